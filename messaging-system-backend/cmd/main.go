@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"messaging-system-backend/internal/controllers"
 	"messaging-system-backend/internal/database"
 	"messaging-system-backend/internal/handlers"
 	"messaging-system-backend/internal/middleware"
+	"messaging-system-backend/internal/storage"
+	"messaging-system-backend/internal/workers"
 )
 
 func main() {
@@ -27,6 +32,20 @@ func main() {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 
+	// Initialize attachment storage
+	if err := storage.InitBlobStore(); err != nil {
+		log.Fatalf("Failed to initialize blob store: %v", err)
+	}
+
+	// Background workers
+	go workers.RunThumbnailWorker(context.Background(), 5*time.Second)
+	go workers.RunLinkPreviewWorker(context.Background(), 2*time.Second)
+	leader := workers.NewRedisLeader(database.RedisClient, "leader:scheduled-messages", 30*time.Second)
+	go workers.NewScheduledDispatcher(controllers.ScheduledStore{}, leader, time.Now).Run(context.Background(), 5*time.Second)
+	go workers.RunExpiryReaper(context.Background(), time.Minute)
+	go workers.RunIdempotencyCleanup(context.Background(), time.Hour)
+	go workers.RunSyncCompactor(context.Background(), time.Hour)
+
 	// Aunthentication routes
 	http.HandleFunc("/register", handlers.RegisterHandler)
 	http.HandleFunc("/login", handlers.LoginHandler)
@@ -45,18 +64,84 @@ func main() {
 	http.HandleFunc("/group/remove-member", handlers.RemoveMemberFromGroup)
 	http.HandleFunc("/group/promote", handlers.PromoteMemberToAdmin)
 	http.HandleFunc("/group/demote", handlers.DemoteAdminToMember)
+	http.HandleFunc("/groups/{id}/slow-mode", handlers.SlowModeHandler)
 
 	//Chat previews and messages
 	http.HandleFunc("/chats/latest-dm-previews", handlers.ViewLatestUserChats)
 	http.HandleFunc("/chats/latest-group-previews", handlers.ViewLatestGroups)
 	http.HandleFunc("/chats/messages", handlers.ViewChatMessages)
 
+	// Conversation routes
+	http.HandleFunc("/conversations", handlers.ConversationsHandler)
+	http.HandleFunc("/conversations/{id}/messages", handlers.ConversationMessagesHandler)
+	http.HandleFunc("/conversations/{id}/state", handlers.ConversationStateHandler)
+	http.HandleFunc("/conversations/{id}/draft", handlers.ConversationDraftHandler)
+
+	// Folder routes
+	http.HandleFunc("/folders", handlers.ChatFoldersHandler)
+	http.HandleFunc("/folders/order", handlers.FolderOrderHandler)
+	http.HandleFunc("/folders/{id}", handlers.ChatFolderHandler)
+	http.HandleFunc("/folders/{id}/conversations", handlers.FolderConversationsHandler)
+
 	//Group messages summary
 	http.HandleFunc("/groups/summary", handlers.GetGroupSummary)
 
 	// Edit message routes
 	http.HandleFunc("/edit/direct", handlers.EditDirectMessageHandler)
 	http.HandleFunc("/edit/group", handlers.EditGroupMessageHandler)
+	http.HandleFunc("/messages/{id}/history", handlers.MessageHistoryHandler)
+	http.HandleFunc("/messages/{id}/reactions", handlers.ReactionHandler)
+
+	// Pin and delete routes
+	http.HandleFunc("/messages/{id}", handlers.DeleteMessageHandler)
+	http.HandleFunc("/messages/{id}/pin", handlers.PinHandler)
+	http.HandleFunc("/chats/pins", handlers.PinnedMessagesHandler)
+
+	// Forwarding routes
+	http.HandleFunc("/messages/forward", handlers.ForwardMessagesHandler)
+	http.HandleFunc("/user/privacy", handlers.PrivacySettingsHandler)
+
+	// Scheduled message routes
+	http.HandleFunc("/scheduled", handlers.ScheduledMessagesHandler)
+	http.HandleFunc("/scheduled/{id}", handlers.ScheduledMessageHandler)
+
+	// Disappearing message routes
+	http.HandleFunc("/chats/disappearing", handlers.DisappearingSettingsHandler)
+
+	// Key directory routes
+	http.HandleFunc("/keys/devices", handlers.DevicesHandler)
+	http.HandleFunc("/keys/devices/{id}", handlers.DeviceHandler)
+	http.HandleFunc("/keys/devices/{id}/prekeys", handlers.DevicePrekeysHandler)
+	http.HandleFunc("/keys/users/{id}/bundle", handlers.PrekeyBundleHandler)
+
+	// Search routes
+	http.HandleFunc("/search/messages", handlers.SearchMessagesHandler)
+
+	// Offline sync routes
+	http.HandleFunc("/sync", handlers.SyncHandler)
+
+	// Attachment download routes
+	http.HandleFunc("/attachments/{id}/url", handlers.AttachmentURLHandler)
+	http.HandleFunc("/attachments/{id}/download", handlers.DownloadAttachmentHandler)
+
+	// Group thread routes
+	http.HandleFunc("/threads/{id}", handlers.ViewThreadHandler)
+	http.HandleFunc("/threads/{id}/follow", handlers.FollowThreadHandler)
+	http.HandleFunc("/threads/{id}/unfollow", handlers.UnfollowThreadHandler)
+
+	// Poll routes
+	http.HandleFunc("/polls", handlers.CreatePollHandler)
+	http.HandleFunc("/polls/{id}", handlers.PollHandler)
+	http.HandleFunc("/polls/{id}/vote", handlers.VotePollHandler)
+	http.HandleFunc("/polls/{id}/close", handlers.ClosePollHandler)
+
+	// Mention routes
+	http.HandleFunc("/me/mentions", handlers.MentionsFeedHandler)
+	http.HandleFunc("/me/mentions/read", handlers.MarkMentionsReadHandler)
+
+	// Saved message routes
+	http.HandleFunc("/messages/{id}/save", handlers.SaveMessageHandler)
+	http.HandleFunc("/me/saved", handlers.SavedMessagesHandler)
 
 	//status of users 
 	http.HandleFunc("/user/status", handlers.GetUserStatusHandler)
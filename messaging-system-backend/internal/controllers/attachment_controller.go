@@ -0,0 +1,393 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/imaging"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/storage"
+
+	"github.com/lib/pq"
+)
+
+const (
+	maxAttachmentSize        = 25 << 20 // per file
+	maxAttachmentsPerMessage = 10
+	maxMultipartRequestSize  = maxAttachmentsPerMessage*maxAttachmentSize + 1<<20
+	multipartMemory          = 8 << 20 // larger parts are buffered on disk
+	attachmentURLTTL         = 5 * time.Minute
+)
+
+// allowedAttachmentTypes lists the sniffed content types (or type prefixes)
+// accepted for upload. Files sniffed as application/octet-stream are
+// rejected, since that covers executables and anything else unrecognised.
+var allowedAttachmentTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"application/pdf",
+	"application/zip",
+	"text/plain",
+}
+
+var (
+	// ErrAttachmentTooLarge is returned when a file exceeds the per-file size limit
+	ErrAttachmentTooLarge = fmt.Errorf("attachments must be %d MB or smaller", maxAttachmentSize>>20)
+	// ErrTooManyAttachments is returned when a message carries more files than allowed
+	ErrTooManyAttachments = fmt.Errorf("a message can carry at most %d attachments", maxAttachmentsPerMessage)
+	// ErrUnsupportedAttachment is returned when the sniffed content type is not allowed
+	ErrUnsupportedAttachment = errors.New("unsupported attachment type")
+	// ErrInvalidAttachmentURL is returned when a download link is forged or expired
+	ErrInvalidAttachmentURL = errors.New("download link is invalid or has expired")
+)
+
+// storedAttachment is an uploaded blob waiting to be linked to a message
+type storedAttachment struct {
+	StorageKey  string
+	Filename    string
+	ContentType string
+	SizeBytes   int64
+	// ProcessingStatus is "pending" for images queued for thumbnail generation
+	ProcessingStatus string
+}
+
+// processedImageTypes are the image formats the thumbnail worker can decode
+var processedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// decodeMessageRequest reads a send request into dst. Plain JSON bodies are
+// decoded directly; multipart/form-data requests carry the same JSON in the
+// "message" field and the files in "attachments" parts.
+func decodeMessageRequest(w http.ResponseWriter, r *http.Request, dst interface{}) ([]*multipart.FileHeader, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return nil, json.NewDecoder(r.Body).Decode(dst)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMultipartRequestSize)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("message")), dst); err != nil {
+		return nil, err
+	}
+	return r.MultipartForm.File["attachments"], nil
+}
+
+// uploadAttachments validates the files and writes them to the blob store.
+// On error nothing is left behind in the store.
+func uploadAttachments(files []*multipart.FileHeader) ([]storedAttachment, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	if len(files) > maxAttachmentsPerMessage {
+		return nil, ErrTooManyAttachments
+	}
+	for _, fh := range files {
+		if fh.Size > maxAttachmentSize {
+			return nil, ErrAttachmentTooLarge
+		}
+	}
+
+	var stored []storedAttachment
+	for _, fh := range files {
+		att, err := uploadAttachment(fh)
+		if err != nil {
+			discardAttachments(stored)
+			return nil, err
+		}
+		stored = append(stored, att)
+	}
+	return stored, nil
+}
+
+// uploadAttachment sniffs the content type of one file and stores it
+func uploadAttachment(fh *multipart.FileHeader) (storedAttachment, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return storedAttachment{}, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return storedAttachment{}, err
+	}
+	contentType := sniffContentType(head[:n])
+	if !attachmentTypeAllowed(contentType) {
+		return storedAttachment{}, ErrUnsupportedAttachment
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return storedAttachment{}, err
+	}
+
+	att := storedAttachment{
+		Filename:         sanitizeFilename(fh.Filename),
+		ContentType:      contentType,
+		SizeBytes:        fh.Size,
+		ProcessingStatus: "none",
+	}
+	var body io.Reader = f
+
+	// Location and camera metadata is removed before the original is stored,
+	// so it never becomes downloadable
+	if processedImageTypes[contentType] {
+		data, err := io.ReadAll(io.LimitReader(f, maxAttachmentSize))
+		if err != nil {
+			return storedAttachment{}, err
+		}
+		data, err = imaging.StripMetadata(data, contentType)
+		if err != nil {
+			return storedAttachment{}, ErrUnsupportedAttachment
+		}
+		body = bytes.NewReader(data)
+		att.SizeBytes = int64(len(data))
+		att.ProcessingStatus = "pending"
+	}
+
+	att.StorageKey, err = newStorageKey()
+	if err != nil {
+		return storedAttachment{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := storage.Blobs.Put(ctx, att.StorageKey, body, att.SizeBytes, contentType); err != nil {
+		return storedAttachment{}, fmt.Errorf("failed to store attachment: %w", err)
+	}
+	return att, nil
+}
+
+// linkAttachments records the uploaded blobs as attachments of a message
+func linkAttachments(tx *sql.Tx, chatType string, messageID int, uploaderID int, stored []storedAttachment) error {
+	for _, att := range stored {
+		_, err := tx.Exec(`
+			INSERT INTO attachments (message_type, message_id, uploader_id, storage_key, filename, content_type, size_bytes, processing_status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, chatType, messageID, uploaderID, att.StorageKey, att.Filename, att.ContentType, att.SizeBytes, att.ProcessingStatus)
+		if err != nil {
+			return fmt.Errorf("failed to save attachment: %w", err)
+		}
+	}
+	return nil
+}
+
+// discardAttachments removes blobs that were uploaded for a message that was never saved
+func discardAttachments(stored []storedAttachment) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, att := range stored {
+		if err := storage.Blobs.Delete(ctx, att.StorageKey); err != nil {
+			log.Printf("Failed to discard attachment %s: %v", att.StorageKey, err)
+		}
+	}
+}
+
+// attachAttachmentMetadata loads the attachments of every message in one query
+func attachAttachmentMetadata(chatType string, messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT a.message_id, a.id, a.filename, a.content_type, a.size_bytes,
+		       a.width, a.height, COALESCE(a.blurhash, ''), a.created_at,
+		       COALESCE((
+		           SELECT json_agg(json_build_object('size', t.size, 'width', t.width, 'height', t.height) ORDER BY t.size)
+		           FROM attachment_thumbnails t
+		           WHERE t.attachment_id = a.id
+		       ), '[]') AS thumbnails
+		FROM attachments a
+		WHERE a.message_type = $1 AND a.message_id = ANY($2)
+		ORDER BY a.message_id, a.id
+	`, chatType, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	attachments := make(map[int][]models.Attachment)
+	for rows.Next() {
+		var messageID int
+		var att models.Attachment
+		var thumbnails []byte
+		if err := rows.Scan(
+			&messageID, &att.ID, &att.Filename, &att.ContentType, &att.SizeBytes,
+			&att.Width, &att.Height, &att.Blurhash, &att.CreatedAt, &thumbnails,
+		); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(thumbnails, &att.Thumbnails); err != nil {
+			return err
+		}
+		attachments[messageID] = append(attachments[messageID], att)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].ID]
+	}
+	return nil
+}
+
+// authorizeAttachment loads an attachment and checks the user can read its conversation
+func authorizeAttachment(attachmentID int, userID int) (models.Attachment, string, error) {
+	var att models.Attachment
+	var chatType, storageKey string
+	var messageID int
+	err := database.DB.QueryRow(`
+		SELECT id, message_type, message_id, storage_key, filename, content_type, size_bytes, created_at
+		FROM attachments WHERE id = $1
+	`, attachmentID).Scan(
+		&att.ID, &chatType, &messageID, &storageKey,
+		&att.Filename, &att.ContentType, &att.SizeBytes, &att.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return att, "", ErrMessageNotFound
+	} else if err != nil {
+		return att, "", err
+	}
+
+	if _, err := authorizeMessage(chatType, messageID, userID); err != nil {
+		return att, "", err
+	}
+	return att, storageKey, nil
+}
+
+// GetAttachmentURL issues a short-lived signed download link for a conversation
+// participant. A non-zero size links to that thumbnail instead of the original.
+func GetAttachmentURL(attachmentID int, userID int, size int) (*models.AttachmentURL, error) {
+	if _, _, err := authorizeAttachment(attachmentID, userID); err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(attachmentURLTTL).Truncate(time.Second)
+	sig := signAttachmentURL(attachmentID, userID, size, expiresAt.Unix())
+
+	url := fmt.Sprintf("/attachments/%d/download?uid=%d&exp=%d&sig=%s", attachmentID, userID, expiresAt.Unix(), sig)
+	if size > 0 {
+		url += fmt.Sprintf("&size=%d", size)
+	}
+	return &models.AttachmentURL{URL: url, ExpiresAt: expiresAt}, nil
+}
+
+// OpenSignedAttachment verifies a signed download link, re-checks that the
+// user it was issued to can still read the conversation, and opens the blob
+// of the original file or of the requested thumbnail size
+func OpenSignedAttachment(attachmentID int, userID int, size int, expires int64, sig string) (io.ReadCloser, models.Attachment, error) {
+	expected := signAttachmentURL(attachmentID, userID, size, expires)
+	if !hmac.Equal([]byte(expected), []byte(sig)) || time.Now().Unix() > expires {
+		return nil, models.Attachment{}, ErrInvalidAttachmentURL
+	}
+
+	att, storageKey, err := authorizeAttachment(attachmentID, userID)
+	if err != nil {
+		return nil, att, err
+	}
+
+	if size > 0 {
+		err = database.DB.QueryRow(`
+			SELECT storage_key, content_type, size_bytes
+			FROM attachment_thumbnails
+			WHERE attachment_id = $1 AND size = $2
+		`, attachmentID, size).Scan(&storageKey, &att.ContentType, &att.SizeBytes)
+		if err == sql.ErrNoRows {
+			return nil, att, ErrMessageNotFound
+		} else if err != nil {
+			return nil, att, err
+		}
+		att.Filename = fmt.Sprintf("thumbnail-%d-%s", size, att.Filename)
+	}
+
+	body, err := storage.Blobs.Get(context.Background(), storageKey)
+	if err != nil {
+		return nil, att, err
+	}
+	return body, att, nil
+}
+
+// signAttachmentURL computes the signature binding an attachment, a thumbnail
+// size (0 for the original), a user and an expiry time
+func signAttachmentURL(attachmentID int, userID int, size int, expires int64) string {
+	secret := os.Getenv("ATTACHMENT_URL_SECRET")
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET_KEY")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d:%d:%d:%d", attachmentID, size, userID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sniffContentType detects the media type from the first bytes of a file,
+// ignoring whatever the client claimed
+func sniffContentType(head []byte) string {
+	contentType := http.DetectContentType(head)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType
+}
+
+// attachmentTypeAllowed reports whether a sniffed content type may be uploaded
+func attachmentTypeAllowed(contentType string) bool {
+	for _, allowed := range allowedAttachmentTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(contentType, allowed) {
+			return true
+		}
+		if contentType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// newStorageKey returns a random, date-partitioned blob key
+func newStorageKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("2006/01/02") + "/" + hex.EncodeToString(b), nil
+}
+
+// sanitizeFilename keeps only the base name of an uploaded file
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		return "attachment"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
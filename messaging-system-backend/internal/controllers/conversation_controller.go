@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+)
+
+// ErrConversationNotFound is returned for a conversation ID that does not exist
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// conversationListLimit caps how many conversations GET /conversations returns
+const conversationListLimit = 50
+
+// conversation identifies the DM or group a message belongs to. ID is the
+// conversation ID, which for groups is also the group ID.
+type conversation struct {
+	ID         int
+	ChatType   string
+	GroupID    int
+	SenderID   int
+	ReceiverID int
+}
+
+// loadMessageConversation looks up the conversation of a DM or group message.
+// A message of the other chat type is reported as not found.
+func loadMessageConversation(chatType string, messageID int) (conversation, error) {
+	if chatType != "dm" && chatType != "group" {
+		return conversation{ChatType: chatType}, ErrInvalidChatType
+	}
+
+	var c conversation
+	err := database.DB.QueryRow(`
+		SELECT c.id, c.type, m.sender_id,
+		       CASE WHEN c.dm_user_low = m.sender_id THEN COALESCE(c.dm_user_high, 0) ELSE COALESCE(c.dm_user_low, 0) END
+		FROM conversation_messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = $1
+	`, messageID).Scan(&c.ID, &c.ChatType, &c.SenderID, &c.ReceiverID)
+	if err == sql.ErrNoRows || (err == nil && c.ChatType != chatType) {
+		return conversation{ChatType: chatType}, ErrMessageNotFound
+	}
+	if c.ChatType == "group" {
+		c.GroupID, c.ReceiverID = c.ID, 0
+	}
+	return c, err
+}
+
+// loadConversation looks up a conversation by ID. A DM conversation has its
+// two users as SenderID and ReceiverID.
+func loadConversation(conversationID int) (conversation, error) {
+	c := conversation{ID: conversationID}
+	var low, high sql.NullInt64
+	err := database.DB.QueryRow(`
+		SELECT type, dm_user_low, dm_user_high FROM conversations WHERE id = $1
+	`, conversationID).Scan(&c.ChatType, &low, &high)
+	if err == sql.ErrNoRows {
+		return c, ErrConversationNotFound
+	}
+	if c.ChatType == "group" {
+		c.GroupID = c.ID
+	} else {
+		c.SenderID, c.ReceiverID = int(low.Int64), int(high.Int64)
+	}
+	return c, err
+}
+
+// findDirectConversation returns the ID of the DM conversation between two
+// users, or 0 when they have never exchanged a message
+func findDirectConversation(userA int, userB int) (int, error) {
+	var id int
+	err := database.DB.QueryRow(`
+		SELECT id FROM conversations
+		WHERE type = 'dm' AND dm_user_low = LEAST($1::int, $2::int) AND dm_user_high = GREATEST($1::int, $2::int)
+	`, userA, userB).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// ensureDirectConversation returns the DM conversation between two users,
+// creating it and its members on first use
+func ensureDirectConversation(tx *sql.Tx, userA int, userB int) (conversation, error) {
+	c := conversation{ChatType: "dm", SenderID: userA, ReceiverID: userB}
+	err := tx.QueryRow(`
+		INSERT INTO conversations (type, dm_user_low, dm_user_high)
+		VALUES ('dm', LEAST($1::int, $2::int), GREATEST($1::int, $2::int))
+		ON CONFLICT (dm_user_low, dm_user_high) WHERE type = 'dm' DO UPDATE SET type = EXCLUDED.type
+		RETURNING id
+	`, userA, userB).Scan(&c.ID)
+	if err != nil {
+		return c, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id)
+		SELECT DISTINCT $1::int, UNNEST(ARRAY[$2::int, $3::int])
+		ON CONFLICT DO NOTHING
+	`, c.ID, userA, userB)
+	return c, err
+}
+
+// isConversationMember reports whether a user belongs to a conversation
+func isConversationMember(conversationID int, userID int) (bool, error) {
+	var exists bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM conversation_members
+			WHERE conversation_id = $1 AND user_id = $2
+		)
+	`, conversationID, userID).Scan(&exists)
+	return exists, err
+}
+
+// hasParticipant reports whether a user may read the conversation. DMs are
+// limited to the two users; groups use the current group membership.
+func (c conversation) hasParticipant(userID int) (bool, error) {
+	if c.ChatType == "group" {
+		return isGroupMember(c.GroupID, userID)
+	}
+	return userID == c.SenderID || userID == c.ReceiverID, nil
+}
+
+// channel returns the Redis channel used to publish events for the conversation
+func (c conversation) channel() string {
+	if c.ChatType == "group" {
+		return events.GroupChannel(c.GroupID)
+	}
+	return events.DMChannel(c.SenderID, c.ReceiverID)
+}
+
+// authorizeMessage loads a message's conversation and checks the user can access it
+func authorizeMessage(chatType string, messageID int, userID int) (conversation, error) {
+	c, err := loadMessageConversation(chatType, messageID)
+	if err != nil {
+		return c, err
+	}
+	ok, err := c.hasParticipant(userID)
+	if err != nil {
+		return c, err
+	}
+	if !ok {
+		return c, ErrNotParticipant
+	}
+	return c, nil
+}
+
+// newMessage is a message about to be stored in a conversation
+type newMessage struct {
+	SenderID        int
+	Kind            string
+	Content         string
+	Entities        *string
+	ReplyToID       *int
+	ThreadRootID    *int
+	Forwarded       bool
+	ForwardedFromID *int
+}
+
+// insertMessage stores a message in a conversation, starts its disappearing
+// timer, unarchives the DM and records it in the members' sync logs. Callers handle the
+// feature-specific rows such as attachments and mentions.
+func insertMessage(tx *sql.Tx, c conversation, msg newMessage) (int, time.Time, error) {
+	if msg.Kind == "" {
+		msg.Kind = "text"
+	}
+
+	var id int
+	var createdAt time.Time
+	err := tx.QueryRow(`
+		INSERT INTO conversation_messages
+			(conversation_id, sender_id, kind, content, entities, reply_to_id, thread_root_id, forwarded, forwarded_from_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`, c.ID, msg.SenderID, msg.Kind, msg.Content, msg.Entities, msg.ReplyToID, msg.ThreadRootID,
+		msg.Forwarded, msg.ForwardedFromID).Scan(&id, &createdAt)
+	if err != nil {
+		return 0, createdAt, err
+	}
+	if err := applyDisappearingTimer(tx, id); err != nil {
+		return 0, createdAt, err
+	}
+	if err := unarchiveOnNewMessage(tx, c); err != nil {
+		return 0, createdAt, err
+	}
+
+	if c.ChatType == "dm" && c.ReceiverID == msg.SenderID {
+		c.ReceiverID = c.SenderID
+	}
+	c.SenderID = msg.SenderID
+	if err := recordMessageEvent(tx, "message.created", c, id); err != nil {
+		return 0, createdAt, err
+	}
+	return id, createdAt, nil
+}
+
+// GetConversations lists the user's DM and group conversations, pinned ones
+// first and then most recently active. Archived conversations are left out
+// unless includeArchived is set.
+func GetConversations(userID int, includeArchived bool) ([]models.ConversationPreview, error) {
+	return listConversations(userID, previewFilter{IncludeArchived: includeArchived})
+}
+
+// listConversations returns the inbox rows matching filter as conversation previews
+func listConversations(userID int, filter previewFilter) ([]models.ConversationPreview, error) {
+	rows, err := loadConversationPreviews(userID, filter, conversationListLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]models.ConversationPreview, 0, len(rows))
+	for _, row := range rows {
+		conversations = append(conversations, row.ConversationPreview)
+	}
+	return conversations, nil
+}
+
+// GetConversationMessages retrieves the latest top-level messages of a
+// conversation the user belongs to
+func GetConversationMessages(conversationID int, userID int) ([]models.ChatMessage, error) {
+	c, err := loadConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return conversationMessages(c, userID)
+}
+
+// conversationMessages loads the latest top-level messages of a conversation
+// with everything the chat view shows alongside them
+func conversationMessages(c conversation, userID int) ([]models.ChatMessage, error) {
+	member, err := isConversationMember(c.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, ErrNotParticipant
+	}
+
+	// Groups report the reader's status as receiver_status, DMs the status of
+	// whoever received each message
+	rows, err := database.DB.Query(`
+		SELECT m.id, m.sender_id, m.kind, m.content, m.created_at,
+		       su.status AS sender_status,
+		       ru.status AS receiver_status,
+		       m.edited, m.edited_at, m.reply_to_id, m.thread_root_id, m.expires_at,
+		       CASE WHEN c.type = 'dm' THEN ru.id ELSE 0 END AS receiver_id
+		FROM conversation_messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		JOIN users su ON su.id = m.sender_id
+		JOIN users ru ON ru.id = CASE
+			WHEN c.type = 'group' THEN $2
+			WHEN c.dm_user_low = m.sender_id THEN c.dm_user_high
+			ELSE c.dm_user_low
+		END
+		WHERE m.conversation_id = $1 AND m.thread_root_id IS NULL
+		  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT 10
+	`, c.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.ChatMessage
+	for rows.Next() {
+		var msg models.ChatMessage
+		if err := rows.Scan(
+			&msg.ID, &msg.SenderID, &msg.Kind, &msg.Content, &msg.CreatedAt,
+			&msg.SenderStatus, &msg.ReceiverStatus,
+			&msg.Edited, &msg.EditedAt, &msg.ReplyToID, &msg.ThreadRootID, &msg.ExpiresAt,
+			&msg.ReceiverID,
+		); err != nil {
+			return nil, err
+		}
+		msg.ConversationID = c.ID
+		if c.ChatType == "group" {
+			groupID := c.GroupID
+			msg.GroupID = &groupID
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := attachReplySnippets(messages); err != nil {
+		return nil, err
+	}
+	if err := attachReactions(c.ChatType, messages, userID); err != nil {
+		return nil, err
+	}
+	if err := attachAttachmentMetadata(c.ChatType, messages); err != nil {
+		return nil, err
+	}
+	if err := attachLinkPreviews(c.ChatType, messages); err != nil {
+		return nil, err
+	}
+	if err := attachEntities(messages); err != nil {
+		return nil, err
+	}
+	if err := attachForwardOrigins(messages, userID); err != nil {
+		return nil, err
+	}
+	if err := attachCiphertexts(messages, userID); err != nil {
+		return nil, err
+	}
+	if err := markMessagesRead(c.ChatType, messages, userID); err != nil {
+		return nil, err
+	}
+	if c.ChatType == "group" {
+		if err := attachMentions(messages); err != nil {
+			return nil, err
+		}
+		if err := attachThreadSummaries(messages, userID); err != nil {
+			return nil, err
+		}
+		if err := attachPolls(messages, userID); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+)
+
+// maxPinnedConversations caps how many conversations a user can pin to the
+// top of their inbox
+const maxPinnedConversations = 5
+
+var (
+	// ErrTooManyPinnedConversations is returned when pinning past the cap
+	ErrTooManyPinnedConversations = errors.New("you can pin at most 5 conversations")
+	// ErrInvalidMuteUntil is returned for a mute that would already be over
+	ErrInvalidMuteUntil = errors.New("muted_until must be in the future")
+)
+
+// conversationStateColumns selects a member's state from conversation_members
+// cm. A mute whose muted_until has passed reads as not muted.
+const conversationStateColumns = `
+	cm.archived_at IS NOT NULL,
+	cm.pinned_at IS NOT NULL,
+	cm.muted AND (cm.muted_until IS NULL OR cm.muted_until > NOW()),
+	CASE WHEN cm.muted AND cm.muted_until > NOW() THEN cm.muted_until END`
+
+// scanConversationState returns the destinations for conversationStateColumns
+func scanConversationState(s *models.ConversationState) []interface{} {
+	return []interface{}{&s.Archived, &s.Pinned, &s.Muted, &s.MutedUntil}
+}
+
+// GetConversationState returns the user's archive, mute and pin state for a
+// conversation they belong to
+func GetConversationState(conversationID int, userID int) (*models.ConversationState, error) {
+	if _, err := loadConversation(conversationID); err != nil {
+		return nil, err
+	}
+
+	var state models.ConversationState
+	err := database.DB.QueryRow(`
+		SELECT `+conversationStateColumns+`
+		FROM conversation_members cm
+		WHERE cm.conversation_id = $1 AND cm.user_id = $2
+	`, conversationID, userID).Scan(scanConversationState(&state)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotParticipant
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// UpdateConversationState changes the user's own state for a conversation.
+// Fields left out of the input keep their value. The user's row is locked
+// while pins are counted, so concurrent requests cannot go past the cap.
+func UpdateConversationState(conversationID int, input models.ConversationStateInput, userID int) (*models.ConversationState, error) {
+	unmuting := input.Muted != nil && !*input.Muted
+	if input.MutedUntil != nil && !unmuting && !input.MutedUntil.After(time.Now()) {
+		return nil, ErrInvalidMuteUntil
+	}
+	if input.MutedUntil != nil && input.Muted == nil {
+		muted := true
+		input.Muted = &muted
+	}
+
+	c, err := loadConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+
+	var pinned bool
+	err = tx.QueryRow(`
+		SELECT pinned_at IS NOT NULL FROM conversation_members
+		WHERE conversation_id = $1 AND user_id = $2
+		FOR UPDATE
+	`, conversationID, userID).Scan(&pinned)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotParticipant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Pinned != nil && *input.Pinned && !pinned {
+		var count int
+		if err := tx.QueryRow(`
+			SELECT COUNT(*) FROM conversation_members WHERE user_id = $1 AND pinned_at IS NOT NULL
+		`, userID).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count >= maxPinnedConversations {
+			return nil, ErrTooManyPinnedConversations
+		}
+	}
+
+	var state models.ConversationState
+	err = tx.QueryRow(`
+		UPDATE conversation_members cm
+		SET archived_at = CASE WHEN $3::boolean IS NULL THEN archived_at
+		                       WHEN $3 THEN COALESCE(archived_at, NOW()) END,
+		    pinned_at = CASE WHEN $4::boolean IS NULL THEN pinned_at
+		                     WHEN $4 THEN COALESCE(pinned_at, NOW()) END,
+		    muted = COALESCE($5::boolean, muted),
+		    muted_until = CASE WHEN $5::boolean IS NULL THEN muted_until
+		                       WHEN $5 THEN $6::timestamp END
+		WHERE cm.conversation_id = $1 AND cm.user_id = $2
+		RETURNING `+conversationStateColumns+`
+	`, conversationID, userID, input.Archived, input.Pinned, input.Muted, input.MutedUntil).
+		Scan(scanConversationState(&state)...)
+	if err != nil {
+		return nil, err
+	}
+
+	event := models.Event{
+		Type:     "conversation.state",
+		ChatType: c.ChatType,
+		GroupID:  c.GroupID,
+		UserID:   userID,
+		Data:     map[string]interface{}{"conversation_id": conversationID, "state": state},
+	}
+	if err := recordSyncEvent(tx, []int{userID}, event); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.UserChannel(userID), event)
+	return &state, nil
+}
+
+// unarchiveOnNewMessage brings an archived DM back to its members' inboxes
+// when a message arrives, except for members who have it muted
+func unarchiveOnNewMessage(tx *sql.Tx, c conversation) error {
+	if c.ChatType != "dm" {
+		return nil
+	}
+	_, err := tx.Exec(`
+		UPDATE conversation_members
+		SET archived_at = NULL
+		WHERE conversation_id = $1 AND archived_at IS NOT NULL
+		  AND NOT (muted AND (muted_until IS NULL OR muted_until > NOW()))
+	`, c.ID)
+	return err
+}
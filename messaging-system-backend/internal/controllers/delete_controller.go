@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/storage"
+
+	"github.com/lib/pq"
+)
+
+// ErrDeleteNotAllowed is returned when a user tries to delete someone else's message
+var ErrDeleteNotAllowed = errors.New("only the sender or a group admin can delete this message")
+
+// DeleteMessageForEveryone permanently removes a message for all participants.
+// The sender may delete their own messages and group admins may delete any
+// group message. Deleting a thread root also deletes its replies.
+func DeleteMessageForEveryone(input models.DeleteMessageInput, userID int) (map[string]string, error) {
+	conv, err := authorizeMessage(input.MessageType, input.MessageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if conv.SenderID != userID {
+		allowed := false
+		if conv.ChatType == "group" {
+			if allowed, err = isGroupAdmin(conv.GroupID, userID); err != nil {
+				return nil, err
+			}
+		}
+		if !allowed {
+			return nil, ErrDeleteNotAllowed
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("failed to delete message")
+	}
+	defer tx.Rollback()
+
+	blobKeys, err := purgeMessages(tx, conv.ChatType, []int64{int64(input.MessageID)})
+	if err != nil {
+		return nil, errors.New("failed to delete message")
+	}
+	event := models.Event{
+		Type:      "message.deleted",
+		ChatType:  conv.ChatType,
+		GroupID:   conv.GroupID,
+		MessageID: input.MessageID,
+		UserID:    userID,
+	}
+	if err := recordConversationEvent(tx, conv, event); err != nil {
+		return nil, errors.New("failed to delete message")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("failed to delete message")
+	}
+
+	deleteOrphanedBlobs(blobKeys)
+
+	events.Publish(conv.channel(), event)
+
+	return map[string]string{
+		"message": "Message deleted",
+	}, nil
+}
+
+// purgeMessages hard-deletes messages together with their pins, bookmarks,
+// reactions, receipts, revisions, link previews and attachment rows. Group thread roots take
+// their replies with them, and roots that lose replies get their reply count
+// and last reply time recounted. It returns the storage keys of the removed
+// attachments and thumbnails so the blobs can be cleaned up after commit.
+func purgeMessages(tx *sql.Tx, chatType string, ids []int64) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	if chatType == "group" {
+		rows, err := tx.Query(`
+			SELECT id FROM conversation_messages WHERE thread_root_id = ANY($1) AND NOT id = ANY($1)
+		`, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var roots []int64
+	if chatType == "group" {
+		rows, err := tx.Query(`
+			SELECT DISTINCT thread_root_id FROM conversation_messages
+			WHERE id = ANY($1) AND thread_root_id IS NOT NULL AND NOT thread_root_id = ANY($1)
+		`, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			roots = append(roots, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(`
+		SELECT a.storage_key FROM attachments a
+		WHERE a.message_type = $1 AND a.message_id = ANY($2)
+		UNION
+		SELECT t.storage_key FROM attachment_thumbnails t
+		JOIN attachments a ON a.id = t.attachment_id
+		WHERE a.message_type = $1 AND a.message_id = ANY($2)
+	`, chatType, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"message_pins", "saved_messages", "message_reactions", "message_receipts", "message_revisions", "link_previews", "attachments"} {
+		if _, err := tx.Exec(`
+			DELETE FROM `+table+` WHERE message_type = $1 AND message_id = ANY($2)
+		`, chatType, pq.Array(ids)); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		DELETE FROM conversation_messages WHERE id = ANY($1)
+	`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	if err := recountThreadReplies(tx, roots); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// deleteOrphanedBlobs removes stored blobs that are no longer referenced by
+// any attachment or thumbnail. Failures are logged and leave the blob behind.
+func deleteOrphanedBlobs(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT k FROM UNNEST($1::text[]) AS k
+		WHERE NOT EXISTS (SELECT 1 FROM attachments WHERE storage_key = k)
+		  AND NOT EXISTS (SELECT 1 FROM attachment_thumbnails WHERE storage_key = k)
+	`, pq.Array(keys))
+	if err != nil {
+		log.Printf("Failed to check orphaned blobs: %v", err)
+		return
+	}
+	var orphaned []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err == nil {
+			orphaned = append(orphaned, key)
+		}
+	}
+	rows.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, key := range orphaned {
+		if err := storage.Blobs.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// allowedDisappearSeconds are the timers users can pick: 1 hour, 1 day and 7 days
+var allowedDisappearSeconds = map[int]bool{
+	3600:   true,
+	86400:  true,
+	604800: true,
+}
+
+var (
+	// ErrInvalidDisappearTimer is returned for a timer or start mode that is not offered
+	ErrInvalidDisappearTimer = errors.New("timer must be 0, 3600, 86400 or 604800 seconds, starting from send or read")
+	// ErrDisappearNotAllowed is returned when a group member who is not an admin changes the timer
+	ErrDisappearNotAllowed = errors.New("only group admins can change disappearing messages")
+)
+
+// GetDisappearingSettings returns the disappearing messages timer of a DM or group
+func GetDisappearingSettings(chatType string, chatID int, userID int) (*models.DisappearingSettings, error) {
+	settings := &models.DisappearingSettings{ChatType: chatType, ChatID: chatID, From: "send"}
+
+	var after sql.NullInt64
+	var err error
+	switch chatType {
+	case "dm":
+		err = database.DB.QueryRow(`
+			SELECT disappear_after_seconds, disappear_from FROM direct_chat_settings
+			WHERE user_low = LEAST($1::int, $2::int) AND user_high = GREATEST($1::int, $2::int)
+		`, userID, chatID).Scan(&after, &settings.From)
+	case "group":
+		isMember, merr := isGroupMember(chatID, userID)
+		if merr != nil {
+			return nil, merr
+		}
+		if !isMember {
+			return nil, ErrNotParticipant
+		}
+		err = database.DB.QueryRow(`
+			SELECT disappear_after_seconds, disappear_from FROM groups WHERE id = $1
+		`, chatID).Scan(&after, &settings.From)
+	default:
+		return nil, ErrInvalidChatType
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	settings.AfterSeconds = int(after.Int64)
+	return settings, nil
+}
+
+// UpdateDisappearingSettings changes the timer for new messages in a DM or
+// group. Either DM participant may change it; in groups only admins can.
+// Messages sent before the change keep their previous timer.
+func UpdateDisappearingSettings(input models.DisappearingSettings, userID int) (map[string]string, error) {
+	if input.From == "" {
+		input.From = "send"
+	}
+	if (input.AfterSeconds != 0 && !allowedDisappearSeconds[input.AfterSeconds]) ||
+		(input.From != "send" && input.From != "read") {
+		return nil, ErrInvalidDisappearTimer
+	}
+	var after *int
+	if input.AfterSeconds != 0 {
+		after = &input.AfterSeconds
+	}
+
+	conv := conversation{ChatType: input.ChatType}
+	var err error
+	switch input.ChatType {
+	case "dm":
+		conv.SenderID, conv.ReceiverID = userID, input.ChatID
+		_, err = database.DB.Exec(`
+			INSERT INTO direct_chat_settings (user_low, user_high, disappear_after_seconds, disappear_from, updated_by)
+			VALUES (LEAST($1::int, $2::int), GREATEST($1::int, $2::int), $3, $4, $1)
+			ON CONFLICT (user_low, user_high) DO UPDATE
+			SET disappear_after_seconds = EXCLUDED.disappear_after_seconds,
+			    disappear_from = EXCLUDED.disappear_from,
+			    updated_by = EXCLUDED.updated_by,
+			    updated_at = CURRENT_TIMESTAMP
+		`, userID, input.ChatID, after, input.From)
+	case "group":
+		conv.GroupID = input.ChatID
+		isAdmin, aerr := isGroupAdmin(input.ChatID, userID)
+		if aerr != nil {
+			return nil, aerr
+		}
+		if !isAdmin {
+			return nil, ErrDisappearNotAllowed
+		}
+		_, err = database.DB.Exec(`
+			UPDATE groups SET disappear_after_seconds = $2, disappear_from = $3 WHERE id = $1
+		`, input.ChatID, after, input.From)
+	default:
+		return nil, ErrInvalidChatType
+	}
+	if err != nil {
+		return nil, errors.New("failed to update disappearing messages")
+	}
+
+	events.Publish(conv.channel(), models.Event{
+		Type:     "disappearing.updated",
+		ChatType: input.ChatType,
+		GroupID:  conv.GroupID,
+		UserID:   userID,
+		Data:     map[string]interface{}{"after_seconds": input.AfterSeconds, "from": input.From},
+	})
+
+	return map[string]string{
+		"message": "Disappearing messages updated",
+	}, nil
+}
+
+// applyDisappearingTimer copies the conversation's timer onto a newly sent
+// message. Timers that run from send time get their expiry right away; timers
+// that run from first read get it in markMessagesRead.
+func applyDisappearingTimer(tx *sql.Tx, messageID int) error {
+	_, err := tx.Exec(`
+		UPDATE conversation_messages m
+		SET disappear_after_seconds = s.disappear_after_seconds,
+		    expires_at = CASE WHEN s.disappear_from = 'send'
+		                      THEN m.created_at + s.disappear_after_seconds * INTERVAL '1 second' END
+		FROM (
+			SELECT c.id,
+			       COALESCE(g.disappear_after_seconds, d.disappear_after_seconds) AS disappear_after_seconds,
+			       COALESCE(g.disappear_from, d.disappear_from) AS disappear_from
+			FROM conversations c
+			LEFT JOIN groups g ON c.type = 'group' AND g.id = c.id
+			LEFT JOIN direct_chat_settings d
+			       ON c.type = 'dm' AND d.user_low = c.dm_user_low AND d.user_high = c.dm_user_high
+		) s
+		WHERE m.id = $1 AND s.id = m.conversation_id AND s.disappear_after_seconds IS NOT NULL
+	`, messageID)
+	return err
+}
+
+// markMessagesRead records read receipts for messages the user did not send
+// and starts read-based disappearing timers on their first read
+func markMessagesRead(chatType string, messages []models.ChatMessage, userID int) error {
+	var ids []int64
+	for _, msg := range messages {
+		if msg.SenderID != userID {
+			ids = append(ids, int64(msg.ID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	receipts, err := database.DB.Query(`
+		INSERT INTO message_receipts (message_type, message_id, user_id)
+		SELECT $1, id, $3 FROM UNNEST($2::int[]) AS id
+		ON CONFLICT (message_type, message_id, user_id) DO NOTHING
+		RETURNING message_id
+	`, chatType, pq.Array(ids), userID)
+	if err != nil {
+		return err
+	}
+	newlyRead := make(map[int]bool)
+	for receipts.Next() {
+		var id int
+		if err := receipts.Scan(&id); err != nil {
+			receipts.Close()
+			return err
+		}
+		newlyRead[id] = true
+	}
+	receipts.Close()
+	if err := receipts.Err(); err != nil {
+		return err
+	}
+	if err := recordReadEvents(chatType, messages, newlyRead, userID); err != nil {
+		return err
+	}
+
+	rows, err := database.DB.Query(`
+		UPDATE conversation_messages
+		SET expires_at = CURRENT_TIMESTAMP + disappear_after_seconds * INTERVAL '1 second'
+		WHERE id = ANY($1) AND expires_at IS NULL AND disappear_after_seconds IS NOT NULL
+		RETURNING id, expires_at
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	started := make(map[int]time.Time)
+	for rows.Next() {
+		var id int
+		var expiresAt time.Time
+		if err := rows.Scan(&id, &expiresAt); err != nil {
+			return err
+		}
+		started[id] = expiresAt
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if expiresAt, ok := started[messages[i].ID]; ok {
+			messages[i].ExpiresAt = &expiresAt
+		}
+	}
+	return nil
+}
+
+// PurgeExpiredMessages hard-deletes up to limit expired DMs and up to limit
+// expired group messages, with their attachments, reactions and receipts,
+// and logs a message.deleted event for each so synced clients drop them.
+// It returns how many messages were selected for deletion.
+func PurgeExpiredMessages(ctx context.Context, limit int) (int, error) {
+	total := 0
+	for _, chatType := range []string{"dm", "group"} {
+		tx, err := database.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return total, err
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT m.id, c.id, m.sender_id,
+			       CASE WHEN c.dm_user_low = m.sender_id THEN COALESCE(c.dm_user_high, 0) ELSE COALESCE(c.dm_user_low, 0) END
+			FROM conversation_messages m
+			JOIN conversations c ON c.id = m.conversation_id
+			WHERE c.type = $2 AND m.expires_at <= CURRENT_TIMESTAMP
+			ORDER BY m.expires_at
+			LIMIT $1
+			FOR UPDATE OF m SKIP LOCKED
+		`, limit, chatType)
+		if err != nil {
+			tx.Rollback()
+			return total, err
+		}
+		var ids []int64
+		var convs []conversation
+		for rows.Next() {
+			var id int64
+			conv := conversation{ChatType: chatType}
+			if err := rows.Scan(&id, &conv.ID, &conv.SenderID, &conv.ReceiverID); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return total, err
+			}
+			if chatType == "group" {
+				conv.GroupID, conv.ReceiverID = conv.ID, 0
+			}
+			ids = append(ids, id)
+			convs = append(convs, conv)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			tx.Rollback()
+			return total, err
+		}
+
+		keys, err := purgeMessages(tx, chatType, ids)
+		if err != nil {
+			tx.Rollback()
+			return total, err
+		}
+		deleted := make([]models.Event, len(ids))
+		for i, conv := range convs {
+			deleted[i] = models.Event{
+				Type:      "message.deleted",
+				ChatType:  chatType,
+				GroupID:   conv.GroupID,
+				MessageID: int(ids[i]),
+			}
+			if err := recordConversationEvent(tx, conv, deleted[i]); err != nil {
+				tx.Rollback()
+				return total, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return total, err
+		}
+
+		deleteOrphanedBlobs(keys)
+		for i, conv := range convs {
+			events.Publish(conv.channel(), deleted[i])
+		}
+		total += len(ids)
+	}
+	return total, nil
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+)
+
+const (
+	// maxDraftLength is the longest draft in characters
+	maxDraftLength = 10000
+	// maxDraftClockSkew is how far ahead of the server a client's draft
+	// timestamp may be. A device with a clock far in the future would
+	// otherwise win every later save.
+	maxDraftClockSkew = 5 * time.Minute
+)
+
+// ErrInvalidDraft is returned for a draft that is too long or has no usable client timestamp
+var ErrInvalidDraft = errors.New("a draft needs client_updated_at no later than now and at most 10000 characters")
+
+// GetDraft returns the user's draft in a conversation, or nil when there is none
+func GetDraft(conversationID int, userID int) (*models.Draft, error) {
+	if err := authorizeDraft(conversationID, userID); err != nil {
+		return nil, err
+	}
+	return loadDraft(database.DB, conversationID, userID)
+}
+
+// SaveDraft stores the user's draft in a conversation. The save with the
+// latest client timestamp wins: an older save leaves the stored draft as it
+// is, and the stored draft is returned either way so the client can catch up.
+// A cleared draft is kept as an empty row so a stale save cannot bring it back.
+func SaveDraft(conversationID int, input models.DraftInput, userID int) (*models.Draft, error) {
+	if input.ClientUpdatedAt.IsZero() || input.ClientUpdatedAt.After(time.Now().Add(maxDraftClockSkew)) ||
+		utf8.RuneCountInString(input.Content) > maxDraftLength {
+		return nil, ErrInvalidDraft
+	}
+	if err := authorizeDraft(conversationID, userID); err != nil {
+		return nil, err
+	}
+	if input.ReplyToID != nil {
+		if err := validateReply(*input.ReplyToID, conversationID); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO message_drafts (user_id, conversation_id, content, reply_to_id, client_updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, conversation_id) DO UPDATE
+		SET content = EXCLUDED.content,
+		    reply_to_id = EXCLUDED.reply_to_id,
+		    client_updated_at = EXCLUDED.client_updated_at,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE message_drafts.client_updated_at < EXCLUDED.client_updated_at
+	`, userID, conversationID, input.Content, input.ReplyToID, input.ClientUpdatedAt.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	draft, err := loadDraft(tx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	applied, _ := res.RowsAffected()
+	if applied == 0 {
+		return draft, tx.Commit()
+	}
+
+	event := draftEvent(conversationID, userID, draft)
+	if err := recordSyncEvent(tx, []int{userID}, event); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.UserChannel(userID), event)
+	return draft, nil
+}
+
+// clearDraft empties the sender's draft in a conversation once they send a
+// message there. The cleared draft keeps the later of its own timestamp and
+// now, so a save the client made before sending does not bring it back.
+func clearDraft(tx *sql.Tx, conversationID int, userID int) error {
+	res, err := tx.Exec(`
+		UPDATE message_drafts
+		SET content = '', reply_to_id = NULL,
+		    client_updated_at = GREATEST(client_updated_at, CURRENT_TIMESTAMP),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND conversation_id = $2 AND (content <> '' OR reply_to_id IS NOT NULL)
+	`, userID, conversationID)
+	if err != nil {
+		return err
+	}
+	if cleared, _ := res.RowsAffected(); cleared == 0 {
+		return nil
+	}
+	return recordSyncEvent(tx, []int{userID}, draftEvent(conversationID, userID, nil))
+}
+
+// authorizeDraft checks the conversation exists and the user belongs to it
+func authorizeDraft(conversationID int, userID int) error {
+	if _, err := loadConversation(conversationID); err != nil {
+		return err
+	}
+	member, err := isConversationMember(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return ErrNotParticipant
+	}
+	return nil
+}
+
+// loadDraft reads the user's draft in a conversation. A missing or cleared
+// draft is returned as nil.
+func loadDraft(q dbRunner, conversationID int, userID int) (*models.Draft, error) {
+	rows, err := q.Query(`
+		SELECT content, reply_to_id, client_updated_at FROM message_drafts
+		WHERE user_id = $1 AND conversation_id = $2 AND (content <> '' OR reply_to_id IS NOT NULL)
+	`, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	draft := &models.Draft{ConversationID: conversationID}
+	if err := rows.Scan(&draft.Content, &draft.ReplyToID, &draft.ClientUpdatedAt); err != nil {
+		return nil, err
+	}
+	return draft, rows.Err()
+}
+
+// draftEvent describes a changed draft for the user's other devices. A nil
+// draft means it was cleared.
+func draftEvent(conversationID int, userID int, draft *models.Draft) models.Event {
+	return models.Event{
+		Type:   "draft.updated",
+		UserID: userID,
+		Data:   map[string]interface{}{"conversation_id": conversationID, "draft": draft},
+	}
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -8,30 +10,90 @@ import (
 	"messaging-system-backend/internal/models"
 )
 
+var (
+	// ErrMessageNotFound is returned when a referenced message does not exist
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrNotParticipant is returned when the caller is not allowed to see a conversation
+	ErrNotParticipant = errors.New("you are not a participant in this conversation")
+	// ErrInvalidChatType is returned for chat types other than "dm" and "group"
+	ErrInvalidChatType = errors.New("invalid chat type")
+)
+
 // EditDirectMessage allows a user to edit a direct message
 func EditDirectMessage(input models.EditMessageInput, userID int) error {
-	var existing models.Message
-
-	err := database.DB.QueryRow(`
-		SELECT id, sender_id, content, updated_at, created_at
-		FROM messages
-		WHERE id = $1`, input.MessageID).Scan(
-		&existing.ID,
-		&existing.SenderID,
+	return editMessage("dm", input, userID)
+}
+
+// EditGroupMessage allows a user to edit a message in a group chat
+func EditGroupMessage(input models.EditMessageInput, userID int) error {
+	return editMessage("group", input, userID)
+}
+
+// editMessage replaces the content of the user's own message within an hour
+// of sending it. LastUpdatedAt must match the stored version so concurrent
+// edits are detected instead of overwritten. A group message's mentions are
+// resolved again, and users mentioned for the first time are notified. Link
+// previews are replaced with those of the new text.
+func editMessage(chatType string, input models.EditMessageInput, userID int) error {
+	label := "message"
+	if chatType == "group" {
+		label = "group message"
+	}
+
+	content, entities, err := formatContent(input.NewContent)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing struct {
+		Kind      string
+		Content   string
+		UpdatedAt time.Time
+		CreatedAt time.Time
+	}
+	conv := conversation{ChatType: chatType}
+	err = tx.QueryRow(`
+		SELECT c.id, m.sender_id,
+		       CASE WHEN c.dm_user_low = m.sender_id THEN COALESCE(c.dm_user_high, 0) ELSE COALESCE(c.dm_user_low, 0) END,
+		       m.kind, m.content, m.updated_at, m.created_at
+		FROM conversation_messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = $1 AND c.type = $2
+		FOR UPDATE OF m`, input.MessageID, chatType).Scan(
+		&conv.ID,
+		&conv.SenderID,
+		&conv.ReceiverID,
+		&existing.Kind,
 		&existing.Content,
 		&existing.UpdatedAt,
 		&existing.CreatedAt,
 	)
 	if err != nil {
-		return fmt.Errorf("message not found")
+		return fmt.Errorf("%s not found", label)
+	}
+	if chatType == "group" {
+		conv.GroupID, conv.ReceiverID = conv.ID, 0
 	}
 
-	if existing.SenderID != userID {
+	if conv.SenderID != userID {
 		return fmt.Errorf("you can only edit your own messages")
 	}
 
+	if existing.Kind == "poll" {
+		return ErrPollNotEditable
+	}
+	if existing.Kind == "encrypted" {
+		return ErrEncryptedMessage
+	}
+
 	if time.Since(existing.CreatedAt) > time.Hour {
-		return fmt.Errorf("message can no longer be edited")
+		return fmt.Errorf("%s can no longer be edited", label)
 	}
 
 	inputTime := input.LastUpdatedAt.UTC().Truncate(time.Second)
@@ -41,59 +103,112 @@ func EditDirectMessage(input models.EditMessageInput, userID int) error {
 		return fmt.Errorf("conflict detected, please refresh the message")
 	}
 
-	_, err = database.DB.Exec(`
-		UPDATE messages
-		SET content = $1, updated_at = CURRENT_TIMESTAMP
+	var resolved resolvedMentions
+	if chatType == "group" {
+		resolved, err = resolveMentions(conv.GroupID, userID, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := recordRevision(tx, chatType, input.MessageID, existing.Content, userID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE conversation_messages
+		SET content = $1, entities = $3, updated_at = CURRENT_TIMESTAMP,
+		    edited = TRUE, edited_at = CURRENT_TIMESTAMP
 		WHERE id = $2
-	`, input.NewContent, input.MessageID)
+	`, content, input.MessageID, entities)
+	if err != nil {
+		return err
+	}
 
-	return err
-}
+	if err := replaceLinkPreviews(tx, chatType, input.MessageID, input.NewContent); err != nil {
+		return err
+	}
 
-// EditGroupMessage allows a user to edit a message in a group chat
-func EditGroupMessage(input models.EditMessageInput, userID int) error {
-	var existing struct {
-		ID        int
-		SenderID  int
-		Content   string
-		UpdatedAt time.Time
-		CreatedAt time.Time
+	if chatType == "group" {
+		resolved, err = replaceMentions(tx, input.MessageID, resolved)
+		if err != nil {
+			return err
+		}
 	}
 
-	err := database.DB.QueryRow(`
-		SELECT id, sender_id, content, updated_at, created_at
-		FROM group_messages
-		WHERE id = $1`, input.MessageID).Scan(
-		&existing.ID,
-		&existing.SenderID,
-		&existing.Content,
-		&existing.UpdatedAt,
-		&existing.CreatedAt,
-	)
-	if err != nil {
-		return fmt.Errorf("group message not found")
+	if err := recordMessageEvent(tx, "message.edited", conv, input.MessageID); err != nil {
+		return err
 	}
 
-	if existing.SenderID != userID {
-		return fmt.Errorf("you can only edit your own messages")
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
-	if time.Since(existing.CreatedAt) > time.Hour {
-		return fmt.Errorf("group message can no longer be edited")
+	if chatType == "group" {
+		publishMentionEvents(conv.GroupID, input.MessageID, userID, resolved)
 	}
+	return nil
+}
 
-	inputTime := input.LastUpdatedAt.UTC().Truncate(time.Second)
-	dbTime := existing.UpdatedAt.UTC().Truncate(time.Second)
+// recordRevision stores the content a message had before an edit
+func recordRevision(tx *sql.Tx, messageType string, messageID int, content string, editedBy int) error {
+	_, err := tx.Exec(`
+		INSERT INTO message_revisions (message_type, message_id, content, edited_by)
+		VALUES ($1, $2, $3, $4)
+	`, messageType, messageID, content, editedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save message revision: %w", err)
+	}
+	return nil
+}
 
-	if !inputTime.Equal(dbTime) {
-		return fmt.Errorf("conflict detected, please refresh the message")
+// GetMessageHistory returns the previous versions of a message, oldest first.
+// DM history is visible to both participants and group history to everyone
+// currently in the group, admins included.
+func GetMessageHistory(chatType string, messageID int, userID int) ([]models.MessageRevision, error) {
+	conv, err := loadMessageConversation(chatType, messageID)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = database.DB.Exec(`
-		UPDATE group_messages
-		SET content = $1, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $2
-	`, input.NewContent, input.MessageID)
+	if chatType == "dm" {
+		if userID != conv.SenderID && userID != conv.ReceiverID {
+			return nil, ErrNotParticipant
+		}
+	} else {
+		isMember, err := isGroupMember(conv.GroupID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, ErrNotParticipant
+		}
+	}
 
-	return err
+	rows, err := database.DB.Query(`
+		SELECT id, message_type, message_id, content, edited_by, created_at
+		FROM message_revisions r
+		WHERE message_type = $1 AND message_id = $2
+		  AND NOT EXISTS (
+		      SELECT 1 FROM conversation_messages m WHERE m.id = r.message_id AND m.expires_at <= NOW()
+		  )
+		ORDER BY created_at, id
+	`, chatType, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.MessageRevision
+	for rows.Next() {
+		var rev models.MessageRevision
+		if err := rows.Scan(
+			&rev.ID, &rev.MessageType, &rev.MessageID,
+			&rev.Content, &rev.EditedBy, &rev.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
 }
@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// maxChatFolders caps how many folders a user can create
+	maxChatFolders = 10
+	// maxFolderNameLength is the longest folder name in characters
+	maxFolderNameLength = 32
+	// maxFolderConversations caps the included and the excluded conversation IDs of a folder
+	maxFolderConversations = 100
+)
+
+var (
+	// ErrInvalidFolder is returned for a folder without a usable name or rules
+	ErrInvalidFolder = errors.New("a folder needs a name of at most 32 characters and at least one rule or included conversation")
+	// ErrFolderNotFound is returned for a folder that does not exist or belongs to another user
+	ErrFolderNotFound = errors.New("folder not found")
+	// ErrTooManyFolders is returned when creating a folder past the cap
+	ErrTooManyFolders = errors.New("you can have at most 10 folders")
+	// ErrInvalidFolderOrder is returned when a new order does not list each of the user's folders once
+	ErrInvalidFolderOrder = errors.New("folder_ids must list each of your folders once")
+)
+
+// folderMatchSQL matches a conversation of the inbox query against the
+// folder f. Exclusions win over inclusions, and the rules only apply when
+// the folder has at least one.
+const folderMatchSQL = `
+	f.id IS NOT NULL
+	AND NOT c.id = ANY(f.exclude_ids)
+	AND (
+		c.id = ANY(f.include_ids)
+		OR (
+			(cardinality(f.types) > 0 OR f.unread IS NOT NULL OR f.muted IS NOT NULL OR f.admin_only)
+			AND (cardinality(f.types) = 0 OR c.type = ANY(f.types))
+			AND (f.unread IS NULL OR f.unread = u.unread)
+			AND (f.muted IS NULL OR f.muted = (cm.muted AND (cm.muted_until IS NULL OR cm.muted_until > NOW())))
+			AND (NOT f.admin_only OR cm.role = 'admin')
+		)
+	)`
+
+// folderColumns are the chat_folders columns scanned by scanFolder
+const folderColumns = `id, name, position, types, unread, muted, admin_only, include_ids, exclude_ids, created_at, updated_at`
+
+// scanFolder reads a row selected with folderColumns
+func scanFolder(row interface{ Scan(...interface{}) error }) (models.ChatFolder, error) {
+	var folder models.ChatFolder
+	var include, exclude []int64
+	err := row.Scan(
+		&folder.ID, &folder.Name, &folder.Position, pq.Array(&folder.Types), &folder.Unread, &folder.Muted,
+		&folder.AdminOnly, pq.Array(&include), pq.Array(&exclude), &folder.CreatedAt, &folder.UpdatedAt,
+	)
+	if folder.Types == nil {
+		folder.Types = []string{}
+	}
+	folder.IncludeIDs = intsFromInt64s(include)
+	folder.ExcludeIDs = intsFromInt64s(exclude)
+	return folder, err
+}
+
+// intsFromInt64s converts IDs scanned from an INT[] column
+func intsFromInt64s(ids []int64) []int {
+	out := make([]int, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, int(id))
+	}
+	return out
+}
+
+// uniqueIDs drops repeated IDs, keeping the first occurrence of each
+func uniqueIDs(ids []int) []int64 {
+	seen := make(map[int]bool, len(ids))
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, int64(id))
+		}
+	}
+	return out
+}
+
+// normalizeFolderInput trims and validates a folder and removes repeated
+// types and conversation IDs
+func normalizeFolderInput(input *models.ChatFolderInput) error {
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || utf8.RuneCountInString(input.Name) > maxFolderNameLength {
+		return ErrInvalidFolder
+	}
+
+	types := []string{}
+	seen := make(map[string]bool)
+	for _, t := range input.Types {
+		if t != "dm" && t != "group" {
+			return ErrInvalidChatType
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	input.Types = types
+
+	if len(input.IncludeIDs) > maxFolderConversations || len(input.ExcludeIDs) > maxFolderConversations {
+		return ErrInvalidFolder
+	}
+	hasRules := len(input.Types) > 0 || input.Unread != nil || input.Muted != nil || input.AdminOnly
+	if !hasRules && len(input.IncludeIDs) == 0 {
+		return ErrInvalidFolder
+	}
+	return nil
+}
+
+// CreateFolder adds a folder after the user's existing ones. The user's row
+// is locked while folders are counted, so concurrent requests cannot go past
+// the cap.
+func CreateFolder(input models.ChatFolderInput, userID int) (*models.ChatFolder, error) {
+	if err := normalizeFolderInput(&input); err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+
+	var count, nextPosition int
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(MAX(position) + 1, 0) FROM chat_folders WHERE user_id = $1
+	`, userID).Scan(&count, &nextPosition)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxChatFolders {
+		return nil, ErrTooManyFolders
+	}
+
+	folder, err := scanFolder(tx.QueryRow(`
+		INSERT INTO chat_folders (user_id, name, position, types, unread, muted, admin_only, include_ids, exclude_ids)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+folderColumns,
+		userID, input.Name, nextPosition, pq.Array(input.Types), input.Unread, input.Muted,
+		input.AdminOnly, pq.Array(uniqueIDs(input.IncludeIDs)), pq.Array(uniqueIDs(input.ExcludeIDs)),
+	))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// GetFolders lists the user's folders in their display order
+func GetFolders(userID int) ([]models.ChatFolder, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+folderColumns+`
+		FROM chat_folders
+		WHERE user_id = $1
+		ORDER BY position, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []models.ChatFolder{}
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// GetFolder returns one of the user's folders
+func GetFolder(folderID int, userID int) (*models.ChatFolder, error) {
+	folder, err := scanFolder(database.DB.QueryRow(`
+		SELECT `+folderColumns+` FROM chat_folders WHERE id = $1 AND user_id = $2
+	`, folderID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// UpdateFolder replaces the name and rules of one of the user's folders.
+// Its position is kept.
+func UpdateFolder(folderID int, input models.ChatFolderInput, userID int) (*models.ChatFolder, error) {
+	if err := normalizeFolderInput(&input); err != nil {
+		return nil, err
+	}
+
+	folder, err := scanFolder(database.DB.QueryRow(`
+		UPDATE chat_folders
+		SET name = $3, types = $4, unread = $5, muted = $6, admin_only = $7,
+		    include_ids = $8, exclude_ids = $9, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2
+		RETURNING `+folderColumns,
+		folderID, userID, input.Name, pq.Array(input.Types), input.Unread, input.Muted,
+		input.AdminOnly, pq.Array(uniqueIDs(input.IncludeIDs)), pq.Array(uniqueIDs(input.ExcludeIDs)),
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// DeleteFolder removes one of the user's folders. The conversations in it
+// are not affected.
+func DeleteFolder(folderID int, userID int) (map[string]string, error) {
+	res, err := database.DB.Exec(`
+		DELETE FROM chat_folders WHERE id = $1 AND user_id = $2
+	`, folderID, userID)
+	if err != nil {
+		return nil, errors.New("failed to delete folder")
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, ErrFolderNotFound
+	}
+
+	return map[string]string{
+		"message": "Folder deleted",
+	}, nil
+}
+
+// ReorderFolders sets the display order of the user's folders. The input
+// must list every folder of the user exactly once.
+func ReorderFolders(input models.FolderOrderInput, userID int) ([]models.ChatFolder, error) {
+	ids := uniqueIDs(input.FolderIDs)
+	if len(ids) == 0 || len(ids) != len(input.FolderIDs) {
+		return nil, ErrInvalidFolderOrder
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var total int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM (SELECT id FROM chat_folders WHERE user_id = $1 FOR UPDATE) f
+	`, userID).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`
+		UPDATE chat_folders f
+		SET position = o.ord - 1, updated_at = CURRENT_TIMESTAMP
+		FROM UNNEST($2::int[]) WITH ORDINALITY AS o(id, ord)
+		WHERE f.id = o.id AND f.user_id = $1
+	`, userID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := res.RowsAffected(); int(affected) != len(ids) || total != len(ids) {
+		return nil, ErrInvalidFolderOrder
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetFolders(userID)
+}
+
+// GetFolderConversations lists the user's conversations that match one of
+// their folders, in inbox order. The rules are evaluated in the inbox query.
+func GetFolderConversations(folderID int, userID int, includeArchived bool) ([]models.ConversationPreview, error) {
+	if _, err := GetFolder(folderID, userID); err != nil {
+		return nil, err
+	}
+	return listConversations(userID, previewFilter{IncludeArchived: includeArchived, FolderID: folderID})
+}
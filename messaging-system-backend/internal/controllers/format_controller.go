@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/richtext"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUnsafeLink is returned when a formatted link does not use http, https or mailto
+	ErrUnsafeLink = richtext.ErrUnsafeLink
+	// ErrTooMuchFormatting is returned when a message has too many formatted spans
+	ErrTooMuchFormatting = richtext.ErrTooManyEntities
+)
+
+// formatContent parses the markdown subset in message content. It returns
+// the plain text to store as content and the entity list as JSON, or nil
+// (stored as NULL) when the message has no formatting.
+func formatContent(content string) (string, *string, error) {
+	text, parsed, err := richtext.Parse(content)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(parsed) == 0 {
+		return text, nil, nil
+	}
+
+	entities := make([]models.TextEntity, len(parsed))
+	for i, e := range parsed {
+		entities[i] = models.TextEntity{
+			Type:     e.Type,
+			Offset:   e.Offset,
+			Length:   e.Length,
+			URL:      e.URL,
+			Language: e.Language,
+			Number:   e.Number,
+		}
+	}
+	data, err := json.Marshal(entities)
+	if err != nil {
+		return "", nil, err
+	}
+	encoded := string(data)
+	return text, &encoded, nil
+}
+
+// attachEntities loads the formatting entities of messages in one query
+func attachEntities(messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, entities FROM conversation_messages
+		WHERE id = ANY($1) AND entities IS NOT NULL
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byMessage := make(map[int][]models.TextEntity)
+	for rows.Next() {
+		var id int
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return err
+		}
+		var entities []models.TextEntity
+		if err := json.Unmarshal(data, &entities); err != nil {
+			return err
+		}
+		byMessage[id] = entities
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Entities = byMessage[messages[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/spam"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// maxForwardDestinations caps how many conversations one request can forward to
+	maxForwardDestinations = 5
+	// maxForwardMessages caps how many messages one request can forward
+	maxForwardMessages = 20
+)
+
+var (
+	// ErrInvalidForward is returned when a forward request has no messages or destinations
+	ErrInvalidForward = errors.New("forward needs at least one message and one destination")
+	// ErrTooManyForwardDestinations is returned when a forward exceeds the destination limit
+	ErrTooManyForwardDestinations = errors.New("too many forward destinations")
+	// ErrTooManyForwardMessages is returned when a forward exceeds the message limit
+	ErrTooManyForwardMessages = errors.New("too many messages to forward")
+	// ErrInvalidForwardDestination is returned when a destination does not exist or the user cannot post there
+	ErrInvalidForwardDestination = errors.New("cannot forward to this conversation")
+	// ErrInvalidPrivacySetting is returned for an unknown privacy value
+	ErrInvalidPrivacySetting = errors.New("forward_attribution must be everyone or nobody")
+)
+
+// forwardSource is a message being forwarded and the author it is attributed to
+type forwardSource struct {
+	ID       int
+	Kind     string
+	Content  string
+	Entities *string
+	AuthorID *int
+}
+
+// ForwardMessages copies one or more messages the user can read into other
+// DMs and groups they can post to. Copies keep the original author as
+// forwarded_from attribution, and attachments are shared by reference.
+func ForwardMessages(input models.ForwardInput, userID int) (*models.ForwardResult, error) {
+	if len(input.MessageIDs) == 0 || len(input.Destinations) == 0 {
+		return nil, ErrInvalidForward
+	}
+	if len(input.Destinations) > maxForwardDestinations {
+		return nil, ErrTooManyForwardDestinations
+	}
+	if len(input.MessageIDs) > maxForwardMessages {
+		return nil, ErrTooManyForwardMessages
+	}
+
+	sources, err := loadForwardSources(input.SourceType, input.MessageIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	destinations := dedupeDestinations(input.Destinations)
+	var chatKeys []string
+	var groupIDs []int
+	for _, dest := range destinations {
+		if err := authorizeForwardDestination(dest, userID); err != nil {
+			return nil, err
+		}
+		if dest.Type == "dm" {
+			chatKeys = append(chatKeys, fmt.Sprintf("dm:%d:%d", min(userID, dest.ID), max(userID, dest.ID)))
+		} else {
+			chatKeys = append(chatKeys, fmt.Sprintf("group:%d", dest.ID))
+			groupIDs = append(groupIDs, dest.ID)
+		}
+	}
+	// Each copy counts as a message sent to its destination
+	if err := checkSendLimits(userID, len(sources), groupIDs, chatKeys...); err != nil {
+		return nil, err
+	}
+
+	// Copies go through the spam checks like messages sent to the destination
+	screenings := make([][]spam.Result, len(destinations))
+	for i, dest := range destinations {
+		recipient, firstContact := fmt.Sprintf("group:%d", dest.ID), false
+		if dest.Type == "dm" {
+			conversationID, err := findDirectConversation(userID, dest.ID)
+			if err != nil {
+				return nil, err
+			}
+			recipient, firstContact = fmt.Sprintf("dm:%d", dest.ID), conversationID == 0
+		}
+		for _, src := range sources {
+			result, err := checkSpam(context.Background(), userID, recipient, src.Content, firstContact)
+			if err != nil {
+				return nil, err
+			}
+			if result.Verdict == spam.Reject {
+				return nil, &SpamRejectedError{Result: result}
+			}
+			screenings[i] = append(screenings[i], result)
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("failed to forward messages")
+	}
+	defer tx.Rollback()
+
+	result := &models.ForwardResult{}
+	for i, dest := range destinations {
+		conv := conversation{ID: dest.ID, ChatType: "group", GroupID: dest.ID}
+		if dest.Type == "dm" {
+			conv, err = ensureDirectConversation(tx, userID, dest.ID)
+			if err != nil {
+				return nil, errors.New("failed to forward messages")
+			}
+		}
+
+		for j, src := range sources {
+			newID, _, err := insertMessage(tx, conv, newMessage{
+				SenderID:        userID,
+				Content:         src.Content,
+				Entities:        src.Entities,
+				Forwarded:       true,
+				ForwardedFromID: src.AuthorID,
+			})
+			if err != nil {
+				return nil, errors.New("failed to forward messages")
+			}
+			if err := queueForModeration(tx, newID, userID, screenings[i][j]); err != nil {
+				return nil, errors.New("failed to forward messages")
+			}
+			if err := copyAttachments(tx, input.SourceType, src.ID, dest.Type, newID); err != nil {
+				return nil, errors.New("failed to forward messages")
+			}
+			result.Messages = append(result.Messages, models.ForwardedMessage{
+				SourceMessageID: src.ID,
+				Destination:     dest,
+				MessageID:       newID,
+			})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("failed to forward messages")
+	}
+	for _, groupID := range groupIDs {
+		startSlowMode(context.Background(), groupID, userID)
+	}
+	return result, nil
+}
+
+// loadForwardSources loads the messages to forward in send order, checking
+// that the user can read every one of them. Forwarding a forwarded message
+// keeps the attribution to its original author. Encrypted messages and polls
+// cannot be forwarded.
+func loadForwardSources(chatType string, messageIDs []int, userID int) ([]forwardSource, error) {
+	for _, id := range messageIDs {
+		if _, err := authorizeMessage(chatType, id, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]int64, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = int64(id)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, kind, content, entities::text,
+		       CASE WHEN forwarded THEN forwarded_from_id ELSE sender_id END
+		FROM conversation_messages
+		WHERE id = ANY($1)
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at, id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []forwardSource
+	for rows.Next() {
+		var src forwardSource
+		if err := rows.Scan(&src.ID, &src.Kind, &src.Content, &src.Entities, &src.AuthorID); err != nil {
+			return nil, err
+		}
+		// The ciphertexts are only readable by the original recipients
+		if src.Kind == "encrypted" {
+			return nil, ErrEncryptedMessage
+		}
+		// A copy would carry the question without the poll
+		if src.Kind == "poll" {
+			return nil, ErrPollNotForwardable
+		}
+		sources = append(sources, src)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Expired messages the reaper has not removed yet cannot be forwarded
+	if len(sources) != len(uniqueIDs(messageIDs)) {
+		return nil, ErrMessageNotFound
+	}
+	return sources, nil
+}
+
+// dedupeDestinations drops repeated destinations while keeping their order
+func dedupeDestinations(destinations []models.ForwardDestination) []models.ForwardDestination {
+	seen := make(map[models.ForwardDestination]bool)
+	var unique []models.ForwardDestination
+	for _, dest := range destinations {
+		if !seen[dest] {
+			seen[dest] = true
+			unique = append(unique, dest)
+		}
+	}
+	return unique
+}
+
+// authorizeForwardDestination checks that the user can post to the destination
+func authorizeForwardDestination(dest models.ForwardDestination, userID int) error {
+	switch dest.Type {
+	case "dm":
+		var exists bool
+		err := database.DB.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)
+		`, dest.ID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrInvalidForwardDestination
+		}
+	case "group":
+		isMember, err := isGroupMember(dest.ID, userID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrInvalidForwardDestination
+		}
+	default:
+		return ErrInvalidChatType
+	}
+	return nil
+}
+
+// copyAttachments links the attachments and thumbnails of a message to a new
+// message. The copies point at the same stored blobs.
+func copyAttachments(tx *sql.Tx, fromType string, fromID int, toType string, toID int) error {
+	rows, err := tx.Query(`
+		SELECT id FROM attachments WHERE message_type = $1 AND message_id = $2 ORDER BY id
+	`, fromType, fromID)
+	if err != nil {
+		return err
+	}
+	var attachmentIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		attachmentIDs = append(attachmentIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range attachmentIDs {
+		var newID int
+		err := tx.QueryRow(`
+			INSERT INTO attachments (message_type, message_id, uploader_id, storage_key, filename, content_type,
+			                         size_bytes, width, height, blurhash, processing_status)
+			SELECT $2, $3, uploader_id, storage_key, filename, content_type,
+			       size_bytes, width, height, blurhash, processing_status
+			FROM attachments WHERE id = $1
+			RETURNING id
+		`, id, toType, toID).Scan(&newID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO attachment_thumbnails (attachment_id, size, storage_key, content_type, width, height, size_bytes)
+			SELECT $2, size, storage_key, content_type, width, height, size_bytes
+			FROM attachment_thumbnails WHERE attachment_id = $1
+		`, id, newID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachForwardOrigins loads the forwarded_from attribution of forwarded
+// messages. Authors who hide forward attribution are shown as hidden to
+// everyone except themselves.
+func attachForwardOrigins(messages []models.ChatMessage, userID int) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT m.id, u.id, COALESCE(u.username, ''),
+		       u.id IS NULL OR (u.forward_attribution = 'nobody' AND u.id <> $2)
+		FROM conversation_messages m
+		LEFT JOIN users u ON u.id = m.forwarded_from_id
+		WHERE m.id = ANY($1) AND m.forwarded
+	`, pq.Array(ids), userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	origins := make(map[int]*models.ForwardOrigin)
+	for rows.Next() {
+		var messageID int
+		origin := &models.ForwardOrigin{}
+		if err := rows.Scan(&messageID, &origin.UserID, &origin.Username, &origin.Hidden); err != nil {
+			return err
+		}
+		if origin.Hidden {
+			origin.UserID, origin.Username = nil, ""
+		}
+		origins[messageID] = origin
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].ForwardedFrom = origins[messages[i].ID]
+	}
+	return nil
+}
+
+// GetPrivacySettings returns the user's privacy settings
+func GetPrivacySettings(userID int) (*models.PrivacySettings, error) {
+	settings := &models.PrivacySettings{}
+	err := database.DB.QueryRow(`
+		SELECT forward_attribution FROM users WHERE id = $1
+	`, userID).Scan(&settings.ForwardAttribution)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdatePrivacySettings changes the user's privacy settings. The forward
+// attribution setting also applies to messages forwarded in the past.
+func UpdatePrivacySettings(userID int, settings models.PrivacySettings) (map[string]string, error) {
+	if settings.ForwardAttribution != "everyone" && settings.ForwardAttribution != "nobody" {
+		return nil, ErrInvalidPrivacySetting
+	}
+
+	_, err := database.DB.Exec(`
+		UPDATE users SET forward_attribution = $2 WHERE id = $1
+	`, userID, settings.ForwardAttribution)
+	if err != nil {
+		return nil, errors.New("failed to update privacy settings")
+	}
+
+	return map[string]string{
+		"message": "Privacy settings updated",
+	}, nil
+}
@@ -28,28 +28,56 @@ func CreateGroup(input models.CreateGroupInput, creatorID int) (map[string]inter
 		return nil, errors.New("group creator must be a member of the group")
 	}
 
-	// Create group
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("error creating group")
+	}
+	defer tx.Rollback()
+
+	// A group's conversation shares its ID
 	var groupID int
-	err := database.DB.QueryRow(`
-		INSERT INTO groups (name) VALUES ($1) RETURNING id
-	`, input.Name).Scan(&groupID)
+	err = tx.QueryRow(`
+		INSERT INTO conversations (type) VALUES ('group') RETURNING id
+	`).Scan(&groupID)
+	if err == nil {
+		_, err = tx.Exec(`
+			INSERT INTO groups (id, name) VALUES ($1, $2)
+		`, groupID, input.Name)
+	}
 	if err != nil {
 		return nil, errors.New("error creating group")
 	}
 
 	// Add members
 	for _, memberID := range input.Members {
-		isAdmin := (memberID == creatorID)
+		role := "member"
+		if memberID == creatorID {
+			role = "admin"
+		}
 
-		_, err := database.DB.Exec(`
-			INSERT INTO group_members (group_id, user_id, is_admin)
+		_, err := tx.Exec(`
+			INSERT INTO conversation_members (conversation_id, user_id, role)
 			VALUES ($1, $2, $3)
-		`, groupID, memberID, isAdmin)
+		`, groupID, memberID, role)
 		if err != nil {
 			return nil, errors.New("error adding members to group")
 		}
 	}
 
+	err = recordConversationEvent(tx, conversation{ID: groupID, ChatType: "group", GroupID: groupID}, models.Event{
+		Type:     "group.created",
+		ChatType: "group",
+		GroupID:  groupID,
+		UserID:   creatorID,
+		Data:     map[string]string{"name": input.Name},
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, errors.New("error creating group")
+	}
+
 	return map[string]interface{}{
 		"message":  "Group created",
 		"group_id": groupID,
@@ -60,8 +88,8 @@ func CreateGroup(input models.CreateGroupInput, creatorID int) (map[string]inter
 func AddMemberToGroup(input models.AddGroupMemberInput, requesterID int) (map[string]string, error) {
 	var isAdmin bool
 	err := database.DB.QueryRow(`
-        SELECT is_admin FROM group_members
-        WHERE group_id = $1 AND user_id = $2
+        SELECT role = 'admin' FROM conversation_members
+        WHERE conversation_id = $1 AND user_id = $2
     `, input.GroupID, requesterID).Scan(&isAdmin)
 
 	if err == sql.ErrNoRows || !isAdmin {
@@ -72,7 +100,7 @@ func AddMemberToGroup(input models.AddGroupMemberInput, requesterID int) (map[st
 
 	var count int
 	err = database.DB.QueryRow(`
-        SELECT COUNT(*) FROM group_members WHERE group_id = $1
+        SELECT COUNT(*) FROM conversation_members WHERE conversation_id = $1
     `, input.GroupID).Scan(&count)
 
 	if err != nil {
@@ -82,10 +110,22 @@ func AddMemberToGroup(input models.AddGroupMemberInput, requesterID int) (map[st
 		return nil, errors.New("group already has 25 members")
 	}
 
-	_, err = database.DB.Exec(`
-        INSERT INTO group_members (group_id, user_id, is_admin)
-        VALUES ($1, $2, false)
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("error adding member")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO conversation_members (conversation_id, user_id, role)
+        VALUES ($1, $2, 'member')
     `, input.GroupID, input.UserID)
+	if err == nil {
+		err = recordMembershipEvent(tx, "member.added", input.GroupID, requesterID, input.UserID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		return nil, errors.New("error adding member")
 	}
@@ -100,8 +140,8 @@ func PromoteMemberToAdmin(input models.PromoteMemberInput, requesterID int) (map
 	// Check if requester is an admin
 	var isAdmin bool
 	err := database.DB.QueryRow(`
-        SELECT is_admin FROM group_members 
-        WHERE group_id = $1 AND user_id = $2
+        SELECT role = 'admin' FROM conversation_members 
+        WHERE conversation_id = $1 AND user_id = $2
     `, input.GroupID, requesterID).Scan(&isAdmin)
 	if err != nil || !isAdmin {
 		return nil, errors.New("only admins can promote members")
@@ -110,8 +150,8 @@ func PromoteMemberToAdmin(input models.PromoteMemberInput, requesterID int) (map
 	// Count current admins
 	var adminCount int
 	err = database.DB.QueryRow(`
-        SELECT COUNT(*) FROM group_members 
-        WHERE group_id = $1 AND is_admin = true
+        SELECT COUNT(*) FROM conversation_members
+        WHERE conversation_id = $1 AND role = 'admin'
     `, input.GroupID).Scan(&adminCount)
 	if err != nil {
 		return nil, errors.New("failed to check admin count")
@@ -121,9 +161,15 @@ func PromoteMemberToAdmin(input models.PromoteMemberInput, requesterID int) (map
 	}
 
 	// Promote the member
-	res, err := database.DB.Exec(`
-        UPDATE group_members SET is_admin = true 
-        WHERE group_id = $1 AND user_id = $2
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("failed to promote member")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+        UPDATE conversation_members SET role = 'admin'
+        WHERE conversation_id = $1 AND user_id = $2
     `, input.GroupID, input.UserID)
 	if err != nil {
 		return nil, errors.New("failed to promote member")
@@ -134,6 +180,14 @@ func PromoteMemberToAdmin(input models.PromoteMemberInput, requesterID int) (map
 		return nil, errors.New("member not found in group")
 	}
 
+	err = recordMembershipEvent(tx, "member.promoted", input.GroupID, requesterID, input.UserID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, errors.New("failed to promote member")
+	}
+
 	return map[string]string{
 		"message": "Member promoted to admin",
 	}, nil
@@ -144,8 +198,8 @@ func DemoteAdminToMember(input models.PromoteOrDemoteInput, requesterID int) (ma
 	// Step 1: Verify requester is admin
 	var isAdmin bool
 	err := database.DB.QueryRow(`
-		SELECT is_admin FROM group_members 
-		WHERE group_id = $1 AND user_id = $2
+		SELECT role = 'admin' FROM conversation_members 
+		WHERE conversation_id = $1 AND user_id = $2
 	`, input.GroupID, requesterID).Scan(&isAdmin)
 	if err != nil || !isAdmin {
 		return nil, fmt.Errorf("only admins can demote")
@@ -154,8 +208,8 @@ func DemoteAdminToMember(input models.PromoteOrDemoteInput, requesterID int) (ma
 	// Step 2: Check if target user is admin
 	var isTargetAdmin bool
 	err = database.DB.QueryRow(`
-		SELECT is_admin FROM group_members 
-		WHERE group_id = $1 AND user_id = $2
+		SELECT role = 'admin' FROM conversation_members 
+		WHERE conversation_id = $1 AND user_id = $2
 	`, input.GroupID, input.UserID).Scan(&isTargetAdmin)
 	if err != nil {
 		return nil, fmt.Errorf("target user not found in group")
@@ -170,11 +224,23 @@ func DemoteAdminToMember(input models.PromoteOrDemoteInput, requesterID int) (ma
 	}
 
 	// Step 4: Perform demotion
-	_, err = database.DB.Exec(`
-		UPDATE group_members 
-		SET is_admin = false 
-		WHERE group_id = $1 AND user_id = $2
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to demote user")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE conversation_members
+		SET role = 'member'
+		WHERE conversation_id = $1 AND user_id = $2
 	`, input.GroupID, input.UserID)
+	if err == nil {
+		err = recordMembershipEvent(tx, "member.demoted", input.GroupID, requesterID, input.UserID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to demote user")
 	}
@@ -189,8 +255,8 @@ func RemoveMemberFromGroup(input models.PromoteOrDemoteInput, requesterID int) (
 	// Step 1: Check if requester is admin
 	var isAdmin bool
 	err := database.DB.QueryRow(`
-		SELECT is_admin FROM group_members 
-		WHERE group_id = $1 AND user_id = $2
+		SELECT role = 'admin' FROM conversation_members 
+		WHERE conversation_id = $1 AND user_id = $2
 	`, input.GroupID, requesterID).Scan(&isAdmin)
 	if err != nil || !isAdmin {
 		return nil, fmt.Errorf("only admins can remove members")
@@ -204,8 +270,8 @@ func RemoveMemberFromGroup(input models.PromoteOrDemoteInput, requesterID int) (
 	// Step 3: Optional - Prevent removing another admin
 	var targetIsAdmin bool
 	err = database.DB.QueryRow(`
-		SELECT is_admin FROM group_members 
-		WHERE group_id = $1 AND user_id = $2
+		SELECT role = 'admin' FROM conversation_members 
+		WHERE conversation_id = $1 AND user_id = $2
 	`, input.GroupID, input.UserID).Scan(&targetIsAdmin)
 	if err != nil {
 		return nil, fmt.Errorf("target user not found in group")
@@ -215,10 +281,22 @@ func RemoveMemberFromGroup(input models.PromoteOrDemoteInput, requesterID int) (
 	}
 
 	// Step 4: Remove member
-	_, err = database.DB.Exec(`
-		DELETE FROM group_members 
-		WHERE group_id = $1 AND user_id = $2
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove member")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM conversation_members
+		WHERE conversation_id = $1 AND user_id = $2
 	`, input.GroupID, input.UserID)
+	if err == nil {
+		err = recordMembershipEvent(tx, "member.removed", input.GroupID, requesterID, input.UserID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to remove member")
 	}
@@ -227,3 +305,29 @@ func RemoveMemberFromGroup(input models.PromoteOrDemoteInput, requesterID int) (
 		"message": "Member removed from group",
 	}, nil
 }
+
+// isGroupMember reports whether a user currently belongs to a group
+func isGroupMember(groupID int, userID int) (bool, error) {
+	var exists bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM conversation_members cm
+			JOIN conversations c ON c.id = cm.conversation_id
+			WHERE cm.conversation_id = $1 AND cm.user_id = $2 AND c.type = 'group'
+		)
+	`, groupID, userID).Scan(&exists)
+	return exists, err
+}
+
+// isGroupAdmin reports whether a user is an admin of a group
+func isGroupAdmin(groupID int, userID int) (bool, error) {
+	var isAdmin bool
+	err := database.DB.QueryRow(`
+		SELECT role = 'admin' FROM conversation_members
+		WHERE conversation_id = $1 AND user_id = $2
+	`, groupID, userID).Scan(&isAdmin)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isAdmin, err
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+)
+
+const (
+	// maxIdempotencyKeyLength caps the length of client message IDs
+	maxIdempotencyKeyLength = 128
+
+	defaultIdempotencyWindow = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidIdempotencyKey is returned when a client message ID is too long
+	ErrInvalidIdempotencyKey = errors.New("client_msg_id must be at most 128 characters")
+	// ErrIdempotencyKeyReused is returned when a key is retried against a different conversation
+	ErrIdempotencyKeyReused = errors.New("client_msg_id was already used for another conversation")
+)
+
+// idempotencyWindow is how long a send can be retried with the same key.
+// It is read from IDEMPOTENCY_WINDOW as a Go duration such as "24h".
+func idempotencyWindow() time.Duration {
+	if window, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_WINDOW")); err == nil && window > 0 {
+		return window
+	}
+	return defaultIdempotencyWindow
+}
+
+// idempotencyKey returns the key of a send request. The Idempotency-Key
+// header takes precedence over client_msg_id in the body.
+func idempotencyKey(r *http.Request, clientMsgID string) (string, error) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = clientMsgID
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return "", ErrInvalidIdempotencyKey
+	}
+	return key, nil
+}
+
+// findIdempotentSend returns the message an earlier send with the same key
+// created, or nil when the key is unused or its window has passed
+func findIdempotentSend(senderID int, key string, chatType string, chatID int) (*models.SendResult, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	var messageType string
+	var prevChatID int
+	result := &models.SendResult{Message: "Message sent", ClientMsgID: key, Duplicate: true}
+	err := database.DB.QueryRow(`
+		SELECT message_type, chat_id, message_id, created_at
+		FROM idempotency_keys
+		WHERE sender_id = $1 AND key = $2
+		  AND created_at > CURRENT_TIMESTAMP - $3 * INTERVAL '1 second'
+	`, senderID, key, idempotencyWindow().Seconds()).Scan(&messageType, &prevChatID, &result.ID, &result.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if messageType != chatType || prevChatID != chatID {
+		return nil, ErrIdempotencyKeyReused
+	}
+	return result, nil
+}
+
+// recordIdempotentSend stores the key of a send in the same transaction as
+// the message. It returns false when a concurrent send with the same key got
+// there first; the caller should roll back and replay that send instead.
+func recordIdempotentSend(tx *sql.Tx, senderID int, key string, chatType string, chatID int, messageID int, createdAt time.Time) (bool, error) {
+	if key == "" {
+		return true, nil
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO idempotency_keys (sender_id, key, message_type, chat_id, message_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (sender_id, key) DO UPDATE
+		SET message_type = EXCLUDED.message_type,
+		    chat_id = EXCLUDED.chat_id,
+		    message_id = EXCLUDED.message_id,
+		    created_at = EXCLUDED.created_at
+		WHERE idempotency_keys.created_at <= CURRENT_TIMESTAMP - $7 * INTERVAL '1 second'
+	`, senderID, key, chatType, chatID, messageID, createdAt, idempotencyWindow().Seconds())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// PurgeExpiredIdempotencyKeys deletes keys whose retry window has passed and
+// returns how many were removed
+func PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	res, err := database.DB.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE created_at <= CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'
+	`, idempotencyWindow().Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
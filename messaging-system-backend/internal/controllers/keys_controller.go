@@ -0,0 +1,539 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/ratelimit"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// maxDevices caps how many devices a user can register keys for
+	maxDevices = 5
+	// maxDeviceID is the largest device ID a client can pick
+	maxDeviceID = 65535
+	// maxPrekeysPerUpload caps the one-time prekeys in one request
+	maxPrekeysPerUpload = 100
+	// maxStoredPrekeys caps the unused one-time prekeys kept per device
+	maxStoredPrekeys = 500
+	// maxCiphertextLength is the longest base64 ciphertext for one device
+	maxCiphertextLength = 65536
+)
+
+// defaultPrekeyBundleRate limits how often one user can fetch another's
+// prekey bundles, since every fetch uses up one-time prekeys. It can be
+// changed with RATE_LIMIT_PREKEY_BUNDLE.
+var defaultPrekeyBundleRate = ratelimit.Rate{Burst: 10, Per: time.Hour}
+
+var (
+	// ErrInvalidDeviceKeys is returned for keys that are not base64 public keys and signatures
+	ErrInvalidDeviceKeys = errors.New("keys must be base64 public keys of 32 or 33 bytes with 64-byte signatures")
+	// ErrInvalidDeviceID is returned for a device ID out of range
+	ErrInvalidDeviceID = errors.New("device_id must be between 1 and 65535")
+	// ErrDeviceNotFound is returned for a device the user has not registered
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrTooManyDevices is returned when registering a device past the cap
+	ErrTooManyDevices = errors.New("you can register at most 5 devices")
+	// ErrTooManyPrekeys is returned when an upload has too many one-time prekeys or would store too many
+	ErrTooManyPrekeys = errors.New("upload at most 100 one-time prekeys at a time and keep at most 500")
+	// ErrNoDevices is returned for a prekey bundle of a user without registered devices
+	ErrNoDevices = errors.New("this user has not registered any devices")
+	// ErrInvalidEncryptedMessage is returned for an encrypted message with content, attachments or bad ciphertexts
+	ErrInvalidEncryptedMessage = errors.New("an encrypted message needs base64 ciphertexts and no content or attachments")
+	// ErrStaleDevices is returned when the ciphertexts do not match the registered devices
+	ErrStaleDevices = errors.New("ciphertexts must cover every device of the receiver, refetch the prekey bundles")
+	// ErrEncryptedMessage is returned when editing or forwarding an encrypted message
+	ErrEncryptedMessage = errors.New("encrypted messages cannot be edited or forwarded")
+	// ErrTooManyBundleRequests is returned when a user fetches another user's prekey bundles too often
+	ErrTooManyBundleRequests = errors.New("too many prekey bundle requests for this user, try again later")
+)
+
+// validKey reports whether s is base64 for one of the given sizes in bytes
+func validKey(s string, sizes ...int) bool {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	for _, size := range sizes {
+		if len(raw) == size {
+			return true
+		}
+	}
+	return false
+}
+
+// validPrekeys checks one-time prekeys and rejects repeated key IDs
+func validPrekeys(prekeys []models.Prekey) error {
+	if len(prekeys) > maxPrekeysPerUpload {
+		return ErrTooManyPrekeys
+	}
+	seen := make(map[int]bool, len(prekeys))
+	for _, k := range prekeys {
+		if k.KeyID < 0 || seen[k.KeyID] || !validKey(k.PublicKey, 32, 33) {
+			return ErrInvalidDeviceKeys
+		}
+		seen[k.KeyID] = true
+	}
+	return nil
+}
+
+// insertPrekeys stores one-time prekeys for a device. A key ID the device
+// already uses keeps its stored key.
+func insertPrekeys(tx *sql.Tx, userID int, deviceID int, prekeys []models.Prekey) error {
+	if len(prekeys) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(prekeys))
+	keys := make([]string, len(prekeys))
+	for i, k := range prekeys {
+		ids[i], keys[i] = int64(k.KeyID), k.PublicKey
+	}
+	_, err := tx.Exec(`
+		INSERT INTO one_time_prekeys (user_id, device_id, key_id, public_key)
+		SELECT $1, $2, UNNEST($3::int[]), UNNEST($4::text[])
+		ON CONFLICT DO NOTHING
+	`, userID, deviceID, pq.Array(ids), pq.Array(keys))
+	return err
+}
+
+// countPrekeys returns how many one-time prekeys a device has left
+func countPrekeys(q dbRunner, userID int, deviceID int) (int, error) {
+	rows, err := q.Query(`
+		SELECT COUNT(*) FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2
+	`, userID, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, err
+		}
+	}
+	return count, rows.Err()
+}
+
+// RegisterDevice publishes the keys of one of the user's devices, or rotates
+// the signed prekey of a registered one. A new identity key means the device
+// was reinstalled, so its remaining one-time prekeys are discarded. The
+// user's row is locked while devices are counted, so concurrent requests
+// cannot go past the cap.
+func RegisterDevice(deviceID int, input models.DeviceKeysInput, userID int) (*models.Device, error) {
+	if deviceID < 1 || deviceID > maxDeviceID {
+		return nil, ErrInvalidDeviceID
+	}
+	if !validKey(input.IdentityKey, 32, 33) || input.SignedPrekey.KeyID < 0 ||
+		!validKey(input.SignedPrekey.PublicKey, 32, 33) || !validKey(input.SignedPrekey.Signature, 64) {
+		return nil, ErrInvalidDeviceKeys
+	}
+	if err := validPrekeys(input.OneTimePrekeys); err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+
+	var identityKey string
+	err = tx.QueryRow(`
+		SELECT identity_key FROM user_devices WHERE user_id = $1 AND device_id = $2
+	`, userID, deviceID).Scan(&identityKey)
+	switch {
+	case err == sql.ErrNoRows:
+		var count int
+		if err := tx.QueryRow(`
+			SELECT COUNT(*) FROM user_devices WHERE user_id = $1
+		`, userID).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count >= maxDevices {
+			return nil, ErrTooManyDevices
+		}
+	case err != nil:
+		return nil, err
+	case identityKey != input.IdentityKey:
+		if _, err := tx.Exec(`
+			DELETE FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2
+		`, userID, deviceID); err != nil {
+			return nil, err
+		}
+	}
+
+	device := models.Device{DeviceID: deviceID}
+	err = tx.QueryRow(`
+		INSERT INTO user_devices (user_id, device_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, device_id) DO UPDATE
+		SET identity_key = EXCLUDED.identity_key,
+		    signed_prekey_id = EXCLUDED.signed_prekey_id,
+		    signed_prekey = EXCLUDED.signed_prekey,
+		    signed_prekey_signature = EXCLUDED.signed_prekey_signature,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature, created_at, updated_at
+	`, userID, deviceID, input.IdentityKey, input.SignedPrekey.KeyID, input.SignedPrekey.PublicKey,
+		input.SignedPrekey.Signature).Scan(
+		&device.IdentityKey, &device.SignedPrekey.KeyID, &device.SignedPrekey.PublicKey,
+		&device.SignedPrekey.Signature, &device.CreatedAt, &device.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertPrekeys(tx, userID, deviceID, input.OneTimePrekeys); err != nil {
+		return nil, err
+	}
+	if device.OneTimePrekeysLeft, err = countPrekeys(tx, userID, deviceID); err != nil {
+		return nil, err
+	}
+	if device.OneTimePrekeysLeft > maxStoredPrekeys {
+		return nil, ErrTooManyPrekeys
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// UploadPrekeys adds one-time prekeys to one of the user's devices and
+// returns how many it has left
+func UploadPrekeys(deviceID int, input models.PrekeyUploadInput, userID int) (*models.PrekeyCount, error) {
+	if len(input.OneTimePrekeys) == 0 {
+		return nil, ErrInvalidDeviceKeys
+	}
+	if err := validPrekeys(input.OneTimePrekeys); err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRow(`
+		SELECT 1 FROM user_devices WHERE user_id = $1 AND device_id = $2 FOR UPDATE
+	`, userID, deviceID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertPrekeys(tx, userID, deviceID, input.OneTimePrekeys); err != nil {
+		return nil, err
+	}
+	count, err := countPrekeys(tx, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if count > maxStoredPrekeys {
+		return nil, ErrTooManyPrekeys
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &models.PrekeyCount{DeviceID: deviceID, OneTimePrekeysLeft: count}, nil
+}
+
+// GetDevices lists the user's registered devices with their remaining
+// one-time prekeys, so clients know when to upload more
+func GetDevices(userID int) ([]models.Device, error) {
+	rows, err := database.DB.Query(`
+		SELECT d.device_id, d.identity_key, d.signed_prekey_id, d.signed_prekey, d.signed_prekey_signature,
+		       (SELECT COUNT(*) FROM one_time_prekeys k WHERE k.user_id = d.user_id AND k.device_id = d.device_id),
+		       d.created_at, d.updated_at
+		FROM user_devices d
+		WHERE d.user_id = $1
+		ORDER BY d.device_id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []models.Device{}
+	for rows.Next() {
+		var d models.Device
+		if err := rows.Scan(
+			&d.DeviceID, &d.IdentityKey, &d.SignedPrekey.KeyID, &d.SignedPrekey.PublicKey,
+			&d.SignedPrekey.Signature, &d.OneTimePrekeysLeft, &d.CreatedAt, &d.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// RemoveDevice unregisters one of the user's devices together with its
+// one-time prekeys. Ciphertexts already stored for it are kept.
+func RemoveDevice(deviceID int, userID int) (map[string]string, error) {
+	res, err := database.DB.Exec(`
+		DELETE FROM user_devices WHERE user_id = $1 AND device_id = $2
+	`, userID, deviceID)
+	if err != nil {
+		return nil, errors.New("failed to remove device")
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, ErrDeviceNotFound
+	}
+
+	return map[string]string{
+		"message": "Device removed",
+	}, nil
+}
+
+// GetPrekeyBundles returns a bundle for each of a user's devices. Each bundle
+// takes one of the device's one-time prekeys, which is deleted in the same
+// statement; concurrent requests skip keys another one has locked, so no key
+// is handed out twice. A device without one-time prekeys left is returned
+// with its signed prekey only. Each requester has a limited number of
+// fetches per target, so no one can drain a user's one-time prekeys.
+func GetPrekeyBundles(targetID int, userID int) ([]models.PrekeyBundle, error) {
+	if err := checkPrekeyBundleRate(userID, targetID); err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT device_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature
+		FROM user_devices
+		WHERE user_id = $1
+		ORDER BY device_id
+	`, targetID)
+	if err != nil {
+		return nil, err
+	}
+	bundles := []models.PrekeyBundle{}
+	for rows.Next() {
+		b := models.PrekeyBundle{UserID: targetID}
+		if err := rows.Scan(
+			&b.DeviceID, &b.IdentityKey, &b.SignedPrekey.KeyID, &b.SignedPrekey.PublicKey, &b.SignedPrekey.Signature,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bundles = append(bundles, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(bundles) == 0 {
+		return nil, ErrNoDevices
+	}
+
+	rows, err = tx.Query(`
+		WITH picked AS (
+			SELECT k.device_id, k.key_id
+			FROM user_devices d
+			CROSS JOIN LATERAL (
+				SELECT p.device_id, p.key_id
+				FROM one_time_prekeys p
+				WHERE p.user_id = d.user_id AND p.device_id = d.device_id
+				ORDER BY p.key_id
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			) k
+			WHERE d.user_id = $1
+		)
+		DELETE FROM one_time_prekeys p
+		USING picked
+		WHERE p.user_id = $1 AND p.device_id = picked.device_id AND p.key_id = picked.key_id
+		RETURNING p.device_id, p.key_id, p.public_key
+	`, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prekeys := make(map[int]*models.Prekey)
+	for rows.Next() {
+		var deviceID int
+		var k models.Prekey
+		if err := rows.Scan(&deviceID, &k.KeyID, &k.PublicKey); err != nil {
+			return nil, err
+		}
+		prekeys[deviceID] = &k
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range bundles {
+		bundles[i].OneTimePrekey = prekeys[bundles[i].DeviceID]
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return bundles, nil
+}
+
+// validateCiphertexts checks an encrypted DM before it is stored. It needs a
+// ciphertext for every registered device of the receiver; the sender may add
+// ones for their own other devices. A ciphertext for a device that is not
+// registered means the sender's device list is stale.
+func validateCiphertexts(senderID int, receiverID int, ciphertexts []models.MessageCiphertext) error {
+	if len(ciphertexts) == 0 {
+		return ErrInvalidEncryptedMessage
+	}
+
+	type deviceKey struct{ userID, deviceID int }
+	sent := make(map[deviceKey]bool, len(ciphertexts))
+	for _, c := range ciphertexts {
+		key := deviceKey{c.RecipientID, c.DeviceID}
+		if (c.RecipientID != receiverID && c.RecipientID != senderID) || sent[key] ||
+			c.Ciphertext == "" || len(c.Ciphertext) > maxCiphertextLength {
+			return ErrInvalidEncryptedMessage
+		}
+		if _, err := base64.StdEncoding.DecodeString(c.Ciphertext); err != nil {
+			return ErrInvalidEncryptedMessage
+		}
+		sent[key] = true
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT user_id, device_id FROM user_devices WHERE user_id IN ($1, $2)
+	`, senderID, receiverID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	registered := make(map[deviceKey]bool)
+	receiverDevices := 0
+	for rows.Next() {
+		var key deviceKey
+		if err := rows.Scan(&key.userID, &key.deviceID); err != nil {
+			return err
+		}
+		registered[key] = true
+		if key.userID == receiverID {
+			receiverDevices++
+			if !sent[key] {
+				return ErrStaleDevices
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if receiverDevices == 0 {
+		return ErrNoDevices
+	}
+	for key := range sent {
+		if !registered[key] {
+			return ErrStaleDevices
+		}
+	}
+	return nil
+}
+
+// storeCiphertexts saves the ciphertexts of an encrypted message and marks
+// its conversation as encrypted, which keeps it out of search
+func storeCiphertexts(tx *sql.Tx, conversationID int, messageID int, ciphertexts []models.MessageCiphertext) error {
+	recipients := make([]int64, len(ciphertexts))
+	devices := make([]int64, len(ciphertexts))
+	blobs := make([]string, len(ciphertexts))
+	for i, c := range ciphertexts {
+		recipients[i], devices[i], blobs[i] = int64(c.RecipientID), int64(c.DeviceID), c.Ciphertext
+	}
+	_, err := tx.Exec(`
+		INSERT INTO message_ciphertexts (message_id, recipient_id, device_id, ciphertext)
+		SELECT $1, UNNEST($2::int[]), UNNEST($3::int[]), UNNEST($4::text[])
+	`, messageID, pq.Array(recipients), pq.Array(devices), pq.Array(blobs))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE conversations SET encrypted = TRUE WHERE id = $1 AND NOT encrypted
+	`, conversationID)
+	return err
+}
+
+// attachCiphertexts loads the ciphertexts of encrypted messages addressed to
+// the reader's devices in one pass. Each device picks its own.
+func attachCiphertexts(messages []models.ChatMessage, userID int) error {
+	var ids []int64
+	for _, msg := range messages {
+		if msg.Kind == "encrypted" {
+			ids = append(ids, int64(msg.ID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT message_id, recipient_id, device_id, ciphertext
+		FROM message_ciphertexts
+		WHERE message_id = ANY($1) AND recipient_id = $2
+		ORDER BY message_id, device_id
+	`, pq.Array(ids), userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byMessage := make(map[int][]models.MessageCiphertext)
+	for rows.Next() {
+		var messageID int
+		var c models.MessageCiphertext
+		if err := rows.Scan(&messageID, &c.RecipientID, &c.DeviceID, &c.Ciphertext); err != nil {
+			return err
+		}
+		byMessage[messageID] = append(byMessage[messageID], c)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Ciphertexts = byMessage[messages[i].ID]
+	}
+	return nil
+}
+
+// checkPrekeyBundleRate takes a token from the requester's bucket for the
+// target's bundles. The limit is skipped when Redis is not configured or
+// fails, like the send limits.
+func checkPrekeyBundleRate(userID int, targetID int) error {
+	if database.RedisClient == nil {
+		return nil
+	}
+	wait, err := ratelimit.NewRedisLimiter(database.RedisClient).Allow(context.Background(), ratelimit.Bucket{
+		Key:  fmt.Sprintf("ratelimit:prekeys:%d:%d", userID, targetID),
+		Rate: sendRate("RATE_LIMIT_PREKEY_BUNDLE", defaultPrekeyBundleRate),
+	})
+	if err != nil {
+		log.Printf("prekey bundle rate check failed for user %d: %v", userID, err)
+		return nil
+	}
+	if wait > 0 {
+		return &RateLimitError{Wait: wait, Err: ErrTooManyBundleRequests}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/unfurl"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// maxLinkPreviewsPerMessage caps how many links of one message are unfurled
+	maxLinkPreviewsPerMessage = 3
+
+	linkPreviewCacheTTL       = 24 * time.Hour
+	linkPreviewFailureTTL     = time.Hour
+	linkPreviewStaleAfter     = 5 * time.Minute
+	linkPreviewFetchTimeLimit = 10 * time.Second
+)
+
+// linkPreviewJob is a link waiting to be unfurled
+type linkPreviewJob struct {
+	ID          int
+	MessageType string
+	MessageID   int
+	URL         string
+}
+
+// cachedLinkPreview is the Redis cache entry for a URL. Failed fetches are
+// cached too, for a shorter time, so a broken link is not fetched for every
+// message that contains it.
+type cachedLinkPreview struct {
+	Preview *unfurl.Preview `json:"preview,omitempty"`
+	Failed  bool            `json:"failed,omitempty"`
+}
+
+// queueLinkPreviews records the links in a new message so the link preview
+// worker unfurls them after the message is sent
+func queueLinkPreviews(tx *sql.Tx, chatType string, messageID int, content string) error {
+	urls := unfurl.ExtractURLs(content, maxLinkPreviewsPerMessage)
+	if len(urls) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+		INSERT INTO link_previews (message_type, message_id, position, url)
+		SELECT $1, $2, t.position, t.url
+		FROM UNNEST($3::text[]) WITH ORDINALITY AS t(url, position)
+		ON CONFLICT (message_type, message_id, url) DO NOTHING
+	`, chatType, messageID, pq.Array(urls))
+	return err
+}
+
+// replaceLinkPreviews drops the link previews of an edited message and
+// queues the links in its new text
+func replaceLinkPreviews(tx *sql.Tx, chatType string, messageID int, content string) error {
+	if _, err := tx.Exec(`
+		DELETE FROM link_previews WHERE message_type = $1 AND message_id = $2
+	`, chatType, messageID); err != nil {
+		return err
+	}
+	return queueLinkPreviews(tx, chatType, messageID, content)
+}
+
+// attachLinkPreviews loads the ready link previews of messages in one query
+func attachLinkPreviews(chatType string, messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT message_id, url, COALESCE(title, ''), COALESCE(description, ''),
+		       COALESCE(image_url, ''), COALESCE(site_name, '')
+		FROM link_previews
+		WHERE message_type = $1 AND message_id = ANY($2) AND status = 'ready'
+		ORDER BY message_id, position
+	`, chatType, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byMessage := make(map[int][]models.LinkPreview)
+	for rows.Next() {
+		var messageID int
+		var p models.LinkPreview
+		if err := rows.Scan(&messageID, &p.URL, &p.Title, &p.Description, &p.ImageURL, &p.SiteName); err != nil {
+			return err
+		}
+		byMessage[messageID] = append(byMessage[messageID], p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].LinkPreviews = byMessage[messages[i].ID]
+	}
+	return nil
+}
+
+// ProcessLinkPreviews claims up to limit queued links, unfurls them through
+// the Redis cache and the fetcher, and notifies the conversation of each
+// preview that became ready. Rows are claimed with SKIP LOCKED so several app
+// instances can run it at once. It returns how many links were claimed.
+func ProcessLinkPreviews(ctx context.Context, fetcher *unfurl.Fetcher, limit int) (int, error) {
+	rows, err := database.DB.QueryContext(ctx, `
+		UPDATE link_previews
+		SET status = 'processing', processing_started_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM link_previews
+			WHERE status = 'pending'
+			   OR (status = 'processing' AND processing_started_at < $1)
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, message_type, message_id, url
+	`, time.Now().Add(-linkPreviewStaleAfter), limit)
+	if err != nil {
+		return 0, err
+	}
+	var jobs []linkPreviewJob
+	for rows.Next() {
+		var job linkPreviewJob
+		if err := rows.Scan(&job.ID, &job.MessageType, &job.MessageID, &job.URL); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, job := range jobs {
+		preview := unfurlLink(ctx, fetcher, job.URL)
+		if ctx.Err() != nil {
+			// The claimed rows go back to the queue once they are stale
+			return 0, ctx.Err()
+		}
+		if preview == nil {
+			if _, err := database.DB.ExecContext(ctx, `
+				UPDATE link_previews SET status = 'failed' WHERE id = $1
+			`, job.ID); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		res, err := database.DB.ExecContext(ctx, `
+			UPDATE link_previews
+			SET status = 'ready', title = $2, description = $3, image_url = $4, site_name = $5
+			WHERE id = $1
+		`, job.ID, preview.Title, preview.Description, preview.ImageURL, preview.SiteName)
+		if err != nil {
+			return 0, err
+		}
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			publishLinkPreview(job, preview)
+		}
+	}
+	return len(jobs), nil
+}
+
+// unfurlLink returns the preview of a URL from the cache, or fetches and
+// caches it. It returns nil when the link has no usable preview.
+func unfurlLink(ctx context.Context, fetcher *unfurl.Fetcher, url string) *unfurl.Preview {
+	sum := sha256.Sum256([]byte(url))
+	key := "link_preview:" + hex.EncodeToString(sum[:])
+
+	if database.RedisClient != nil {
+		data, err := database.RedisClient.Get(ctx, key).Bytes()
+		if err == nil {
+			var cached cachedLinkPreview
+			if json.Unmarshal(data, &cached) == nil {
+				if cached.Preview != nil {
+					cached.Preview.URL = url
+				}
+				return cached.Preview
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			log.Printf("Failed to read link preview cache: %v", err)
+		}
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, linkPreviewFetchTimeLimit)
+	defer cancel()
+	preview, err := fetcher.Fetch(fetchCtx, url)
+	if ctx.Err() != nil {
+		// Shutting down: do not cache the failure
+		return nil
+	}
+
+	cached := cachedLinkPreview{Preview: preview, Failed: err != nil}
+	ttl := linkPreviewCacheTTL
+	if err != nil {
+		ttl = linkPreviewFailureTTL
+	}
+	if database.RedisClient != nil {
+		if data, merr := json.Marshal(cached); merr == nil {
+			if err := database.RedisClient.Set(ctx, key, data, ttl).Err(); err != nil {
+				log.Printf("Failed to cache link preview: %v", err)
+			}
+		}
+	}
+	return preview
+}
+
+// publishLinkPreview tells live clients that a message gained a link preview
+func publishLinkPreview(job linkPreviewJob, preview *unfurl.Preview) {
+	conv, err := loadMessageConversation(job.MessageType, job.MessageID)
+	if err != nil {
+		// Deleted in the meantime
+		return
+	}
+	events.Publish(conv.channel(), models.Event{
+		Type:      "message.link_preview",
+		ChatType:  conv.ChatType,
+		GroupID:   conv.GroupID,
+		MessageID: job.MessageID,
+		UserID:    conv.SenderID,
+		Data: models.LinkPreview{
+			URL:         job.URL,
+			Title:       preview.Title,
+			Description: preview.Description,
+			ImageURL:    preview.ImageURL,
+			SiteName:    preview.SiteName,
+		},
+	})
+}
@@ -69,7 +69,9 @@ func CallHuggingFaceSummarizer(messages []string) (string, error) {
 	return summary, nil
 }
 
-// SummarizeGroupMessages retrieves and summarizes the messages in a group
+// SummarizeGroupMessages retrieves and summarizes the messages in a group.
+// Encrypted messages are skipped, and with nothing left to read the summary
+// is empty.
 func SummarizeGroupMessages(groupID int) (map[string]interface{}, error) {
 	// Add context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -77,9 +79,11 @@ func SummarizeGroupMessages(groupID int) (map[string]interface{}, error) {
 
 	rows, err := database.DB.QueryContext(ctx, `
         SELECT u.username, gm.content
-        FROM group_messages gm
+        FROM conversation_messages gm
         JOIN users u ON gm.sender_id = u.id
-        WHERE gm.group_id = $1
+        WHERE gm.conversation_id = $1
+          AND gm.kind <> 'encrypted'
+          AND (gm.expires_at IS NULL OR gm.expires_at > NOW())
         ORDER BY gm.created_at DESC
         LIMIT 20
     `, groupID)
@@ -103,6 +107,13 @@ func SummarizeGroupMessages(groupID int) (map[string]interface{}, error) {
 		return nil, fmt.Errorf("rows iteration error: %v", err)
 	}
 
+	if len(messages) == 0 {
+		return map[string]interface{}{
+			"summary": "",
+			"users":   []string{},
+		}, nil
+	}
+
 	summary, err := CallHuggingFaceSummarizer(messages)
 	if err != nil {
 		return nil, fmt.Errorf("summarization failed: %v", err)
@@ -0,0 +1,351 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/mentions"
+	"messaging-system-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// allMentionMemberThreshold is the group size above which only admins may use @all
+	allMentionMemberThreshold = 20
+
+	defaultMentionPageSize = 20
+	maxMentionPageSize     = 100
+)
+
+// ErrAllMentionRestricted is returned when a regular member uses @all in a large group
+var ErrAllMentionRestricted = errors.New("only group admins can use @all in groups of this size")
+
+// mentionKindPriority decides which kind is recorded on a notification when a
+// user is mentioned more than once in the same message
+var mentionKindPriority = map[string]int{
+	mentions.KindUser:   3,
+	mentions.KindAdmins: 2,
+	mentions.KindAll:    1,
+}
+
+// resolvedMentions holds the mention entities of a message and the users to notify
+type resolvedMentions struct {
+	entities   []models.Mention
+	recipients map[int]string
+}
+
+// resolveMentions parses the mentions in a group message and resolves them
+// against the current group members. Usernames that are not members are
+// ignored, and the sender is never notified about their own message.
+func resolveMentions(groupID int, senderID int, content string) (resolvedMentions, error) {
+	resolved := resolvedMentions{recipients: make(map[int]string)}
+	tokens := mentions.Parse(content)
+	if len(tokens) == 0 {
+		return resolved, nil
+	}
+
+	var usernames []string
+	broadcast := false
+	for _, token := range tokens {
+		if token.Kind == mentions.KindUser {
+			usernames = append(usernames, token.Username)
+		} else {
+			broadcast = true
+		}
+	}
+
+	// @all and @admins need the full member list, plain mentions only the named users
+	rows, err := database.DB.Query(`
+		SELECT u.id, u.username, gm.role = 'admin'
+		FROM conversation_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.conversation_id = $1 AND ($2 OR u.username = ANY($3) OR u.id = $4)
+	`, groupID, broadcast, pq.Array(usernames), senderID)
+	if err != nil {
+		return resolved, err
+	}
+	defer rows.Close()
+
+	type member struct {
+		id      int
+		isAdmin bool
+	}
+	var members []member
+	byUsername := make(map[string]int)
+	senderIsAdmin := false
+	for rows.Next() {
+		var m member
+		var username string
+		if err := rows.Scan(&m.id, &username, &m.isAdmin); err != nil {
+			return resolved, err
+		}
+		members = append(members, m)
+		byUsername[username] = m.id
+		if m.id == senderID {
+			senderIsAdmin = m.isAdmin
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return resolved, err
+	}
+
+	notify := func(userID int, kind string) {
+		if userID == senderID {
+			return
+		}
+		if current, ok := resolved.recipients[userID]; !ok || mentionKindPriority[kind] > mentionKindPriority[current] {
+			resolved.recipients[userID] = kind
+		}
+	}
+
+	for _, token := range tokens {
+		entity := models.Mention{Kind: token.Kind, Offset: token.Offset, Length: token.Length}
+		switch token.Kind {
+		case mentions.KindUser:
+			id, ok := byUsername[token.Username]
+			if !ok {
+				continue
+			}
+			entity.UserID = &id
+			notify(id, token.Kind)
+		case mentions.KindAll:
+			if len(members) > allMentionMemberThreshold && !senderIsAdmin {
+				return resolved, ErrAllMentionRestricted
+			}
+			for _, m := range members {
+				notify(m.id, token.Kind)
+			}
+		case mentions.KindAdmins:
+			for _, m := range members {
+				if m.isAdmin {
+					notify(m.id, token.Kind)
+				}
+			}
+		}
+		resolved.entities = append(resolved.entities, entity)
+	}
+	return resolved, nil
+}
+
+// saveMentions stores the mention entities of a group message and creates the
+// mention notifications in the same transaction as the message
+func saveMentions(tx *sql.Tx, messageID int, resolved resolvedMentions) error {
+	for _, entity := range resolved.entities {
+		_, err := tx.Exec(`
+			INSERT INTO message_mentions (group_message_id, kind, mentioned_user_id, start_offset, length)
+			VALUES ($1, $2, $3, $4, $5)
+		`, messageID, entity.Kind, entity.UserID, entity.Offset, entity.Length)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(resolved.recipients) == 0 {
+		return nil
+	}
+	userIDs := make([]int64, 0, len(resolved.recipients))
+	kinds := make([]string, 0, len(resolved.recipients))
+	for userID, kind := range resolved.recipients {
+		userIDs = append(userIDs, int64(userID))
+		kinds = append(kinds, kind)
+	}
+	_, err := tx.Exec(`
+		INSERT INTO mention_notifications (user_id, group_message_id, kind)
+		SELECT u, $1, k FROM UNNEST($2::int[], $3::text[]) AS t(u, k)
+		ON CONFLICT (user_id, group_message_id) DO NOTHING
+	`, messageID, pq.Array(userIDs), pq.Array(kinds))
+	return err
+}
+
+// replaceMentions swaps the mentions of an edited group message for those of
+// its new text. Users who are no longer mentioned lose their notification.
+// The returned mentions keep only the users mentioned for the first time,
+// who still need to be notified.
+func replaceMentions(tx *sql.Tx, messageID int, resolved resolvedMentions) (resolvedMentions, error) {
+	userIDs := make([]int64, 0, len(resolved.recipients))
+	for userID := range resolved.recipients {
+		userIDs = append(userIDs, int64(userID))
+	}
+
+	if _, err := tx.Exec(`
+		DELETE FROM message_mentions WHERE group_message_id = $1
+	`, messageID); err != nil {
+		return resolvedMentions{}, err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM mention_notifications
+		WHERE group_message_id = $1 AND NOT user_id = ANY($2)
+	`, messageID, pq.Array(userIDs)); err != nil {
+		return resolvedMentions{}, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT user_id FROM mention_notifications WHERE group_message_id = $1
+	`, messageID)
+	if err != nil {
+		return resolvedMentions{}, err
+	}
+	notified := make(map[int]bool)
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return resolvedMentions{}, err
+		}
+		notified[userID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return resolvedMentions{}, err
+	}
+
+	if err := saveMentions(tx, messageID, resolved); err != nil {
+		return resolvedMentions{}, err
+	}
+
+	fresh := resolvedMentions{entities: resolved.entities, recipients: make(map[int]string)}
+	for userID, kind := range resolved.recipients {
+		if !notified[userID] {
+			fresh.recipients[userID] = kind
+		}
+	}
+	return fresh, nil
+}
+
+// publishMentionEvents notifies each mentioned user on their personal channel
+func publishMentionEvents(groupID int, messageID int, senderID int, resolved resolvedMentions) {
+	for userID, kind := range resolved.recipients {
+		events.Publish(events.UserChannel(userID), models.Event{
+			Type:      "mention",
+			ChatType:  "group",
+			GroupID:   groupID,
+			MessageID: messageID,
+			UserID:    senderID,
+			Data:      map[string]string{"kind": kind},
+		})
+	}
+}
+
+// attachMentions loads the mention entities of group messages in one query
+func attachMentions(messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT group_message_id, kind, mentioned_user_id, start_offset, length
+		FROM message_mentions
+		WHERE group_message_id = ANY($1)
+		ORDER BY group_message_id, start_offset
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byMessage := make(map[int][]models.Mention)
+	for rows.Next() {
+		var messageID int
+		var m models.Mention
+		if err := rows.Scan(&messageID, &m.Kind, &m.UserID, &m.Offset, &m.Length); err != nil {
+			return err
+		}
+		byMessage[messageID] = append(byMessage[messageID], m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Mentions = byMessage[messages[i].ID]
+	}
+	return nil
+}
+
+// GetMentions returns a page of the user's mentions feed, newest first,
+// starting before the notification ID given as cursor. Mentions in groups the
+// user has left are hidden.
+func GetMentions(userID int, before int, limit int, unreadOnly bool) (*models.MentionFeed, error) {
+	if limit <= 0 {
+		limit = defaultMentionPageSize
+	}
+	if limit > maxMentionPageSize {
+		limit = maxMentionPageSize
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT mn.id, gm.conversation_id, g.name, gm.id, gm.sender_id, u.username,
+		       gm.content, mn.kind, mn.read_at IS NOT NULL, mn.created_at
+		FROM mention_notifications mn
+		JOIN conversation_messages gm ON gm.id = mn.group_message_id
+		JOIN groups g ON g.id = gm.conversation_id
+		JOIN users u ON u.id = gm.sender_id
+		JOIN conversation_members m ON m.conversation_id = gm.conversation_id AND m.user_id = mn.user_id
+		WHERE mn.user_id = $1
+		  AND ($2 = 0 OR mn.id < $2)
+		  AND (NOT $3 OR mn.read_at IS NULL)
+		  AND (gm.expires_at IS NULL OR gm.expires_at > NOW())
+		ORDER BY mn.id DESC
+		LIMIT $4
+	`, userID, before, unreadOnly, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feed := &models.MentionFeed{Mentions: []models.MentionNotification{}}
+	for rows.Next() {
+		var n models.MentionNotification
+		if err := rows.Scan(
+			&n.ID, &n.GroupID, &n.GroupName, &n.MessageID, &n.SenderID, &n.SenderUsername,
+			&n.Content, &n.Kind, &n.Read, &n.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		feed.Mentions = append(feed.Mentions, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(feed.Mentions) > limit {
+		feed.Mentions = feed.Mentions[:limit]
+		next := feed.Mentions[limit-1].ID
+		feed.NextCursor = &next
+	}
+	return feed, nil
+}
+
+// MarkMentionsRead marks the user's mentions up to and including the given
+// notification ID as read, or all of them when upToID is zero
+func MarkMentionsRead(userID int, upToID int) (map[string]string, error) {
+	res, err := database.DB.Exec(`
+		UPDATE mention_notifications
+		SET read_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND read_at IS NULL AND ($2 = 0 OR id <= $2)
+	`, userID, upToID)
+	if err != nil {
+		return nil, errors.New("failed to mark mentions as read")
+	}
+	if affected, _ := res.RowsAffected(); affected > 0 {
+		err = recordSyncEvent(database.DB, []int{userID}, models.Event{
+			Type:   "mentions.read",
+			UserID: userID,
+			Data:   map[string]int{"up_to_id": upToID},
+		})
+		if err != nil {
+			return nil, errors.New("failed to mark mentions as read")
+		}
+	}
+
+	return map[string]string{
+		"message": "Mentions marked as read",
+	}, nil
+}
@@ -2,11 +2,13 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"time"
 
 	"messaging-system-backend/internal/database"
 	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/spam"
 	"messaging-system-backend/pkg/utils"
 )
 
@@ -19,31 +21,163 @@ func SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var msg models.Message
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+	files, err := decodeMessageRequest(w, r, &msg)
+	if err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
 	// Set sender ID from JWT
 	msg.SenderID = userID
-	msg.CreatedAt = time.Now()
 
-	_, err = database.DB.Exec(
-		"INSERT INTO messages (sender_id, receiver_id, content, created_at) VALUES ($1, $2, $3, $4)",
-		msg.SenderID, msg.ReceiverID, msg.Content, msg.CreatedAt,
-	)
+	// Links are unfurled from the source so markdown link targets get previews too
+	source := msg.Content
+	content, entities, err := formatContent(msg.Content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg.Content = content
+
+	key, err := idempotencyKey(r, msg.ClientMsgID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if replayIdempotentSend(w, userID, key, "dm", msg.ReceiverID) {
+		return
+	}
+
+	// Until the two users have talked there is no conversation and nothing to reply to
+	conversationID, err := findDirectConversation(msg.SenderID, msg.ReceiverID)
+	if err != nil {
+		http.Error(w, "Could not find conversation", http.StatusInternalServerError)
+		return
+	}
+	if msg.ReplyToID != nil {
+		if err := validateReply(*msg.ReplyToID, conversationID); errors.Is(err, ErrInvalidReplyTarget) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Could not validate reply", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Encrypted messages carry a ciphertext per device instead of content,
+	// which must cover the devices the receiver has registered
+	kind := "text"
+	if msg.Encrypted {
+		kind = "encrypted"
+		if msg.Content != "" || len(files) > 0 {
+			err = ErrInvalidEncryptedMessage
+		} else {
+			err = validateCiphertexts(msg.SenderID, msg.ReceiverID, msg.Ciphertexts)
+		}
+		switch {
+		case errors.Is(err, ErrInvalidEncryptedMessage):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrStaleDevices), errors.Is(err, ErrNoDevices):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, "Could not check devices", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	chatKey := fmt.Sprintf("dm:%d:%d", min(msg.SenderID, msg.ReceiverID), max(msg.SenderID, msg.ReceiverID))
+	if wait, err := checkSendRate(r.Context(), userID, 1, chatKey); errors.Is(err, ErrRateLimited) {
+		writeRateLimited(w, wait, err)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not check rate limits", http.StatusInternalServerError)
+		return
+	}
+
+	screening, err := checkSpam(r.Context(), userID, fmt.Sprintf("dm:%d", msg.ReceiverID), source, conversationID == 0)
+	if err != nil {
+		http.Error(w, "Could not check message", http.StatusInternalServerError)
+		return
+	}
+	if screening.Verdict == spam.Reject {
+		writeSpamRejected(w, screening)
+		return
+	}
+
+	uploads, err := uploadAttachments(files)
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		discardAttachments(uploads)
+		http.Error(w, "Failed to send message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	conv, err := ensureDirectConversation(tx, msg.SenderID, msg.ReceiverID)
+	if err == nil {
+		msg.ID, msg.CreatedAt, err = insertMessage(tx, conv, newMessage{
+			SenderID:  msg.SenderID,
+			Kind:      kind,
+			Content:   msg.Content,
+			Entities:  entities,
+			ReplyToID: msg.ReplyToID,
+		})
+	}
+	if err == nil && msg.Encrypted {
+		err = storeCiphertexts(tx, conv.ID, msg.ID, msg.Ciphertexts)
+	}
+	if err == nil {
+		err = queueForModeration(tx, msg.ID, msg.SenderID, screening)
+	}
+	if err == nil {
+		err = clearDraft(tx, conv.ID, msg.SenderID)
+	}
+	if err == nil {
+		err = linkAttachments(tx, "dm", msg.ID, msg.SenderID, uploads)
+	}
+	if err == nil {
+		err = queueLinkPreviews(tx, "dm", msg.ID, source)
+	}
+	recorded := true
+	if err == nil {
+		recorded, err = recordIdempotentSend(tx, userID, key, "dm", msg.ReceiverID, msg.ID, msg.CreatedAt)
+	}
+	if err == nil && !recorded {
+		// A concurrent retry with the same key was stored first
+		tx.Rollback()
+		discardAttachments(uploads)
+		replayIdempotentSend(w, userID, key, "dm", msg.ReceiverID)
+		return
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
+		discardAttachments(uploads)
 		http.Error(w, "Failed to send message: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"message": "Message sent"})
+	json.NewEncoder(w).Encode(models.SendResult{
+		Message:     "Message sent",
+		ID:          msg.ID,
+		CreatedAt:   msg.CreatedAt,
+		ClientMsgID: key,
+	})
 }
 
 // SendGroupMessage handles sending a message to a group
 func SendGroupMessage(w http.ResponseWriter, r *http.Request) {
 	var msg models.GroupMessageInput
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+	files, err := decodeMessageRequest(w, r, &msg)
+	if err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
@@ -55,24 +189,178 @@ func SendGroupMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if user is a member of the group
-	var count int
-	err = database.DB.QueryRow(`
-        SELECT COUNT(*) FROM group_members 
-        WHERE group_id=$1 AND user_id=$2
-    `, msg.GroupID, userID).Scan(&count)
-	if err != nil || count == 0 {
+	isMember, err := isGroupMember(msg.GroupID, userID)
+	if err != nil || !isMember {
 		http.Error(w, "You are not a member of this group", http.StatusForbidden)
 		return
 	}
 
-	_, err = database.DB.Exec(`
-        INSERT INTO group_messages (group_id, sender_id, content) 
-        VALUES ($1, $2, $3)
-    `, msg.GroupID, userID, msg.Content)
+	key, err := idempotencyKey(r, msg.ClientMsgID)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if replayIdempotentSend(w, userID, key, "group", msg.GroupID) {
+		return
+	}
+
+	if msg.ReplyToID != nil {
+		if err := validateReply(*msg.ReplyToID, msg.GroupID); errors.Is(err, ErrInvalidReplyTarget) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Could not validate reply", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if msg.ThreadRootID != nil {
+		if err := validateThreadRoot(*msg.ThreadRootID, msg.GroupID); errors.Is(err, ErrInvalidThreadRoot) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Could not validate thread", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Links are unfurled from the source so markdown link targets get previews too
+	source := msg.Content
+	content, entities, err := formatContent(msg.Content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg.Content = content
+
+	resolved, err := resolveMentions(msg.GroupID, userID, msg.Content)
+	if errors.Is(err, ErrAllMentionRestricted) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not resolve mentions", http.StatusInternalServerError)
+		return
+	}
+
+	chatKey := fmt.Sprintf("group:%d", msg.GroupID)
+	wait, err := checkSlowMode(r.Context(), msg.GroupID, userID)
+	if err == nil {
+		wait, err = checkSendRate(r.Context(), userID, 1, chatKey)
+	}
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrSlowMode) {
+		writeRateLimited(w, wait, err)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not check rate limits", http.StatusInternalServerError)
+		return
+	}
+
+	screening, err := checkSpam(r.Context(), userID, chatKey, source, false)
+	if err != nil {
+		http.Error(w, "Could not check message", http.StatusInternalServerError)
+		return
+	}
+	if screening.Verdict == spam.Reject {
+		writeSpamRejected(w, screening)
+		return
+	}
+
+	uploads, err := uploadAttachments(files)
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		discardAttachments(uploads)
 		http.Error(w, "Could not send message", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	json.NewEncoder(w).Encode(map[string]string{"message": "Message sent"})
+	conv := conversation{ID: msg.GroupID, ChatType: "group", GroupID: msg.GroupID}
+	msg.ID, msg.CreatedAt, err = insertMessage(tx, conv, newMessage{
+		SenderID:     userID,
+		Content:      msg.Content,
+		Entities:     entities,
+		ReplyToID:    msg.ReplyToID,
+		ThreadRootID: msg.ThreadRootID,
+	})
+	if err == nil {
+		err = queueForModeration(tx, msg.ID, userID, screening)
+	}
+	if err == nil && msg.ThreadRootID != nil {
+		err = recordThreadReply(tx, *msg.ThreadRootID, userID)
+	} else if err == nil {
+		err = clearDraft(tx, conv.ID, userID)
+	}
+	if err == nil {
+		err = linkAttachments(tx, "group", msg.ID, userID, uploads)
+	}
+	if err == nil {
+		err = saveMentions(tx, msg.ID, resolved)
+	}
+	if err == nil {
+		err = queueLinkPreviews(tx, "group", msg.ID, source)
+	}
+	recorded := true
+	if err == nil {
+		recorded, err = recordIdempotentSend(tx, userID, key, "group", msg.GroupID, msg.ID, msg.CreatedAt)
+	}
+	if err == nil && !recorded {
+		// A concurrent retry with the same key was stored first
+		tx.Rollback()
+		discardAttachments(uploads)
+		replayIdempotentSend(w, userID, key, "group", msg.GroupID)
+		return
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		discardAttachments(uploads)
+		http.Error(w, "Could not send message", http.StatusInternalServerError)
+		return
+	}
+
+	startSlowMode(r.Context(), msg.GroupID, userID)
+	publishMentionEvents(msg.GroupID, msg.ID, userID, resolved)
+
+	json.NewEncoder(w).Encode(models.SendResult{
+		Message:     "Message sent",
+		ID:          msg.ID,
+		CreatedAt:   msg.CreatedAt,
+		ClientMsgID: key,
+	})
+}
+
+// replayIdempotentSend answers a retried send with the message the first
+// attempt created. It returns false when the key has not been used yet.
+func replayIdempotentSend(w http.ResponseWriter, senderID int, key string, chatType string, chatID int) bool {
+	result, err := findIdempotentSend(senderID, key, chatType, chatID)
+	if errors.Is(err, ErrIdempotencyKeyReused) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return true
+	} else if err != nil {
+		http.Error(w, "Could not check client_msg_id", http.StatusInternalServerError)
+		return true
+	}
+	if result == nil {
+		return false
+	}
+	json.NewEncoder(w).Encode(result)
+	return true
+}
+
+// writeAttachmentError reports an attachment upload failure to the client
+func writeAttachmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrAttachmentTooLarge), errors.Is(err, ErrTooManyAttachments):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrUnsupportedAttachment):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		http.Error(w, "Could not store attachment", http.StatusInternalServerError)
+	}
 }
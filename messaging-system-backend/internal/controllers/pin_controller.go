@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"errors"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// maxPinsPerConversation caps how many messages can be pinned in one DM or group
+const maxPinsPerConversation = 50
+
+var (
+	// ErrPinNotAllowed is returned when a group member who is not an admin tries to change pins
+	ErrPinNotAllowed = errors.New("only group admins can pin messages")
+	// ErrTooManyPins is returned when a conversation already has the maximum number of pins
+	ErrTooManyPins = errors.New("conversation already has the maximum number of pinned messages")
+)
+
+// pinScope returns the columns identifying the conversation of a pin: the
+// group ID for groups, or the ordered participant pair for DMs
+func (c conversation) pinScope() (groupID, userLow, userHigh *int) {
+	if c.ChatType == "group" {
+		return &c.GroupID, nil, nil
+	}
+	low, high := c.SenderID, c.ReceiverID
+	if low > high {
+		low, high = high, low
+	}
+	return nil, &low, &high
+}
+
+// authorizePin checks that the user may change pins on the message: either
+// participant of a DM, or an admin of the group
+func authorizePin(input models.PinInput, userID int) (conversation, error) {
+	conv, err := authorizeMessage(input.MessageType, input.MessageID, userID)
+	if err != nil {
+		return conv, err
+	}
+	if conv.ChatType == "group" {
+		isAdmin, err := isGroupAdmin(conv.GroupID, userID)
+		if err != nil {
+			return conv, err
+		}
+		if !isAdmin {
+			return conv, ErrPinNotAllowed
+		}
+	}
+	return conv, nil
+}
+
+// PinMessage pins a DM or group message. Pinning an already pinned message is a no-op.
+func PinMessage(input models.PinInput, userID int) (map[string]string, error) {
+	conv, err := authorizePin(input, userID)
+	if err != nil {
+		return nil, err
+	}
+	groupID, userLow, userHigh := conv.pinScope()
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("failed to pin message")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO message_pins (message_type, message_id, group_id, dm_user_low, dm_user_high, pinned_by)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE (
+		        SELECT COUNT(*) FROM message_pins
+		        WHERE message_type = $1
+		          AND group_id IS NOT DISTINCT FROM $3
+		          AND dm_user_low IS NOT DISTINCT FROM $4
+		          AND dm_user_high IS NOT DISTINCT FROM $5
+		    ) < $7
+		ON CONFLICT (message_type, message_id) DO NOTHING
+	`, input.MessageType, input.MessageID, groupID, userLow, userHigh, userID, maxPinsPerConversation)
+	if err != nil {
+		return nil, errors.New("failed to pin message")
+	}
+
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		var exists bool
+		err = tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM message_pins WHERE message_type = $1 AND message_id = $2
+			)
+		`, input.MessageType, input.MessageID).Scan(&exists)
+		if err != nil {
+			return nil, errors.New("failed to pin message")
+		}
+		if !exists {
+			return nil, ErrTooManyPins
+		}
+	} else {
+		event := pinEvent(conv, "message.pinned", input.MessageID, userID)
+		if err := recordConversationEvent(tx, conv, event); err != nil {
+			return nil, errors.New("failed to pin message")
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, errors.New("failed to pin message")
+		}
+		events.Publish(conv.channel(), event)
+	}
+
+	return map[string]string{
+		"message": "Message pinned",
+	}, nil
+}
+
+// UnpinMessage removes the pin from a DM or group message
+func UnpinMessage(input models.PinInput, userID int) (map[string]string, error) {
+	conv, err := authorizePin(input, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("failed to unpin message")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		DELETE FROM message_pins WHERE message_type = $1 AND message_id = $2
+	`, input.MessageType, input.MessageID)
+	if err != nil {
+		return nil, errors.New("failed to unpin message")
+	}
+
+	if affected, _ := res.RowsAffected(); affected > 0 {
+		event := pinEvent(conv, "message.unpinned", input.MessageID, userID)
+		if err := recordConversationEvent(tx, conv, event); err != nil {
+			return nil, errors.New("failed to unpin message")
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, errors.New("failed to unpin message")
+		}
+		events.Publish(conv.channel(), event)
+	}
+
+	return map[string]string{
+		"message": "Message unpinned",
+	}, nil
+}
+
+// pinEvent describes a pin change for the sync log and live clients
+func pinEvent(conv conversation, eventType string, messageID int, userID int) models.Event {
+	return models.Event{
+		Type:      eventType,
+		ChatType:  conv.ChatType,
+		GroupID:   conv.GroupID,
+		MessageID: messageID,
+		UserID:    userID,
+	}
+}
+
+// GetPinnedMessages returns the pinned messages of a DM (chatID is the other
+// user) or group, most recently pinned first
+func GetPinnedMessages(chatType string, chatID int, userID int) ([]models.PinnedMessage, error) {
+	var query string
+	var args []interface{}
+
+	switch chatType {
+	case "dm":
+		query = `
+			SELECT p.message_type, m.id, m.sender_id, m.content, m.created_at, p.pinned_by, p.pinned_at
+			FROM message_pins p
+			JOIN conversation_messages m ON m.id = p.message_id
+			WHERE p.message_type = 'dm' AND p.group_id IS NULL
+			  AND p.dm_user_low = LEAST($1::int, $2::int) AND p.dm_user_high = GREATEST($1::int, $2::int)
+			  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+			ORDER BY p.pinned_at DESC, p.id DESC
+		`
+		args = []interface{}{userID, chatID}
+	case "group":
+		isMember, err := isGroupMember(chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, ErrNotParticipant
+		}
+		query = `
+			SELECT p.message_type, m.id, m.sender_id, m.content, m.created_at, p.pinned_by, p.pinned_at
+			FROM message_pins p
+			JOIN conversation_messages m ON m.id = p.message_id
+			WHERE p.message_type = 'group' AND p.group_id = $1
+			  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+			ORDER BY p.pinned_at DESC, p.id DESC
+		`
+		args = []interface{}{chatID}
+	default:
+		return nil, ErrInvalidChatType
+	}
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []models.PinnedMessage{}
+	for rows.Next() {
+		var p models.PinnedMessage
+		if err := rows.Scan(
+			&p.MessageType, &p.MessageID, &p.SenderID, &p.Content, &p.CreatedAt, &p.PinnedBy, &p.PinnedAt,
+		); err != nil {
+			return nil, err
+		}
+		pins = append(pins, p)
+	}
+	return pins, rows.Err()
+}
+
+// attachLatestGroupPins loads the most recently pinned message of every group preview in one query
+func attachLatestGroupPins(groups []models.GroupPreview) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(groups))
+	for i, g := range groups {
+		ids[i] = int64(g.ID)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT DISTINCT ON (p.group_id)
+		       p.group_id, p.message_type, m.id, m.sender_id, m.content, m.created_at, p.pinned_by, p.pinned_at
+		FROM message_pins p
+		JOIN conversation_messages m ON m.id = p.message_id
+		WHERE p.message_type = 'group' AND p.group_id = ANY($1)
+		  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+		ORDER BY p.group_id, p.pinned_at DESC, p.id DESC
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	latest := make(map[int]*models.PinnedMessage)
+	for rows.Next() {
+		var groupID int
+		p := &models.PinnedMessage{}
+		if err := rows.Scan(
+			&groupID, &p.MessageType, &p.MessageID, &p.SenderID, &p.Content, &p.CreatedAt, &p.PinnedBy, &p.PinnedAt,
+		); err != nil {
+			return err
+		}
+		latest[groupID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range groups {
+		groups[i].PinnedMessage = latest[groups[i].ID]
+	}
+	return nil
+}
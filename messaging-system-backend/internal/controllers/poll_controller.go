@@ -0,0 +1,426 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/spam"
+
+	"github.com/lib/pq"
+)
+
+const (
+	minPollOptions        = 2
+	maxPollOptions        = 10
+	maxPollQuestionLength = 300
+	maxPollOptionLength   = 100
+	// maxPollDuration is how far in the future a poll's close time may be
+	maxPollDuration = 365 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidPoll is returned when a poll's question, options or close time are not valid
+	ErrInvalidPoll = errors.New("a poll needs a question, 2 to 10 distinct options and a close time within one year")
+	// ErrPollNotFound is returned when a message is not a poll
+	ErrPollNotFound = errors.New("poll not found")
+	// ErrPollClosed is returned when voting on or closing a poll that has already closed
+	ErrPollClosed = errors.New("poll is closed")
+	// ErrInvalidPollVote is returned for votes naming unknown options, or several options in a single choice poll
+	ErrInvalidPollVote = errors.New("invalid poll options")
+	// ErrPollCloseNotAllowed is returned when someone other than the creator or a group admin closes a poll
+	ErrPollCloseNotAllowed = errors.New("only the poll creator or a group admin can close the poll")
+	// ErrPollNotEditable is returned when editing the message that carries a poll
+	ErrPollNotEditable = errors.New("polls cannot be edited")
+	// ErrPollNotForwardable is returned when forwarding the message that carries a poll
+	ErrPollNotForwardable = errors.New("polls cannot be forwarded")
+)
+
+// validatePoll trims and checks the question, options and close time of a new poll
+func validatePoll(input *models.CreatePollInput) error {
+	input.Question = strings.TrimSpace(input.Question)
+	if input.Question == "" || utf8.RuneCountInString(input.Question) > maxPollQuestionLength {
+		return ErrInvalidPoll
+	}
+	if len(input.Options) < minPollOptions || len(input.Options) > maxPollOptions {
+		return ErrInvalidPoll
+	}
+	seen := make(map[string]bool)
+	for i, option := range input.Options {
+		option = strings.TrimSpace(option)
+		key := strings.ToLower(option)
+		if option == "" || utf8.RuneCountInString(option) > maxPollOptionLength || seen[key] {
+			return ErrInvalidPoll
+		}
+		seen[key] = true
+		input.Options[i] = option
+	}
+	if input.ClosesAt != nil {
+		now := time.Now()
+		if !input.ClosesAt.After(now) || input.ClosesAt.After(now.Add(maxPollDuration)) {
+			return ErrInvalidPoll
+		}
+	}
+	return nil
+}
+
+// CreatePoll posts a poll to a group. The poll is carried by a group message
+// of kind "poll" whose content is the question, so it shows up in chat
+// history, previews and search like any other message.
+func CreatePoll(input models.CreatePollInput, userID int) (*models.Poll, error) {
+	if err := validatePoll(&input); err != nil {
+		return nil, err
+	}
+	isMember, err := isGroupMember(input.GroupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotParticipant
+	}
+	chatKey := fmt.Sprintf("group:%d", input.GroupID)
+	if err := checkSendLimits(userID, 1, []int{input.GroupID}, chatKey); err != nil {
+		return nil, err
+	}
+	// The options are screened with the question, since either can carry links
+	text := input.Question + "\n" + strings.Join(input.Options, "\n")
+	screening, err := checkSpam(context.Background(), userID, chatKey, text, false)
+	if err != nil {
+		return nil, err
+	}
+	if screening.Verdict == spam.Reject {
+		return nil, &SpamRejectedError{Result: screening}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	conv := conversation{ID: input.GroupID, ChatType: "group", GroupID: input.GroupID}
+	messageID, _, err := insertMessage(tx, conv, newMessage{SenderID: userID, Kind: "poll", Content: input.Question})
+	if err != nil {
+		return nil, err
+	}
+	if err := queueForModeration(tx, messageID, userID, screening); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO polls (message_id, question, multiple_choice, anonymous, closes_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, messageID, input.Question, input.MultipleChoice, input.Anonymous, input.ClosesAt); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO poll_options (poll_id, position, text)
+		SELECT $1, t.position, t.text
+		FROM UNNEST($2::text[]) WITH ORDINALITY AS t(text, position)
+	`, messageID, pq.Array(input.Options)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	startSlowMode(context.Background(), input.GroupID, userID)
+	return loadPoll(messageID, userID)
+}
+
+// GetPoll returns a poll with its current results as seen by the user
+func GetPoll(messageID int, userID int) (*models.Poll, error) {
+	if _, err := authorizeMessage("group", messageID, userID); err != nil {
+		return nil, err
+	}
+	return loadPoll(messageID, userID)
+}
+
+// VotePoll replaces the user's vote in a poll. Votes can be changed or
+// retracted with an empty list until the poll closes.
+func VotePoll(messageID int, input models.PollVoteInput, userID int) (*models.Poll, error) {
+	conv, err := authorizeMessage("group", messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// FOR SHARE lets votes run concurrently while blocking ClosePoll until they commit
+	var multipleChoice, closed bool
+	err = tx.QueryRow(`
+		SELECT multiple_choice,
+		       closed_at IS NOT NULL OR (closes_at IS NOT NULL AND closes_at <= NOW())
+		FROM polls
+		WHERE message_id = $1
+		FOR SHARE
+	`, messageID).Scan(&multipleChoice, &closed)
+	if err == sql.ErrNoRows {
+		return nil, ErrPollNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if closed {
+		return nil, ErrPollClosed
+	}
+
+	optionIDs := make([]int64, 0, len(input.OptionIDs))
+	seen := make(map[int]bool)
+	for _, id := range input.OptionIDs {
+		if !seen[id] {
+			seen[id] = true
+			optionIDs = append(optionIDs, int64(id))
+		}
+	}
+	if len(optionIDs) > 1 && !multipleChoice {
+		return nil, ErrInvalidPollVote
+	}
+	if len(optionIDs) > 0 {
+		var valid int
+		err = tx.QueryRow(`
+			SELECT COUNT(*) FROM poll_options WHERE poll_id = $1 AND id = ANY($2)
+		`, messageID, pq.Array(optionIDs)).Scan(&valid)
+		if err != nil {
+			return nil, err
+		}
+		if valid != len(optionIDs) {
+			return nil, ErrInvalidPollVote
+		}
+	}
+
+	if _, err := tx.Exec(`
+		DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2
+	`, messageID, userID); err != nil {
+		return nil, err
+	}
+	if len(optionIDs) > 0 {
+		if _, err := tx.Exec(`
+			INSERT INTO poll_votes (poll_id, option_id, user_id)
+			SELECT $1, option_id, $2 FROM UNNEST($3::int[]) AS option_id
+		`, messageID, userID, pq.Array(optionIDs)); err != nil {
+			return nil, err
+		}
+	}
+	if err := recordPollEvent(tx, conv, "poll.voted", messageID, userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	poll, err := loadPoll(messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	publishPollEvent(conv, "poll.voted", poll, userID)
+	return poll, nil
+}
+
+// ClosePoll ends a poll before its close time. Only the creator or a group
+// admin may close it.
+func ClosePoll(messageID int, userID int) (*models.Poll, error) {
+	conv, err := authorizeMessage("group", messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if conv.SenderID != userID {
+		isAdmin, err := isGroupAdmin(conv.GroupID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isAdmin {
+			return nil, ErrPollCloseNotAllowed
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE polls
+		SET closed_at = CURRENT_TIMESTAMP, closed_by = $2
+		WHERE message_id = $1 AND closed_at IS NULL
+		  AND (closes_at IS NULL OR closes_at > NOW())
+	`, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		var exists bool
+		if err := tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM polls WHERE message_id = $1)
+		`, messageID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrPollNotFound
+		}
+		return nil, ErrPollClosed
+	}
+	if err := recordPollEvent(tx, conv, "poll.closed", messageID, userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	poll, err := loadPoll(messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	publishPollEvent(conv, "poll.closed", poll, userID)
+	return poll, nil
+}
+
+// loadPoll returns one poll with its results as seen by the user
+func loadPoll(messageID int, userID int) (*models.Poll, error) {
+	polls, err := loadPolls([]int64{int64(messageID)}, userID)
+	if err != nil {
+		return nil, err
+	}
+	poll, ok := polls[messageID]
+	if !ok {
+		return nil, ErrPollNotFound
+	}
+	return poll, nil
+}
+
+// loadPolls loads polls with their options, vote counts and the user's own
+// votes, keyed by message ID. Voter lists are only filled in for polls with
+// visible voters.
+func loadPolls(messageIDs []int64, userID int) (map[int]*models.Poll, error) {
+	polls := make(map[int]*models.Poll)
+	if len(messageIDs) == 0 {
+		return polls, nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT p.message_id, gm.conversation_id, gm.sender_id, p.question,
+		       p.multiple_choice, p.anonymous, p.closes_at, p.closed_at,
+		       p.closed_at IS NOT NULL OR (p.closes_at IS NOT NULL AND p.closes_at <= NOW()),
+		       (SELECT COUNT(DISTINCT user_id) FROM poll_votes v WHERE v.poll_id = p.message_id)
+		FROM polls p
+		JOIN conversation_messages gm ON gm.id = p.message_id
+		WHERE p.message_id = ANY($1)
+	`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		p := &models.Poll{Options: []models.PollOption{}}
+		if err := rows.Scan(&p.MessageID, &p.GroupID, &p.CreatorID, &p.Question,
+			&p.MultipleChoice, &p.Anonymous, &p.ClosesAt, &p.ClosedAt,
+			&p.Closed, &p.TotalVoters); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		polls[p.MessageID] = p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = database.DB.Query(`
+		SELECT o.poll_id, o.id, o.text, COUNT(v.user_id),
+		       COALESCE(ARRAY_AGG(v.user_id ORDER BY v.created_at) FILTER (WHERE v.user_id IS NOT NULL AND NOT p.anonymous), '{}'),
+		       BOOL_OR(v.user_id = $2)
+		FROM poll_options o
+		JOIN polls p ON p.message_id = o.poll_id
+		LEFT JOIN poll_votes v ON v.option_id = o.id
+		WHERE o.poll_id = ANY($1)
+		GROUP BY o.poll_id, o.id, o.text, o.position
+		ORDER BY o.poll_id, o.position
+	`, pq.Array(messageIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pollID int
+		var option models.PollOption
+		var voters pq.Int64Array
+		var mine sql.NullBool
+		if err := rows.Scan(&pollID, &option.ID, &option.Text, &option.Votes, &voters, &mine); err != nil {
+			return nil, err
+		}
+		for _, id := range voters {
+			option.Voters = append(option.Voters, int(id))
+		}
+		p, ok := polls[pollID]
+		if !ok {
+			continue
+		}
+		p.Options = append(p.Options, option)
+		if mine.Bool {
+			p.MyVotes = append(p.MyVotes, option.ID)
+		}
+	}
+	return polls, rows.Err()
+}
+
+// attachPolls loads the poll carried by each poll message in one pass
+func attachPolls(messages []models.ChatMessage, userID int) error {
+	var ids []int64
+	for _, msg := range messages {
+		if msg.Kind == "poll" {
+			ids = append(ids, int64(msg.ID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	polls, err := loadPolls(ids, userID)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Poll = polls[messages[i].ID]
+	}
+	return nil
+}
+
+// recordPollEvent logs a vote or close for every group member. Like message
+// events it names the poll without its results, which clients fetch by ID.
+func recordPollEvent(tx *sql.Tx, conv conversation, eventType string, messageID int, userID int) error {
+	return recordConversationEvent(tx, conv, models.Event{
+		Type:      eventType,
+		ChatType:  conv.ChatType,
+		GroupID:   conv.GroupID,
+		MessageID: messageID,
+		UserID:    userID,
+	})
+}
+
+// publishPollEvent sends the updated results of a poll to live clients in
+// the group. Each client keeps its own my_votes, so it is left out.
+func publishPollEvent(conv conversation, eventType string, poll *models.Poll, userID int) {
+	results := *poll
+	results.MyVotes = nil
+	events.Publish(conv.channel(), models.Event{
+		Type:      eventType,
+		ChatType:  conv.ChatType,
+		GroupID:   conv.GroupID,
+		MessageID: poll.MessageID,
+		UserID:    userID,
+		Data:      results,
+	})
+}
@@ -1,170 +1,213 @@
 package controllers
 
 import (
-	"errors"
+	"time"
 
 	"messaging-system-backend/internal/database"
 	"messaging-system-backend/internal/models"
 )
 
-// GetLatestMessagesFromUsers retrieves the latest messages from users
-func GetLatestMessagesFromUsers(userID int) ([]models.MessagePreview, error) {
-	rows, err := database.DB.Query(`
-		SELECT DISTINCT ON (LEAST(sender_id, receiver_id), GREATEST(sender_id, receiver_id)) 
-    m.id, m.sender_id, m.receiver_id, 
-    s.status AS sender_status, 
-    r.status AS receiver_status, 
-    m.content, m.created_at
-FROM messages m
-JOIN users s ON s.id = m.sender_id
-JOIN users r ON r.id = m.receiver_id
-WHERE m.sender_id = $1 OR m.receiver_id = $1
-ORDER BY LEAST(sender_id, receiver_id), GREATEST(sender_id, receiver_id), m.created_at DESC
-LIMIT 10
+// previewRow is one conversation of the inbox query together with the
+// statuses the legacy preview endpoints report
+type previewRow struct {
+	models.ConversationPreview
+	LastActivity   time.Time
+	SenderStatus   string
+	ReceiverStatus string
+}
+
+// previewFilter narrows the inbox query. ChatType limits it to DMs or groups
+// and FolderID to the conversations matching one of the user's folders.
+type previewFilter struct {
+	ChatType        string
+	IncludeArchived bool
+	FolderID        int
+}
 
-	`, userID)
+// loadConversationPreviews lists the user's conversations with their latest
+// top-level message and the user's state for each. Pinned conversations come
+// first, most recently pinned on top, then the rest by latest activity.
+// Archived conversations are skipped unless the filter includes them. DMs
+// only appear once they have a visible message; groups without messages sort
+// as if active now. A conversation is unread when its latest message came
+// from someone else and the user has no receipt for it. The user's draft is
+// included when there is one.
+func loadConversationPreviews(userID int, filter previewFilter, limit int) ([]previewRow, error) {
+	rows, err := database.DB.Query(`
+		SELECT c.id, c.type, COALESCE(g.name, ou.username, ''), COALESCE(ou.id, 0),
+		       m.id, m.sender_id, m.kind, m.content, m.created_at,
+		       COALESCE(m.created_at, NOW()) AS last_activity,
+		       COALESCE(su.status, 'Available') AS sender_status,
+		       COALESCE(CASE WHEN c.type = 'dm' AND m.sender_id = $1 THEN ou.status ELSE me.status END, 'Available') AS receiver_status,
+		       u.unread,
+		       d.content, d.reply_to_id, d.client_updated_at,
+		       `+conversationStateColumns+`
+		FROM conversation_members cm
+		JOIN conversations c ON c.id = cm.conversation_id
+		JOIN users me ON me.id = cm.user_id
+		LEFT JOIN groups g ON c.type = 'group' AND g.id = c.id
+		LEFT JOIN users ou ON c.type = 'dm'
+		      AND ou.id = CASE WHEN c.dm_user_low = $1 THEN c.dm_user_high ELSE c.dm_user_low END
+		LEFT JOIN LATERAL (
+			SELECT id, sender_id, kind, content, created_at
+			FROM conversation_messages
+			WHERE conversation_id = c.id AND thread_root_id IS NULL
+			  AND (expires_at IS NULL OR expires_at > NOW())
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		) m ON true
+		LEFT JOIN users su ON su.id = m.sender_id
+		CROSS JOIN LATERAL (
+			SELECT m.id IS NOT NULL AND m.sender_id <> $1 AND NOT EXISTS (
+				SELECT 1 FROM message_receipts r
+				WHERE r.message_type = c.type AND r.message_id = m.id AND r.user_id = $1
+			) AS unread
+		) u
+		LEFT JOIN message_drafts d ON d.user_id = $1 AND d.conversation_id = c.id
+		      AND (d.content <> '' OR d.reply_to_id IS NOT NULL)
+		LEFT JOIN chat_folders f ON f.id = $5 AND f.user_id = $1
+		WHERE cm.user_id = $1
+		  AND ($2::text = '' OR c.type = $2)
+		  AND (c.type = 'group' OR m.id IS NOT NULL)
+		  AND ($3 OR cm.archived_at IS NULL)
+		  AND ($5 = 0 OR (`+folderMatchSQL+`))
+		ORDER BY cm.pinned_at IS NULL, cm.pinned_at DESC, last_activity DESC, c.id DESC
+		LIMIT $4
+	`, userID, filter.ChatType, filter.IncludeArchived, limit, filter.FolderID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var previews []models.MessagePreview
+	var previews []previewRow
 	for rows.Next() {
-		var msg models.MessagePreview
-		if err := rows.Scan(
-    &msg.ID,
-    &msg.SenderID,
-    &msg.ReceiverID,
-    &msg.SenderStatus,
-    &msg.ReceiverStatus,
-    &msg.Content,
-    &msg.CreatedAt,
-); err != nil {
-    return nil, err
+		var p previewRow
+		var messageID, senderID *int
+		var kind, content *string
+		var createdAt *time.Time
+		var draftContent *string
+		var draftReplyToID *int
+		var draftUpdatedAt *time.Time
+		dest := []interface{}{
+			&p.ID, &p.Type, &p.Title, &p.OtherUserID,
+			&messageID, &senderID, &kind, &content, &createdAt,
+			&p.LastActivity, &p.SenderStatus, &p.ReceiverStatus, &p.Unread,
+			&draftContent, &draftReplyToID, &draftUpdatedAt,
+		}
+		if err := rows.Scan(append(dest, scanConversationState(&p.ConversationState)...)...); err != nil {
+			return nil, err
+		}
+		if messageID != nil {
+			p.LastMessage = &models.LastMessagePreview{
+				ID:        *messageID,
+				SenderID:  *senderID,
+				Kind:      *kind,
+				Content:   *content,
+				CreatedAt: *createdAt,
+			}
+		}
+		if draftUpdatedAt != nil {
+			p.Draft = &models.Draft{
+				ConversationID:  p.ID,
+				Content:         *draftContent,
+				ReplyToID:       draftReplyToID,
+				ClientUpdatedAt: *draftUpdatedAt,
+			}
+		}
+		previews = append(previews, p)
+	}
+	return previews, rows.Err()
 }
 
-		previews = append(previews, msg)
+// GetLatestMessagesFromUsers retrieves the latest messages from users,
+// pinned DMs first. Archived DMs are left out unless includeArchived is set.
+func GetLatestMessagesFromUsers(userID int, includeArchived bool) ([]models.MessagePreview, error) {
+	rows, err := loadConversationPreviews(userID, previewFilter{ChatType: "dm", IncludeArchived: includeArchived}, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	var previews []models.MessagePreview
+	for _, row := range rows {
+		last := row.LastMessage
+		receiverID := userID
+		if last.SenderID == userID {
+			receiverID = row.OtherUserID
+		}
+		previews = append(previews, models.MessagePreview{
+			ID:                last.ID,
+			ConversationID:    row.ID,
+			SenderID:          last.SenderID,
+			ReceiverID:        receiverID,
+			SenderStatus:      row.SenderStatus,
+			ReceiverStatus:    row.ReceiverStatus,
+			Content:           last.Content,
+			CreatedAt:         last.CreatedAt,
+			Draft:             row.Draft,
+			ConversationState: row.ConversationState,
+		})
 	}
 	return previews, nil
 }
 
-// GetLatestGroupsWithMessages retrieves the latest groups with messages
-func GetLatestGroupsWithMessages(userID int) ([]models.GroupPreview, error) {
-	rows, err := database.DB.Query(`
-		SELECT g.id, g.name,
-       COALESCE(m.content, '') AS last_message,
-       COALESCE(m.created_at, NOW()) AS last_message_time,
-       COALESCE(su.status, 'Available') AS sender_status,
-       COALESCE(ru.status, 'Available') AS receiver_status
-FROM groups g
-INNER JOIN group_members gm ON g.id = gm.group_id
-LEFT JOIN LATERAL (
-    SELECT sender_id, content, created_at
-    FROM group_messages
-    WHERE group_id = g.id
-    ORDER BY created_at DESC
-    LIMIT 1
-) m ON true
-LEFT JOIN users su ON su.id = m.sender_id           
-LEFT JOIN users ru ON ru.id = $1                    
-WHERE gm.user_id = $1
-ORDER BY last_message_time DESC
-LIMIT 10
-
-	`, userID)
+// GetLatestGroupsWithMessages retrieves the latest groups with messages,
+// pinned groups first, optionally including each group's most recently
+// pinned message. Archived groups are left out unless includeArchived is set.
+func GetLatestGroupsWithMessages(userID int, includePinned bool, includeArchived bool) ([]models.GroupPreview, error) {
+	rows, err := loadConversationPreviews(userID, previewFilter{ChatType: "group", IncludeArchived: includeArchived}, 10)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var groups []models.GroupPreview
-	for rows.Next() {
-		var g models.GroupPreview
-		if err := rows.Scan(
-	&g.ID, &g.Name, &g.LastMessage, &g.LastMessageTime,
-	&g.SenderStatus, &g.ReceiverStatus,
-); err != nil {
-	return nil, err
-}
-
+	for _, row := range rows {
+		g := models.GroupPreview{
+			ID:                row.ID,
+			Name:              row.Title,
+			LastMessageType:   "text",
+			LastMessageTime:   row.LastActivity,
+			SenderStatus:      row.SenderStatus,
+			ReceiverStatus:    row.ReceiverStatus,
+			Draft:             row.Draft,
+			ConversationState: row.ConversationState,
+		}
+		if row.LastMessage != nil {
+			g.LastMessage = row.LastMessage.Content
+			g.LastMessageType = row.LastMessage.Kind
+		}
 		groups = append(groups, g)
 	}
+
+	if includePinned {
+		if err := attachLatestGroupPins(groups); err != nil {
+			return nil, err
+		}
+	}
 	return groups, nil
 }
 
-// GetLatestMessages retrieves the latest messages for a specific chat type (DM or group)
+// GetLatestMessages retrieves the latest messages for a specific chat type
+// (DM or group). chatID is the other user for DMs and the group ID for groups.
 func GetLatestMessages(chatType string, chatID int, userID int) ([]models.ChatMessage, error) {
+	var c conversation
 	switch chatType {
 	case "dm":
-		rows, err := database.DB.Query(`
-			SELECT m.id, m.sender_id, m.receiver_id,
-       m.content, m.created_at,
-       su.status AS sender_status,
-       ru.status AS receiver_status
-FROM messages m
-JOIN users su ON su.id = m.sender_id
-JOIN users ru ON ru.id = m.receiver_id
-WHERE (m.sender_id = $1 AND m.receiver_id = $2) OR (m.sender_id = $2 AND m.receiver_id = $1)
-ORDER BY m.created_at DESC
-LIMIT 10
-
-		`, userID, chatID)
-		if err != nil {
+		conversationID, err := findDirectConversation(userID, chatID)
+		if err != nil || conversationID == 0 {
 			return nil, err
 		}
-		defer rows.Close()
-
-		var messages []models.ChatMessage
-		for rows.Next() {
-			var msg models.ChatMessage
-			if err := rows.Scan(
-	&msg.ID, &msg.SenderID, &msg.ReceiverID,
-	&msg.Content, &msg.CreatedAt,
-	&msg.SenderStatus, &msg.ReceiverStatus,
-); err != nil {
-	return nil, err
-}
-
-			messages = append(messages, msg)
-		}
-		return messages, nil
-
+		c = conversation{ID: conversationID, ChatType: "dm", SenderID: userID, ReceiverID: chatID}
 	case "group":
-		rows, err := database.DB.Query(`
-			SELECT gm.id, gm.group_id, gm.sender_id,
-       gm.content, gm.created_at,
-       su.status AS sender_status,
-       ru.status AS receiver_status
-FROM group_messages gm
-JOIN users su ON su.id = gm.sender_id
-JOIN users ru ON ru.id = $2
-WHERE gm.group_id = $1
-ORDER BY gm.created_at DESC
-LIMIT 10
-
-		`, chatID)
+		var err error
+		c, err = loadConversation(chatID)
 		if err != nil {
 			return nil, err
 		}
-		defer rows.Close()
-
-		var messages []models.ChatMessage
-		for rows.Next() {
-			var msg models.ChatMessage
-			if err := rows.Scan(
-	&msg.ID, &msg.GroupID, &msg.SenderID,
-	&msg.Content, &msg.CreatedAt,
-	&msg.SenderStatus, &msg.ReceiverStatus,
-); err != nil {
-	return nil, err
-}
-
-
-			messages = append(messages, msg)
+		if c.ChatType != "group" {
+			return nil, ErrConversationNotFound
 		}
-		return messages, nil
-
 	default:
-		return nil, errors.New("invalid chat type")
+		return nil, ErrInvalidChatType
 	}
+	return conversationMessages(c, userID)
 }
@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/ratelimit"
+)
+
+// Default send limits. Each can be changed with an environment variable
+// holding a rate such as "30/10s".
+var (
+	defaultUserSendRate         = ratelimit.Rate{Burst: 30, Per: 10 * time.Second}
+	defaultConversationSendRate = ratelimit.Rate{Burst: 20, Per: 10 * time.Second}
+	defaultNewAccountSendRate   = ratelimit.Rate{Burst: 10, Per: time.Minute}
+)
+
+// defaultNewAccountAge is how long an account gets the new-account limit
+const defaultNewAccountAge = 24 * time.Hour
+
+// allowedSlowModeSeconds are the slow mode intervals admins can pick
+var allowedSlowModeSeconds = map[int]bool{
+	10:   true,
+	30:   true,
+	60:   true,
+	300:  true,
+	900:  true,
+	3600: true,
+}
+
+var (
+	// ErrRateLimited is returned when a user sends messages faster than their limits allow
+	ErrRateLimited = errors.New("too many messages, slow down")
+	// ErrSlowMode is returned when a group member sends again before the slow mode interval is over
+	ErrSlowMode = errors.New("slow mode is on in this group")
+	// ErrInvalidSlowMode is returned for a slow mode interval that is not offered
+	ErrInvalidSlowMode = errors.New("slow mode must be 0, 10, 30, 60, 300, 900 or 3600 seconds")
+	// ErrSlowModeNotAllowed is returned when a group member who is not an admin changes slow mode
+	ErrSlowModeNotAllowed = errors.New("only group admins can change slow mode")
+)
+
+// sendRate reads a send limit from the environment, falling back to def when
+// the variable is unset or malformed
+func sendRate(name string, def ratelimit.Rate) ratelimit.Rate {
+	if rate, err := ratelimit.ParseRate(os.Getenv(name)); err == nil {
+		return rate
+	}
+	return def
+}
+
+// newAccountAge is how long an account gets the new-account limit. It is read
+// from NEW_ACCOUNT_AGE as a Go duration such as "24h".
+func newAccountAge() time.Duration {
+	if age, err := time.ParseDuration(os.Getenv("NEW_ACCOUNT_AGE")); err == nil && age >= 0 {
+		return age
+	}
+	return defaultNewAccountAge
+}
+
+// isNewAccount reports whether the user registered within newAccountAge
+func isNewAccount(userID int) (bool, error) {
+	var isNew bool
+	err := database.DB.QueryRow(`
+		SELECT created_at > NOW() - $2 * INTERVAL '1 second' FROM users WHERE id = $1
+	`, userID, newAccountAge().Seconds()).Scan(&isNew)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isNew, err
+}
+
+// RateLimitError is returned by controllers when a rate limit or slow mode
+// rejects a request. Wait is how long until it can be retried.
+type RateLimitError struct {
+	Wait time.Duration
+	Err  error
+}
+
+func (e *RateLimitError) Error() string { return e.Err.Error() }
+
+func (e *RateLimitError) Unwrap() error { return e.Err }
+
+// checkSendRate takes count tokens for each conversation in chatKeys from the
+// sender's buckets before messages are stored: from the user's bucket, from
+// each conversation's and, for accounts younger than newAccountAge, from a
+// stricter one. It takes from all of them or none. A chat key names the
+// conversation, which for a first DM may not exist yet. Limits are skipped
+// when Redis is not configured or fails, so an outage does not stop
+// messaging.
+func checkSendRate(ctx context.Context, userID int, count int, chatKeys ...string) (time.Duration, error) {
+	if database.RedisClient == nil || len(chatKeys) == 0 {
+		return 0, nil
+	}
+
+	isNew, err := isNewAccount(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	total := count * len(chatKeys)
+	buckets := []ratelimit.Bucket{
+		{Key: fmt.Sprintf("ratelimit:user:%d", userID), Rate: sendRate("RATE_LIMIT_USER", defaultUserSendRate), Cost: total},
+	}
+	for _, chatKey := range chatKeys {
+		buckets = append(buckets, ratelimit.Bucket{
+			Key:  "ratelimit:chat:" + chatKey,
+			Rate: sendRate("RATE_LIMIT_CONVERSATION", defaultConversationSendRate),
+			Cost: count,
+		})
+	}
+	if isNew {
+		buckets = append(buckets, ratelimit.Bucket{
+			Key:  fmt.Sprintf("ratelimit:new:%d", userID),
+			Rate: sendRate("RATE_LIMIT_NEW_ACCOUNT", defaultNewAccountSendRate),
+			Cost: total,
+		})
+	}
+
+	wait, err := ratelimit.NewRedisLimiter(database.RedisClient).Allow(ctx, buckets...)
+	if err != nil {
+		log.Printf("rate limit check failed for user %d: %v", userID, err)
+		return 0, nil
+	}
+	if wait > 0 {
+		return wait, ErrRateLimited
+	}
+	return 0, nil
+}
+
+// slowModeInterval returns the group's slow mode interval for the member, or
+// 0 when slow mode is off or they are an admin
+func slowModeInterval(groupID int, userID int) (time.Duration, error) {
+	var seconds int
+	var isAdmin bool
+	err := database.DB.QueryRow(`
+		SELECT g.slow_mode_seconds, COALESCE(cm.role = 'admin', FALSE)
+		FROM groups g
+		LEFT JOIN conversation_members cm ON cm.conversation_id = g.id AND cm.user_id = $2
+		WHERE g.id = $1
+	`, groupID, userID).Scan(&seconds, &isAdmin)
+	if err == sql.ErrNoRows || isAdmin {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// checkSlowMode reports how long is left of the member's slow mode interval.
+// It does not start one; startSlowMode does once the message is stored, so a
+// send that fails does not use up the interval.
+func checkSlowMode(ctx context.Context, groupID int, userID int) (time.Duration, error) {
+	if database.RedisClient == nil {
+		return 0, nil
+	}
+	interval, err := slowModeInterval(groupID, userID)
+	if err != nil || interval == 0 {
+		return 0, err
+	}
+
+	wait, err := database.RedisClient.PTTL(ctx, fmt.Sprintf("slowmode:%d:%d", groupID, userID)).Result()
+	if err != nil {
+		log.Printf("slow mode check failed for group %d: %v", groupID, err)
+		return 0, nil
+	}
+	if wait <= 0 {
+		return 0, nil
+	}
+	return min(wait, interval), ErrSlowMode
+}
+
+// startSlowMode starts the member's slow mode interval after a message to
+// the group is stored. Failures are logged and otherwise ignored.
+func startSlowMode(ctx context.Context, groupID int, userID int) {
+	if database.RedisClient == nil {
+		return
+	}
+	interval, err := slowModeInterval(groupID, userID)
+	if err == nil && interval > 0 {
+		err = database.RedisClient.Set(ctx, fmt.Sprintf("slowmode:%d:%d", groupID, userID), 1, interval).Err()
+	}
+	if err != nil {
+		log.Printf("could not start slow mode in group %d for user %d: %v", groupID, userID, err)
+	}
+}
+
+// checkSendLimits applies slow mode in each of groupIDs and the send limits
+// for count messages to each of chatKeys, for sends made outside an HTTP
+// handler. A rejection is returned as a RateLimitError.
+func checkSendLimits(userID int, count int, groupIDs []int, chatKeys ...string) error {
+	ctx := context.Background()
+	for _, groupID := range groupIDs {
+		if wait, err := checkSlowMode(ctx, groupID, userID); errors.Is(err, ErrSlowMode) {
+			return &RateLimitError{Wait: wait, Err: err}
+		} else if err != nil {
+			return err
+		}
+	}
+	if wait, err := checkSendRate(ctx, userID, count, chatKeys...); errors.Is(err, ErrRateLimited) {
+		return &RateLimitError{Wait: wait, Err: err}
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeRateLimited answers a send rejected by checkSendRate with 429 and a
+// Retry-After header
+func writeRateLimited(w http.ResponseWriter, wait time.Duration, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfterSeconds(wait)))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+// GetSlowMode returns the slow mode interval of a group the user belongs to
+func GetSlowMode(groupID int, userID int) (*models.SlowModeSettings, error) {
+	isMember, err := isGroupMember(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotParticipant
+	}
+
+	settings := &models.SlowModeSettings{GroupID: groupID}
+	err = database.DB.QueryRow(`
+		SELECT slow_mode_seconds FROM groups WHERE id = $1
+	`, groupID).Scan(&settings.Seconds)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSlowMode sets the minimum interval between one member's messages in
+// a group. Only admins can change it; 0 turns slow mode off.
+func UpdateSlowMode(groupID int, input models.SlowModeSettings, userID int) (*models.SlowModeSettings, error) {
+	if input.Seconds != 0 && !allowedSlowModeSeconds[input.Seconds] {
+		return nil, ErrInvalidSlowMode
+	}
+	isAdmin, err := isGroupAdmin(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		return nil, ErrSlowModeNotAllowed
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE groups SET slow_mode_seconds = $2 WHERE id = $1
+	`, groupID, input.Seconds); err != nil {
+		return nil, err
+	}
+
+	conv := conversation{ID: groupID, ChatType: "group", GroupID: groupID}
+	event := models.Event{
+		Type:     "group.slow_mode_updated",
+		ChatType: "group",
+		GroupID:  groupID,
+		UserID:   userID,
+		Data:     map[string]int{"seconds": input.Seconds},
+	}
+	if err := recordConversationEvent(tx, conv, event); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	events.Publish(conv.channel(), event)
+	return &models.SlowModeSettings{GroupID: groupID, Seconds: input.Seconds}, nil
+}
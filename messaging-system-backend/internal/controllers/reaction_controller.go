@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf8"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/events"
+	"messaging-system-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// maxDistinctReactions caps how many different emoji can be used on one message
+	maxDistinctReactions = 20
+	// maxEmojiBytes allows multi-codepoint emoji such as flags and skin tones
+	maxEmojiBytes = 32
+)
+
+var (
+	// ErrInvalidEmoji is returned when a reaction is not a single emoji-like token
+	ErrInvalidEmoji = errors.New("invalid emoji")
+	// ErrTooManyReactions is returned when a message already has the maximum number of distinct reactions
+	ErrTooManyReactions = errors.New("message already has the maximum number of different reactions")
+)
+
+// AddReaction adds the user's emoji reaction to a DM or group message
+func AddReaction(input models.ReactionInput, userID int) (map[string]string, error) {
+	if !validEmoji(input.Emoji) {
+		return nil, ErrInvalidEmoji
+	}
+
+	conv, err := authorizeMessage(input.MessageType, input.MessageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("failed to add reaction")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO message_reactions (message_type, message_id, user_id, emoji)
+		SELECT $1, $2, $3, $4
+		WHERE EXISTS (
+		        SELECT 1 FROM message_reactions
+		        WHERE message_type = $1 AND message_id = $2 AND emoji = $4
+		    )
+		   OR (
+		        SELECT COUNT(DISTINCT emoji) FROM message_reactions
+		        WHERE message_type = $1 AND message_id = $2
+		    ) < $5
+		ON CONFLICT (message_type, message_id, user_id, emoji) DO NOTHING
+	`, input.MessageType, input.MessageID, userID, input.Emoji, maxDistinctReactions)
+	if err != nil {
+		return nil, errors.New("failed to add reaction")
+	}
+
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		var exists bool
+		err = tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM message_reactions
+				WHERE message_type = $1 AND message_id = $2 AND user_id = $3 AND emoji = $4
+			)
+		`, input.MessageType, input.MessageID, userID, input.Emoji).Scan(&exists)
+		if err != nil {
+			return nil, errors.New("failed to add reaction")
+		}
+		if !exists {
+			return nil, ErrTooManyReactions
+		}
+	} else {
+		event := reactionEvent(conv, "reaction.added", input, userID)
+		if err := recordConversationEvent(tx, conv, event); err != nil {
+			return nil, errors.New("failed to add reaction")
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, errors.New("failed to add reaction")
+		}
+		events.Publish(conv.channel(), event)
+	}
+
+	return map[string]string{
+		"message": "Reaction added",
+	}, nil
+}
+
+// RemoveReaction removes the user's emoji reaction from a DM or group message
+func RemoveReaction(input models.ReactionInput, userID int) (map[string]string, error) {
+	conv, err := authorizeMessage(input.MessageType, input.MessageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, errors.New("failed to remove reaction")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		DELETE FROM message_reactions
+		WHERE message_type = $1 AND message_id = $2 AND user_id = $3 AND emoji = $4
+	`, input.MessageType, input.MessageID, userID, input.Emoji)
+	if err != nil {
+		return nil, errors.New("failed to remove reaction")
+	}
+
+	if affected, _ := res.RowsAffected(); affected > 0 {
+		event := reactionEvent(conv, "reaction.removed", input, userID)
+		if err := recordConversationEvent(tx, conv, event); err != nil {
+			return nil, errors.New("failed to remove reaction")
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, errors.New("failed to remove reaction")
+		}
+		events.Publish(conv.channel(), event)
+	}
+
+	return map[string]string{
+		"message": "Reaction removed",
+	}, nil
+}
+
+// reactionEvent describes a reaction change for the sync log and live clients
+func reactionEvent(conv conversation, eventType string, input models.ReactionInput, userID int) models.Event {
+	return models.Event{
+		Type:      eventType,
+		ChatType:  conv.ChatType,
+		GroupID:   conv.GroupID,
+		MessageID: input.MessageID,
+		UserID:    userID,
+		Data:      map[string]string{"emoji": input.Emoji},
+	}
+}
+
+// attachReactions loads aggregated reaction counts, flagged with the caller's
+// own reactions, for every message in one query
+func attachReactions(chatType string, messages []models.ChatMessage, userID int) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = $3) AS reacted_by_me
+		FROM message_reactions
+		WHERE message_type = $1 AND message_id = ANY($2)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at)
+	`, chatType, pq.Array(ids), userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	reactions := make(map[int][]models.ReactionCount)
+	for rows.Next() {
+		var messageID int
+		var rc models.ReactionCount
+		if err := rows.Scan(&messageID, &rc.Emoji, &rc.Count, &rc.ReactedByMe); err != nil {
+			return err
+		}
+		reactions[messageID] = append(reactions[messageID], rc)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+	return nil
+}
+
+// validEmoji performs a light sanity check on a reaction: a short printable
+// token with at least one non-ASCII symbol and no ASCII letters. Multi-codepoint
+// sequences such as flags, keycaps and skin tones are allowed.
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiBytes || !utf8.ValidString(emoji) {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || (r < utf8.RuneSelf && unicode.IsLetter(r)) {
+			return false
+		}
+		if r >= utf8.RuneSelf {
+			hasSymbol = true
+		}
+	}
+	return hasSymbol
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// replySnippetLength is the number of characters of the original message shown in a reply
+const replySnippetLength = 100
+
+// ErrInvalidReplyTarget is returned when a reply points outside of its conversation
+var ErrInvalidReplyTarget = errors.New("reply target not found in this conversation")
+
+// validateReply checks that a reply target belongs to the same conversation
+// and has not disappeared
+func validateReply(replyToID int, conversationID int) error {
+	var exists bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM conversation_messages
+			WHERE id = $1 AND conversation_id = $2
+			  AND (expires_at IS NULL OR expires_at > NOW())
+		)
+	`, replyToID, conversationID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrInvalidReplyTarget
+	}
+	return nil
+}
+
+// attachReplySnippets loads the quoted messages for every reply in one query.
+// Replies whose original has since been deleted or has disappeared get a
+// snippet marked as deleted.
+func attachReplySnippets(messages []models.ChatMessage) error {
+	var ids []int64
+	for _, msg := range messages {
+		if msg.ReplyToID != nil {
+			ids = append(ids, int64(*msg.ReplyToID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT m.id, m.sender_id, u.username, m.content
+		FROM conversation_messages m
+		JOIN users u ON u.id = m.sender_id
+		WHERE m.id = ANY($1)
+		  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	snippets := make(map[int]models.ReplySnippet)
+	for rows.Next() {
+		var s models.ReplySnippet
+		if err := rows.Scan(&s.ID, &s.SenderID, &s.SenderUsername, &s.Content); err != nil {
+			return err
+		}
+		s.Content = truncateRunes(s.Content, replySnippetLength)
+		snippets[s.ID] = s
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if messages[i].ReplyToID == nil {
+			continue
+		}
+		id := *messages[i].ReplyToID
+		snippet, ok := snippets[id]
+		if !ok {
+			snippet = models.ReplySnippet{ID: id, Deleted: true}
+		}
+		messages[i].ReplyTo = &snippet
+	}
+	return nil
+}
+
+// truncateRunes shortens s to at most n characters, adding an ellipsis when cut
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
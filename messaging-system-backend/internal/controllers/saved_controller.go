@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+)
+
+const (
+	maxSavedNoteLength = 500
+
+	defaultSavedPageSize = 20
+	maxSavedPageSize     = 100
+)
+
+// ErrSavedNoteTooLong is returned when the note on a saved message exceeds the maximum length
+var ErrSavedNoteTooLong = errors.New("note is too long")
+
+// SaveMessage bookmarks a DM or group message for the user. Saving a message
+// again replaces its note.
+func SaveMessage(input models.SaveMessageInput, userID int) (map[string]string, error) {
+	if utf8.RuneCountInString(input.Note) > maxSavedNoteLength {
+		return nil, ErrSavedNoteTooLong
+	}
+	if _, err := authorizeMessage(input.MessageType, input.MessageID, userID); err != nil {
+		return nil, err
+	}
+
+	_, err := database.DB.Exec(`
+		INSERT INTO saved_messages (user_id, message_type, message_id, note)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, message_type, message_id) DO UPDATE SET note = EXCLUDED.note
+	`, userID, input.MessageType, input.MessageID, input.Note)
+	if err != nil {
+		return nil, errors.New("failed to save message")
+	}
+
+	return map[string]string{
+		"message": "Message saved",
+	}, nil
+}
+
+// UnsaveMessage removes a message from the user's saved messages
+func UnsaveMessage(input models.SaveMessageInput, userID int) (map[string]string, error) {
+	_, err := database.DB.Exec(`
+		DELETE FROM saved_messages
+		WHERE user_id = $1 AND message_type = $2 AND message_id = $3
+	`, userID, input.MessageType, input.MessageID)
+	if err != nil {
+		return nil, errors.New("failed to remove saved message")
+	}
+
+	return map[string]string{
+		"message": "Message removed from saved",
+	}, nil
+}
+
+// GetSavedMessages returns a page of the user's saved messages, newest first,
+// starting before the saved item ID given as cursor. When chatType is set the
+// list is limited to one DM (chatID is the other user) or group. Messages
+// from groups the user no longer belongs to are hidden.
+func GetSavedMessages(userID int, chatType string, chatID int, before int, limit int) (*models.SavedMessagesPage, error) {
+	if chatType != "" && chatType != "dm" && chatType != "group" {
+		return nil, ErrInvalidChatType
+	}
+	if limit <= 0 {
+		limit = defaultSavedPageSize
+	}
+	if limit > maxSavedPageSize {
+		limit = maxSavedPageSize
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT s.id, s.message_type, s.message_id,
+		       CASE WHEN c.type = 'group' THEN c.id END,
+		       CASE WHEN c.type = 'dm' THEN
+		           CASE WHEN c.dm_user_low = m.sender_id THEN c.dm_user_high ELSE c.dm_user_low END
+		       END,
+		       m.sender_id, u.username, m.content, m.created_at,
+		       s.note, s.created_at
+		FROM saved_messages s
+		JOIN conversation_messages m ON m.id = s.message_id
+		JOIN conversations c ON c.id = m.conversation_id AND c.type = s.message_type
+		JOIN conversation_members mem ON mem.conversation_id = c.id AND mem.user_id = s.user_id
+		JOIN users u ON u.id = m.sender_id
+		WHERE s.user_id = $1
+		  AND ($2 = 0 OR s.id < $2)
+		  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+		  AND (
+		        $3 = ''
+		     OR ($3 = 'dm' AND c.type = 'dm' AND $4 IN (c.dm_user_low, c.dm_user_high))
+		     OR ($3 = 'group' AND c.type = 'group' AND c.id = $4)
+		  )
+		ORDER BY s.id DESC
+		LIMIT $5
+	`, userID, before, chatType, chatID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.SavedMessagesPage{Items: []models.SavedMessage{}}
+	for rows.Next() {
+		var item models.SavedMessage
+		if err := rows.Scan(
+			&item.ID, &item.MessageType, &item.MessageID, &item.GroupID, &item.ReceiverID,
+			&item.SenderID, &item.SenderUsername,
+			&item.Content, &item.MessageCreatedAt,
+			&item.Note, &item.SavedAt,
+		); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		next := page.Items[limit-1].ID
+		page.NextCursor = &next
+	}
+	return page, nil
+}
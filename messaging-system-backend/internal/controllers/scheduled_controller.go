@@ -0,0 +1,370 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/internal/spam"
+)
+
+// maxScheduleAhead is how far in the future a message can be scheduled
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+var (
+	// ErrInvalidSendAt is returned when send_at is in the past or too far ahead
+	ErrInvalidSendAt = errors.New("send_at must be in the future and within one year")
+	// ErrEmptyScheduledMessage is returned when a scheduled message has no content
+	ErrEmptyScheduledMessage = errors.New("content cannot be empty")
+	// ErrScheduledNotPending is returned when editing or cancelling a message that was already sent or cancelled
+	ErrScheduledNotPending = errors.New("scheduled message is no longer pending")
+)
+
+// validateScheduleTarget checks that the user can currently post to the DM or group
+func validateScheduleTarget(chatType string, chatID int, userID int) error {
+	switch chatType {
+	case "dm":
+		var exists bool
+		err := database.DB.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)
+		`, chatID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrMessageNotFound
+		}
+	case "group":
+		isMember, err := isGroupMember(chatID, userID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrNotParticipant
+		}
+	default:
+		return ErrInvalidChatType
+	}
+	return nil
+}
+
+// validateSendAt checks that a delivery time is in the future and within the allowed window
+func validateSendAt(sendAt time.Time) error {
+	now := time.Now()
+	if !sendAt.After(now) || sendAt.After(now.Add(maxScheduleAhead)) {
+		return ErrInvalidSendAt
+	}
+	return nil
+}
+
+// ScheduleMessage stores a DM or group message to be sent at input.SendAt
+func ScheduleMessage(input models.ScheduleMessageInput, userID int) (*models.ScheduledMessage, error) {
+	if strings.TrimSpace(input.Content) == "" {
+		return nil, ErrEmptyScheduledMessage
+	}
+	if _, _, err := formatContent(input.Content); err != nil {
+		return nil, err
+	}
+	if err := validateSendAt(input.SendAt); err != nil {
+		return nil, err
+	}
+	if err := validateScheduleTarget(input.ChatType, input.ChatID, userID); err != nil {
+		return nil, err
+	}
+
+	msg := &models.ScheduledMessage{}
+	err := database.DB.QueryRow(`
+		INSERT INTO scheduled_messages (sender_id, chat_type, chat_id, content, send_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, sender_id, chat_type, chat_id, content, send_at, status, created_at, updated_at
+	`, userID, input.ChatType, input.ChatID, input.Content, input.SendAt).Scan(
+		&msg.ID, &msg.SenderID, &msg.ChatType, &msg.ChatID, &msg.Content, &msg.SendAt,
+		&msg.Status, &msg.CreatedAt, &msg.UpdatedAt,
+	)
+	if err != nil {
+		return nil, errors.New("failed to schedule message")
+	}
+	return msg, nil
+}
+
+// GetScheduledMessages lists the user's scheduled messages in delivery order.
+// Only pending messages are returned unless includeDone is set.
+func GetScheduledMessages(userID int, includeDone bool) ([]models.ScheduledMessage, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, sender_id, chat_type, chat_id, content, send_at, status,
+		       COALESCE(failure_reason, ''), sent_message_id, sent_at, created_at, updated_at
+		FROM scheduled_messages
+		WHERE sender_id = $1 AND ($2 OR status = 'pending')
+		ORDER BY send_at, id
+	`, userID, includeDone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.ScheduledMessage{}
+	for rows.Next() {
+		var msg models.ScheduledMessage
+		if err := rows.Scan(
+			&msg.ID, &msg.SenderID, &msg.ChatType, &msg.ChatID, &msg.Content, &msg.SendAt, &msg.Status,
+			&msg.FailureReason, &msg.SentMessageID, &msg.SentAt, &msg.CreatedAt, &msg.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// UpdateScheduledMessage changes the content or delivery time of a pending message
+func UpdateScheduledMessage(id int, input models.UpdateScheduledMessageInput, userID int) (map[string]string, error) {
+	if input.Content != nil && strings.TrimSpace(*input.Content) == "" {
+		return nil, ErrEmptyScheduledMessage
+	}
+	if input.Content != nil {
+		if _, _, err := formatContent(*input.Content); err != nil {
+			return nil, err
+		}
+	}
+	if input.SendAt != nil {
+		if err := validateSendAt(*input.SendAt); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := database.DB.Exec(`
+		UPDATE scheduled_messages
+		SET content = COALESCE($3, content),
+		    send_at = COALESCE($4, send_at),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND sender_id = $2 AND status = 'pending'
+	`, id, userID, input.Content, input.SendAt)
+	if err != nil {
+		return nil, errors.New("failed to update scheduled message")
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, scheduledNotUpdatedError(id, userID)
+	}
+
+	return map[string]string{
+		"message": "Scheduled message updated",
+	}, nil
+}
+
+// CancelScheduledMessage cancels a pending scheduled message
+func CancelScheduledMessage(id int, userID int) (map[string]string, error) {
+	res, err := database.DB.Exec(`
+		UPDATE scheduled_messages
+		SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND sender_id = $2 AND status = 'pending'
+	`, id, userID)
+	if err != nil {
+		return nil, errors.New("failed to cancel scheduled message")
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, scheduledNotUpdatedError(id, userID)
+	}
+
+	return map[string]string{
+		"message": "Scheduled message cancelled",
+	}, nil
+}
+
+// scheduledNotUpdatedError explains why an edit or cancel matched no pending row
+func scheduledNotUpdatedError(id int, userID int) error {
+	var exists bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM scheduled_messages WHERE id = $1 AND sender_id = $2)
+	`, id, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrMessageNotFound
+	}
+	return ErrScheduledNotPending
+}
+
+// ScheduledStore implements workers.ScheduledStore on top of the scheduled_messages table
+type ScheduledStore struct{}
+
+// DueScheduledMessages returns the IDs of pending messages that are due at now
+func (ScheduledStore) DueScheduledMessages(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	rows, err := database.DB.QueryContext(ctx, `
+		SELECT id FROM scheduled_messages
+		WHERE status = 'pending' AND send_at <= $1
+		ORDER BY send_at, id
+		LIMIT $2
+	`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DispatchScheduledMessage sends a due message. Group membership is checked
+// again at send time; a sender who left the group gets the message marked
+// failed instead of delivered. A send the rate limits or slow mode reject is
+// postponed until they allow it, and one the spam checks reject is marked
+// failed. The row is locked so it is sent only once.
+func (ScheduledStore) DispatchScheduledMessage(ctx context.Context, id int, now time.Time) error {
+	tx, err := database.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var msg models.ScheduledMessage
+	err = tx.QueryRowContext(ctx, `
+		SELECT sender_id, chat_type, chat_id, content
+		FROM scheduled_messages
+		WHERE id = $1 AND status = 'pending' AND send_at <= $2
+		FOR UPDATE SKIP LOCKED
+	`, id, now).Scan(&msg.SenderID, &msg.ChatType, &msg.ChatID, &msg.Content)
+	if err == sql.ErrNoRows {
+		// Sent, cancelled or rescheduled in the meantime
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	fail := func(reason string) error {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE scheduled_messages
+			SET status = 'failed', failure_reason = $2, updated_at = $3
+			WHERE id = $1
+		`, id, reason, now)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// Content is stored as written and formatted when it is sent
+	content, entities, err := formatContent(msg.Content)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	var limitErr error
+	if msg.ChatType == "group" {
+		limitErr = checkSendLimits(msg.SenderID, 1, []int{msg.ChatID}, fmt.Sprintf("group:%d", msg.ChatID))
+	} else {
+		chatKey := fmt.Sprintf("dm:%d:%d", min(msg.SenderID, msg.ChatID), max(msg.SenderID, msg.ChatID))
+		limitErr = checkSendLimits(msg.SenderID, 1, nil, chatKey)
+	}
+	var rateErr *RateLimitError
+	if errors.As(limitErr, &rateErr) {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE scheduled_messages SET send_at = $2, updated_at = $3 WHERE id = $1
+		`, id, now.Add(rateErr.Wait), now)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	} else if limitErr != nil {
+		return limitErr
+	}
+
+	// Screened at send time so duplicates and first contacts are counted then
+	recipient, firstContact := fmt.Sprintf("group:%d", msg.ChatID), false
+	if msg.ChatType == "dm" {
+		conversationID, err := findDirectConversation(msg.SenderID, msg.ChatID)
+		if err != nil {
+			return err
+		}
+		recipient, firstContact = fmt.Sprintf("dm:%d", msg.ChatID), conversationID == 0
+	}
+	screening, err := checkSpam(ctx, msg.SenderID, recipient, msg.Content, firstContact)
+	if err != nil {
+		return err
+	}
+	if screening.Verdict == spam.Reject {
+		return fail("message rejected: " + screening.Reason)
+	}
+
+	var conv conversation
+	var resolved resolvedMentions
+	switch msg.ChatType {
+	case "dm":
+		conv, err = ensureDirectConversation(tx, msg.SenderID, msg.ChatID)
+		if err != nil {
+			return err
+		}
+	case "group":
+		var isMember bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM conversation_members cm
+				JOIN conversations c ON c.id = cm.conversation_id
+				WHERE cm.conversation_id = $1 AND cm.user_id = $2 AND c.type = 'group'
+			)
+		`, msg.ChatID, msg.SenderID).Scan(&isMember)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return fail("sender is no longer a member of this group")
+		}
+
+		resolved, err = resolveMentions(msg.ChatID, msg.SenderID, content)
+		if errors.Is(err, ErrAllMentionRestricted) {
+			return fail(err.Error())
+		} else if err != nil {
+			return err
+		}
+
+		conv = conversation{ID: msg.ChatID, ChatType: "group", GroupID: msg.ChatID}
+	default:
+		return fail("unknown chat type")
+	}
+
+	sentID, _, err := insertMessage(tx, conv, newMessage{SenderID: msg.SenderID, Content: content, Entities: entities})
+	if err != nil {
+		return err
+	}
+	if err := queueForModeration(tx, sentID, msg.SenderID, screening); err != nil {
+		return err
+	}
+	if err := queueLinkPreviews(tx, msg.ChatType, sentID, msg.Content); err != nil {
+		return err
+	}
+	if msg.ChatType == "group" {
+		if err := saveMentions(tx, sentID, resolved); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE scheduled_messages
+		SET status = 'sent', sent_message_id = $2, sent_at = $3, updated_at = $3
+		WHERE id = $1
+	`, id, sentID, now)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if msg.ChatType == "group" {
+		startSlowMode(ctx, msg.ChatID, msg.SenderID)
+		publishMentionEvents(msg.ChatID, sentID, msg.SenderID, resolved)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+)
+
+const (
+	maxSearchQueryLength = 256
+
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 50
+
+	// snippetStart and snippetStop mark the matching words in a ts_headline
+	// snippet until it is escaped as HTML and they become <mark> tags. They
+	// are stripped from the content first so messages cannot forge them.
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+var (
+	// ErrInvalidSearchQuery is returned when the search text is empty or too long
+	ErrInvalidSearchQuery = errors.New("q must be between 1 and 256 characters")
+	// ErrInvalidSearchCursor is returned when a pagination cursor cannot be decoded
+	ErrInvalidSearchCursor = errors.New("invalid cursor")
+	// ErrInvalidSearchOrder is returned for an unknown result ordering
+	ErrInvalidSearchOrder = errors.New("order must be relevance or recency")
+)
+
+// searchCursor is the position of the last result on a page. Kind orders DMs
+// (0) before group messages (1) when two results share a timestamp.
+type searchCursor struct {
+	Rank      float64   `json:"r"`
+	CreatedAt time.Time `json:"t"`
+	Kind      int       `json:"k"`
+	ID        int       `json:"i"`
+}
+
+func encodeSearchCursor(c searchCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(s string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidSearchCursor
+	}
+	var c searchCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidSearchCursor
+	}
+	return &c, nil
+}
+
+// searchMatchesSQL selects the DM and group messages matching the query that
+// the user can read right now, i.e. in conversations they are currently a
+// member of. Expired disappearing messages are excluded.
+const searchMatchesSQL = `
+	WITH q AS (
+		SELECT websearch_to_tsquery('english', $2) AS query
+	),
+	hits AS (
+		SELECT c.type AS chat_type,
+		       CASE WHEN c.type = 'dm' THEN 0 ELSE 1 END AS kind,
+		       m.id,
+		       CASE WHEN c.type = 'group' THEN c.id END AS group_id,
+		       CASE WHEN c.type = 'dm' THEN
+		           CASE WHEN c.dm_user_low = m.sender_id THEN c.dm_user_high ELSE c.dm_user_low END
+		       END AS receiver_id,
+		       m.sender_id, m.content, m.created_at,
+		       ts_rank(m.search_vector, q.query)::float8 AS rank
+		FROM conversation_messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		JOIN conversation_members mem ON mem.conversation_id = m.conversation_id AND mem.user_id = $1
+		CROSS JOIN q
+		WHERE m.search_vector @@ q.query
+		  AND NOT c.encrypted
+		  AND (
+		        $4 = ''
+		     OR ($4 = 'dm' AND c.type = 'dm' AND $5 IN (c.dm_user_low, c.dm_user_high))
+		     OR ($4 = 'group' AND c.type = 'group' AND c.id = $5)
+		  )
+		  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+	),
+	page AS (
+		SELECT * FROM hits h
+		WHERE ($3 = 0 OR h.sender_id = $3)
+		  AND ($6::timestamp IS NULL OR h.created_at >= $6)
+		  AND ($7::timestamp IS NULL OR h.created_at < $7)
+		  AND ($8::boolean IS NULL OR $8 = EXISTS (
+		          SELECT 1 FROM attachments a
+		          WHERE a.message_type = h.chat_type AND a.message_id = h.id
+		      ))
+`
+
+// SearchMessages runs a full-text search over the DMs and groups the user
+// can access. Results are ordered by relevance or recency and paged with an
+// opaque cursor; snippets highlight the matching words with <mark> tags.
+// Encrypted conversations are left out, as the server cannot read them.
+func SearchMessages(query models.MessageSearchQuery, userID int) (*models.MessageSearchPage, error) {
+	query.Query = strings.TrimSpace(query.Query)
+	if query.Query == "" || utf8.RuneCountInString(query.Query) > maxSearchQueryLength {
+		return nil, ErrInvalidSearchQuery
+	}
+	if query.ChatType != "" && query.ChatType != "dm" && query.ChatType != "group" {
+		return nil, ErrInvalidChatType
+	}
+	if query.Order == "" {
+		query.Order = "relevance"
+	}
+	if query.Order != "relevance" && query.Order != "recency" {
+		return nil, ErrInvalidSearchOrder
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchPageSize
+	}
+	if query.Limit > maxSearchPageSize {
+		query.Limit = maxSearchPageSize
+	}
+
+	var cursor *searchCursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = decodeSearchCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	var from, to *time.Time
+	if query.From != nil {
+		t := query.From.UTC()
+		from = &t
+	}
+	if query.To != nil {
+		t := query.To.UTC()
+		to = &t
+	}
+
+	args := []interface{}{
+		userID, query.Query, query.SenderID, query.ChatType, query.ChatID,
+		from, to, query.HasAttachment, query.Limit + 1,
+	}
+
+	var order, after string
+	if query.Order == "relevance" {
+		order = "rank DESC, created_at DESC, kind DESC, id DESC"
+		after = "(h.rank, h.created_at, h.kind, h.id) < ($10::float8, $11::timestamp, $12::int, $13::int)"
+		if cursor != nil {
+			args = append(args, cursor.Rank, cursor.CreatedAt, cursor.Kind, cursor.ID)
+		}
+	} else {
+		order = "created_at DESC, kind DESC, id DESC"
+		after = "(h.created_at, h.kind, h.id) < ($10::timestamp, $11::int, $12::int)"
+		if cursor != nil {
+			args = append(args, cursor.CreatedAt, cursor.Kind, cursor.ID)
+		}
+	}
+	if cursor == nil {
+		after = "TRUE"
+	}
+
+	rows, err := database.DB.Query(searchMatchesSQL+`
+		  AND `+after+`
+		ORDER BY `+order+`
+		LIMIT $9
+	)
+	SELECT chat_type, kind, id, group_id, receiver_id, sender_id, content, created_at, rank,
+	       ts_headline('english', translate(content, chr(2) || chr(3), ''), q.query,
+	                   'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxFragments=2, MinWords=5, MaxWords=20')
+	FROM page
+	CROSS JOIN q
+	ORDER BY `+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.MessageSearchPage{Results: []models.MessageSearchResult{}}
+	var last searchCursor
+	for rows.Next() {
+		var r models.MessageSearchResult
+		var kind int
+		if err := rows.Scan(
+			&r.ChatType, &kind, &r.MessageID, &r.GroupID, &r.ReceiverID, &r.SenderID,
+			&r.Content, &r.CreatedAt, &r.Rank, &r.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		if len(page.Results) == query.Limit {
+			page.NextCursor = encodeSearchCursor(last)
+			break
+		}
+		r.Snippet = highlightSnippet(r.Snippet)
+		page.Results = append(page.Results, r)
+		last = searchCursor{Rank: r.Rank, CreatedAt: r.CreatedAt, Kind: kind, ID: r.MessageID}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// highlightSnippet escapes a ts_headline snippet as HTML and turns its
+// highlight markers into <mark> tags
+func highlightSnippet(snippet string) string {
+	return strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>").Replace(html.EscapeString(snippet))
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/spam"
+)
+
+// Spam check thresholds. Duplicates count the distinct conversations that
+// received the same text, links use spam.LinkScore and first contacts count
+// the DM conversations a new account started.
+const (
+	duplicateWindow      = 10 * time.Minute
+	duplicateMinLength   = 20
+	duplicateFlagAt      = 5
+	duplicateRejectAt    = 20
+	linkFlagAt           = 4
+	linkRejectAt         = 8
+	firstContactWindow   = 24 * time.Hour
+	firstContactFlagAt   = 5
+	firstContactRejectAt = 10
+)
+
+// spamChecks returns the enabled checks in the order they run. SPAM_CHECKS
+// holds a comma-separated list of check names to enable; unset enables all
+// of them and "none" turns them off.
+func spamChecks() []spam.Check {
+	all := []spam.Check{
+		spam.LinkCheck{FlagAt: linkFlagAt, RejectAt: linkRejectAt},
+		spam.FirstContactCheck{
+			Store:    SpamStore{},
+			Window:   firstContactWindow,
+			FlagAt:   firstContactFlagAt,
+			RejectAt: firstContactRejectAt,
+		},
+		spam.DuplicateCheck{
+			Store:     SpamStore{},
+			Window:    duplicateWindow,
+			MinLength: duplicateMinLength,
+			FlagAt:    duplicateFlagAt,
+			RejectAt:  duplicateRejectAt,
+		},
+	}
+	checks, unknown := spam.Enabled(all, os.Getenv("SPAM_CHECKS"))
+	if len(unknown) > 0 {
+		log.Printf("SPAM_CHECKS names unknown checks: %v", unknown)
+	}
+	return checks
+}
+
+// checkSpam runs the spam checks on a message before it is stored. recipient
+// names the DM receiver or the group, and firstContact is set for a DM to
+// someone the sender has never messaged. content is the text as sent, so
+// markdown link targets are scored too.
+func checkSpam(ctx context.Context, senderID int, recipient string, content string, firstContact bool) (spam.Result, error) {
+	isNew, err := isNewAccount(senderID)
+	if err != nil {
+		return spam.Result{}, err
+	}
+
+	result, err := spam.NewPipeline(spamChecks()...).Run(ctx, spam.Message{
+		SenderID:     senderID,
+		Recipient:    recipient,
+		Content:      content,
+		FirstContact: firstContact,
+		NewAccount:   isNew,
+	})
+	if err != nil {
+		log.Printf("spam checks skipped for user %d: %v", senderID, err)
+	}
+	return result, nil
+}
+
+// queueForModeration adds a flagged message to the moderation queue. Other
+// verdicts are ignored.
+func queueForModeration(tx *sql.Tx, messageID int, senderID int, result spam.Result) error {
+	if result.Verdict != spam.Flag {
+		return nil
+	}
+	_, err := tx.Exec(`
+		INSERT INTO moderation_queue (message_id, sender_id, check_name, reason)
+		VALUES ($1, $2, $3, $4)
+	`, messageID, senderID, result.Check, result.Reason)
+	return err
+}
+
+// SpamRejectedError is returned by sends made outside an HTTP handler when
+// the spam checks reject them
+type SpamRejectedError struct {
+	Result spam.Result
+}
+
+func (e *SpamRejectedError) Error() string { return "message rejected: " + e.Result.Reason }
+
+// writeSpamRejected answers a send rejected by the spam checks
+func writeSpamRejected(w http.ResponseWriter, result spam.Result) {
+	http.Error(w, "message rejected: "+result.Reason, http.StatusUnprocessableEntity)
+}
+
+// errRedisUnavailable is returned by SpamStore when Redis is not configured
+var errRedisUnavailable = errors.New("redis is not configured")
+
+// SpamStore implements the spam check stores on top of Redis and the
+// conversations table
+type SpamStore struct{}
+
+// RecordRecipient keeps the recipients of each sender's text in a Redis set
+// that expires window after the latest copy
+func (SpamStore) RecordRecipient(ctx context.Context, senderID int, fingerprint string, recipient string, window time.Duration) (int, error) {
+	if database.RedisClient == nil {
+		return 0, errRedisUnavailable
+	}
+	key := fmt.Sprintf("spam:dup:%d:%s", senderID, fingerprint)
+	pipe := database.RedisClient.TxPipeline()
+	pipe.SAdd(ctx, key, recipient)
+	pipe.Expire(ctx, key, window)
+	count := pipe.SCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// FirstContacts counts the DM conversations created within window whose
+// first message came from the sender
+func (SpamStore) FirstContacts(ctx context.Context, senderID int, window time.Duration) (int, error) {
+	var count int
+	err := database.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id = $1
+		WHERE c.type = 'dm' AND c.created_at > NOW() - $2 * INTERVAL '1 second'
+		  AND (
+			SELECT m.sender_id FROM conversation_messages m
+			WHERE m.conversation_id = c.id
+			ORDER BY m.id
+			LIMIT 1
+		  ) = $1
+	`, senderID, window.Seconds()).Scan(&count)
+	return count, err
+}
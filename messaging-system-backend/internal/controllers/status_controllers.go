@@ -23,7 +23,13 @@ func GetUserStatus(userID int) (string, error) {
 
 // UpdateUserStatus attempts to update the user’s status using optimistic concurrency.
 func UpdateUserStatus(userID int, newStatus string, lastUpdatedAt time.Time) error {
-	result, err := database.DB.Exec(`
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
 		UPDATE users
 		SET status = $1, updated_at = CURRENT_TIMESTAMP
 		WHERE id = $2 AND updated_at = $3
@@ -42,5 +48,9 @@ func UpdateUserStatus(userID int, newStatus string, lastUpdatedAt time.Time) err
 		return errors.New("status update conflict: data was modified by another process")
 	}
 
-	return nil
+	if err := recordStatusEvent(tx, userID, newStatus); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
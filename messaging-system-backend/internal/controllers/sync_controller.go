@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultSyncPageSize = 100
+	maxSyncPageSize     = 500
+
+	defaultSyncRetention = 7 * 24 * time.Hour
+)
+
+// ErrInvalidSyncSeq is returned for a negative since value
+var ErrInvalidSyncSeq = errors.New("since must be a non-negative sequence number")
+
+// dbRunner is satisfied by both *sql.DB and *sql.Tx, so sync events can be
+// recorded inside the transaction that makes the change when there is one
+type dbRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// syncRetention is how long sync events are kept before compaction. It is
+// read from SYNC_RETENTION as a Go duration such as "168h".
+func syncRetention() time.Duration {
+	if retention, err := time.ParseDuration(os.Getenv("SYNC_RETENTION")); err == nil && retention > 0 {
+		return retention
+	}
+	return defaultSyncRetention
+}
+
+// conversationUserIDs returns the users whose sync log receives events for a
+// conversation: both DM participants or the current group members
+func conversationUserIDs(q dbRunner, conv conversation) ([]int, error) {
+	if conv.ChatType != "group" {
+		return []int{conv.SenderID, conv.ReceiverID}, nil
+	}
+
+	rows, err := q.Query(`
+		SELECT user_id FROM conversation_members WHERE conversation_id = $1
+	`, conv.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// recordConversationEvent appends an event to the sync log of every participant of the conversation
+func recordConversationEvent(q dbRunner, conv conversation, event models.Event) error {
+	userIDs, err := conversationUserIDs(q, conv)
+	if err != nil {
+		return err
+	}
+	return recordSyncEvent(q, userIDs, event)
+}
+
+// recordSyncEvent appends an event to the sync log of each user. Sequence
+// numbers come from each user's user_sync_state row, which stays locked
+// until the surrounding transaction ends, so every user sees their events
+// committed in sequence order without gaps.
+func recordSyncEvent(q dbRunner, userIDs []int, event models.Event) error {
+	seen := make(map[int]bool, len(userIDs))
+	ids := make([]int64, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID != 0 && !seen[userID] {
+			seen[userID] = true
+			ids = append(ids, int64(userID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	// Lock in a stable order so concurrent events for overlapping users cannot deadlock
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// A nil *string is sent as NULL; an empty []byte would not be valid JSON
+	var data *string
+	if event.Data != nil {
+		encoded, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		s := string(encoded)
+		data = &s
+	}
+
+	_, err := q.Exec(`
+		INSERT INTO user_sync_state (user_id)
+		SELECT UNNEST($1::int[])
+		ON CONFLICT (user_id) DO NOTHING
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`
+		WITH locked AS (
+			SELECT user_id FROM user_sync_state
+			WHERE user_id = ANY($1)
+			ORDER BY user_id
+			FOR UPDATE
+		),
+		bumped AS (
+			UPDATE user_sync_state s
+			SET last_seq = s.last_seq + 1
+			FROM locked
+			WHERE s.user_id = locked.user_id
+			RETURNING s.user_id, s.last_seq
+		)
+		INSERT INTO user_events (user_id, seq, type, chat_type, group_id, message_id, actor_id, data)
+		SELECT user_id, last_seq, $2, $3, NULLIF($4, 0), NULLIF($5, 0), NULLIF($6, 0), $7::jsonb
+		FROM bumped
+	`, pq.Array(ids), event.Type, event.ChatType, event.GroupID, event.MessageID, event.UserID, data)
+	return err
+}
+
+// GetSyncEvents returns the user's events after the since sequence number,
+// oldest first. A since value older than the compacted part of the log, or
+// ahead of the log, asks the client for a full resync.
+func GetSyncEvents(userID int, since int64, limit int) (*models.SyncPage, error) {
+	if since < 0 {
+		return nil, ErrInvalidSyncSeq
+	}
+	if limit <= 0 {
+		limit = defaultSyncPageSize
+	}
+	if limit > maxSyncPageSize {
+		limit = maxSyncPageSize
+	}
+
+	var lastSeq, compactedSeq int64
+	err := database.DB.QueryRow(`
+		SELECT last_seq, compacted_seq FROM user_sync_state WHERE user_id = $1
+	`, userID).Scan(&lastSeq, &compactedSeq)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	page := &models.SyncPage{Events: []models.SyncEvent{}, NextSeq: since}
+	if since < compactedSeq || since > lastSeq {
+		page.ResyncRequired = true
+		page.NextSeq = lastSeq
+		return page, nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT seq, type, chat_type, COALESCE(group_id, 0), COALESCE(message_id, 0),
+		       COALESCE(actor_id, 0), data, created_at
+		FROM user_events
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`, userID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.SyncEvent
+		var data []byte
+		if err := rows.Scan(
+			&e.Seq, &e.Type, &e.ChatType, &e.GroupID, &e.MessageID,
+			&e.UserID, &data, &e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if data != nil {
+			e.Data = json.RawMessage(data)
+		}
+		page.Events = append(page.Events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+		page.HasMore = true
+	}
+	if len(page.Events) > 0 {
+		page.NextSeq = page.Events[len(page.Events)-1].Seq
+	}
+	return page, nil
+}
+
+// CompactSyncEvents deletes up to limit events older than the retention
+// period and remembers the highest deleted sequence number of each user, so
+// clients that were offline longer are told to resync. It returns how many
+// events were deleted.
+func CompactSyncEvents(ctx context.Context, limit int) (int, error) {
+	var deleted int
+	err := database.DB.QueryRowContext(ctx, `
+		WITH doomed AS (
+			SELECT user_id, seq FROM user_events
+			WHERE created_at <= CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'
+			ORDER BY created_at
+			LIMIT $2
+		),
+		deleted AS (
+			DELETE FROM user_events e
+			USING doomed d
+			WHERE e.user_id = d.user_id AND e.seq = d.seq
+			RETURNING e.user_id, e.seq
+		),
+		compacted AS (
+			UPDATE user_sync_state s
+			SET compacted_seq = GREATEST(s.compacted_seq, c.seq)
+			FROM (SELECT user_id, MAX(seq) AS seq FROM deleted GROUP BY user_id) c
+			WHERE s.user_id = c.user_id
+		)
+		SELECT COUNT(*) FROM deleted
+	`, syncRetention().Seconds(), limit).Scan(&deleted)
+	return deleted, err
+}
+
+// recordMessageEvent logs a new or edited message for every participant.
+// The event names the message without its content, which clients fetch by
+// ID, so the log never keeps text that was deleted or has disappeared.
+func recordMessageEvent(q dbRunner, eventType string, conv conversation, messageID int) error {
+	var data interface{}
+	if conv.ChatType != "group" {
+		data = map[string]int{"receiver_id": conv.ReceiverID}
+	}
+	return recordConversationEvent(q, conv, models.Event{
+		Type:      eventType,
+		ChatType:  conv.ChatType,
+		GroupID:   conv.GroupID,
+		MessageID: messageID,
+		UserID:    conv.SenderID,
+		Data:      data,
+	})
+}
+
+// recordMembershipEvent logs a membership change for the current group
+// members and for the affected user, who may just have left the group
+func recordMembershipEvent(q dbRunner, eventType string, groupID int, actorID int, targetID int) error {
+	userIDs, err := conversationUserIDs(q, conversation{ChatType: "group", GroupID: groupID})
+	if err != nil {
+		return err
+	}
+	return recordSyncEvent(q, append(userIDs, targetID), models.Event{
+		Type:     eventType,
+		ChatType: "group",
+		GroupID:  groupID,
+		UserID:   actorID,
+		Data:     map[string]int{"member_id": targetID},
+	})
+}
+
+// recordStatusEvent logs a status change for the user and everyone who
+// shares a DM or group with them
+func recordStatusEvent(q dbRunner, userID int, status string) error {
+	rows, err := q.Query(`
+		SELECT DISTINCT other.user_id
+		FROM conversation_members me
+		JOIN conversation_members other ON other.conversation_id = me.conversation_id
+		WHERE me.user_id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	userIDs := []int{userID}
+	for rows.Next() {
+		var contactID int
+		if err := rows.Scan(&contactID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, contactID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return recordSyncEvent(q, userIDs, models.Event{
+		Type:   "user.status_changed",
+		UserID: userID,
+		Data:   map[string]string{"status": status},
+	})
+}
+
+// recordReadEvents logs newly read messages for every participant of their
+// conversations, so the reader's other devices and the senders catch up
+func recordReadEvents(chatType string, messages []models.ChatMessage, readIDs map[int]bool, userID int) error {
+	byConversation := make(map[conversation][]int)
+	var order []conversation
+	for _, msg := range messages {
+		if !readIDs[msg.ID] {
+			continue
+		}
+		conv := conversation{ChatType: chatType, SenderID: msg.SenderID, ReceiverID: userID}
+		if chatType == "group" && msg.GroupID != nil {
+			conv = conversation{ChatType: chatType, GroupID: *msg.GroupID}
+		}
+		if _, ok := byConversation[conv]; !ok {
+			order = append(order, conv)
+		}
+		byConversation[conv] = append(byConversation[conv], msg.ID)
+	}
+
+	for _, conv := range order {
+		err := recordConversationEvent(database.DB, conv, models.Event{
+			Type:     "messages.read",
+			ChatType: chatType,
+			GroupID:  conv.GroupID,
+			UserID:   userID,
+			Data:     map[string][]int{"message_ids": byConversation[conv]},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
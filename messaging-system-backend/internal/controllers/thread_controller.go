@@ -0,0 +1,346 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultThreadPageSize = 20
+	maxThreadPageSize     = 100
+)
+
+// ErrInvalidThreadRoot is returned when a thread reply points at a message that cannot start a thread
+var ErrInvalidThreadRoot = errors.New("thread root not found in this group")
+
+// validateThreadRoot checks that a thread root is a top-level message of the
+// same group that has not expired
+func validateThreadRoot(rootID int, groupID int) error {
+	var exists bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM conversation_messages
+			WHERE id = $1 AND conversation_id = $2 AND thread_root_id IS NULL
+			  AND (expires_at IS NULL OR expires_at > NOW())
+		)
+	`, rootID, groupID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrInvalidThreadRoot
+	}
+	return nil
+}
+
+// recordThreadReply updates the counters on a thread root and makes the
+// replying user and the root author follow the thread
+func recordThreadReply(tx *sql.Tx, rootID int, senderID int) error {
+	var rootSenderID int
+	err := tx.QueryRow(`
+		UPDATE conversation_messages
+		SET thread_reply_count = thread_reply_count + 1,
+		    thread_last_reply_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING sender_id
+	`, rootID).Scan(&rootSenderID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO thread_follows (root_id, user_id)
+		SELECT $1, u FROM UNNEST($2::int[]) AS u
+		ON CONFLICT (root_id, user_id) DO NOTHING
+	`, rootID, pq.Array([]int64{int64(senderID), int64(rootSenderID)}))
+	return err
+}
+
+// recountThreadReplies sets the reply count and last reply time of thread
+// roots from the replies they still have
+func recountThreadReplies(tx *sql.Tx, rootIDs []int64) error {
+	if len(rootIDs) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+		UPDATE conversation_messages r
+		SET thread_reply_count = s.reply_count, thread_last_reply_at = s.last_reply_at
+		FROM (
+			SELECT root.id, COUNT(m.id) AS reply_count, MAX(m.created_at) AS last_reply_at
+			FROM UNNEST($1::int[]) AS root(id)
+			LEFT JOIN conversation_messages m ON m.thread_root_id = root.id
+			GROUP BY root.id
+		) s
+		WHERE r.id = s.id
+	`, pq.Array(rootIDs))
+	return err
+}
+
+// attachThreadSummaries loads reply counts, participants and the caller's
+// follow and unread state for every thread root in one query. Expired
+// replies the reaper has not removed yet are left out.
+func attachThreadSummaries(messages []models.ChatMessage, userID int) error {
+	var ids []int64
+	for _, msg := range messages {
+		if msg.ThreadRootID == nil {
+			ids = append(ids, int64(msg.ID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT gm.id, gm.thread_reply_count, gm.thread_last_reply_at,
+		       COALESCE((
+		           SELECT array_agg(DISTINCT r.sender_id)
+		           FROM conversation_messages r
+		           WHERE r.thread_root_id = gm.id
+		             AND (r.expires_at IS NULL OR r.expires_at > NOW())
+		       ), '{}') AS participants,
+		       tf.user_id IS NOT NULL AS following,
+		       (
+		           SELECT COUNT(*) FROM conversation_messages r
+		           WHERE r.thread_root_id = gm.id
+		             AND r.id > tf.last_read_reply_id
+		             AND r.sender_id <> $2
+		             AND (r.expires_at IS NULL OR r.expires_at > NOW())
+		       ) AS unread_count
+		FROM conversation_messages gm
+		LEFT JOIN thread_follows tf ON tf.root_id = gm.id AND tf.user_id = $2
+		WHERE gm.id = ANY($1) AND gm.thread_reply_count > 0
+	`, pq.Array(ids), userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	summaries := make(map[int]*models.ThreadSummary)
+	for rows.Next() {
+		var id int
+		var participants []int64
+		summary := &models.ThreadSummary{}
+		if err := rows.Scan(
+			&id, &summary.ReplyCount, &summary.LastReplyAt,
+			pq.Array(&participants), &summary.Following, &summary.UnreadCount,
+		); err != nil {
+			return err
+		}
+		for _, p := range participants {
+			summary.Participants = append(summary.Participants, int(p))
+		}
+		summaries[id] = summary
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if summary, ok := summaries[messages[i].ID]; ok {
+			messages[i].Thread = summary
+		}
+	}
+	return nil
+}
+
+// GetThread returns the root of a thread and a page of its replies, oldest
+// first, starting after the reply ID given as cursor. Viewing a thread marks
+// the returned replies as read for followers.
+func GetThread(rootID int, userID int, after int, limit int) (*models.ThreadView, error) {
+	if limit <= 0 {
+		limit = defaultThreadPageSize
+	}
+	if limit > maxThreadPageSize {
+		limit = maxThreadPageSize
+	}
+
+	var groupID int
+	err := database.DB.QueryRow(`
+		SELECT conversation_id FROM conversation_messages
+		WHERE id = $1 AND thread_root_id IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+	`, rootID).Scan(&groupID)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	isMember, err := isGroupMember(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotParticipant
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT gm.id, gm.conversation_id, gm.sender_id,
+		       gm.kind, gm.content, gm.created_at,
+		       su.status AS sender_status,
+		       ru.status AS receiver_status,
+		       gm.edited, gm.edited_at, gm.reply_to_id, gm.thread_root_id, gm.expires_at
+		FROM conversation_messages gm
+		JOIN users su ON su.id = gm.sender_id
+		JOIN users ru ON ru.id = $2
+		WHERE (gm.id = $1 OR (gm.thread_root_id = $1 AND gm.id > $3))
+		  AND (gm.expires_at IS NULL OR gm.expires_at > NOW())
+		ORDER BY gm.id
+		LIMIT $4
+	`, rootID, userID, after, limit+2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	view := &models.ThreadView{}
+	var replies []models.ChatMessage
+	for rows.Next() {
+		var msg models.ChatMessage
+		if err := rows.Scan(
+			&msg.ID, &msg.GroupID, &msg.SenderID,
+			&msg.Kind, &msg.Content, &msg.CreatedAt,
+			&msg.SenderStatus, &msg.ReceiverStatus,
+			&msg.Edited, &msg.EditedAt, &msg.ReplyToID, &msg.ThreadRootID, &msg.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		if msg.ID == rootID {
+			view.Root = msg
+			continue
+		}
+		replies = append(replies, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(replies) > limit {
+		replies = replies[:limit]
+		next := replies[limit-1].ID
+		view.NextCursor = &next
+	}
+
+	root := []models.ChatMessage{view.Root}
+	if err := attachThreadSummaries(root, userID); err != nil {
+		return nil, err
+	}
+	if err := attachReactions("group", root, userID); err != nil {
+		return nil, err
+	}
+	if err := attachAttachmentMetadata("group", root); err != nil {
+		return nil, err
+	}
+	if err := attachLinkPreviews("group", root); err != nil {
+		return nil, err
+	}
+	if err := attachEntities(root); err != nil {
+		return nil, err
+	}
+	if err := attachMentions(root); err != nil {
+		return nil, err
+	}
+	if err := attachForwardOrigins(root, userID); err != nil {
+		return nil, err
+	}
+	if err := markMessagesRead("group", root, userID); err != nil {
+		return nil, err
+	}
+	if err := attachPolls(root, userID); err != nil {
+		return nil, err
+	}
+	view.Root = root[0]
+
+	if err := attachReplySnippets(replies); err != nil {
+		return nil, err
+	}
+	if err := attachReactions("group", replies, userID); err != nil {
+		return nil, err
+	}
+	if err := attachAttachmentMetadata("group", replies); err != nil {
+		return nil, err
+	}
+	if err := attachLinkPreviews("group", replies); err != nil {
+		return nil, err
+	}
+	if err := attachEntities(replies); err != nil {
+		return nil, err
+	}
+	if err := attachMentions(replies); err != nil {
+		return nil, err
+	}
+	if err := attachForwardOrigins(replies, userID); err != nil {
+		return nil, err
+	}
+	if err := markMessagesRead("group", replies, userID); err != nil {
+		return nil, err
+	}
+	view.Replies = replies
+
+	if len(replies) > 0 {
+		_, err = database.DB.Exec(`
+			UPDATE thread_follows
+			SET last_read_reply_id = GREATEST(last_read_reply_id, $3)
+			WHERE root_id = $1 AND user_id = $2
+		`, rootID, userID, replies[len(replies)-1].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return view, nil
+}
+
+// FollowThread subscribes a group member to a thread
+func FollowThread(rootID int, userID int) (map[string]string, error) {
+	var groupID int
+	err := database.DB.QueryRow(`
+		SELECT conversation_id FROM conversation_messages
+		WHERE id = $1 AND thread_root_id IS NULL
+	`, rootID).Scan(&groupID)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	isMember, err := isGroupMember(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotParticipant
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO thread_follows (root_id, user_id, last_read_reply_id)
+		SELECT $1, $2, COALESCE(MAX(id), 0) FROM conversation_messages WHERE thread_root_id = $1
+		ON CONFLICT (root_id, user_id) DO NOTHING
+	`, rootID, userID)
+	if err != nil {
+		return nil, errors.New("failed to follow thread")
+	}
+
+	return map[string]string{
+		"message": "Thread followed",
+	}, nil
+}
+
+// UnfollowThread removes a user's subscription to a thread
+func UnfollowThread(rootID int, userID int) (map[string]string, error) {
+	_, err := database.DB.Exec(`
+		DELETE FROM thread_follows WHERE root_id = $1 AND user_id = $2
+	`, rootID, userID)
+	if err != nil {
+		return nil, errors.New("failed to unfollow thread")
+	}
+
+	return map[string]string{
+		"message": "Thread unfollowed",
+	}, nil
+}
@@ -0,0 +1,146 @@
+package database
+
+// legacyMessagesMigration moves a database created before conversations
+// existed onto the unified model and drops the old messages, group_messages
+// and group_members tables. It does nothing once they are gone, and the DO
+// block runs as a single statement, so a failure leaves the old tables as
+// they were.
+//
+// Groups keep their IDs as conversation IDs and group messages keep their
+// IDs. Direct messages are renumbered after the highest group message ID and
+// every table that refers to a direct message by ID is rewritten. The sync
+// log is compacted so clients refetch instead of replaying stale IDs.
+//
+// A database can come straight from a release that predates edits, replies,
+// threads and the rest, so the columns copied below are added first when
+// they are missing.
+const legacyMessagesMigration = `
+DO $$
+DECLARE
+	dm_offset INT;
+	tbl TEXT;
+BEGIN
+	IF to_regclass('public.group_messages') IS NULL THEN
+		RETURN;
+	END IF;
+
+	ALTER TABLE messages
+		ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS entities JSONB,
+		ADD COLUMN IF NOT EXISTS reply_to_id INT,
+		ADD COLUMN IF NOT EXISTS forwarded BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS forwarded_from_id INT,
+		ADD COLUMN IF NOT EXISTS edited BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS disappear_after_seconds INT,
+		ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+	ALTER TABLE group_messages
+		ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'text',
+		ADD COLUMN IF NOT EXISTS entities JSONB,
+		ADD COLUMN IF NOT EXISTS reply_to_id INT,
+		ADD COLUMN IF NOT EXISTS thread_root_id INT,
+		ADD COLUMN IF NOT EXISTS thread_reply_count INT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS thread_last_reply_at TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS forwarded BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS forwarded_from_id INT,
+		ADD COLUMN IF NOT EXISTS edited BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS disappear_after_seconds INT,
+		ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+	INSERT INTO conversations (id, type, created_at)
+	SELECT id, 'group', created_at FROM groups
+	ON CONFLICT (id) DO NOTHING;
+
+	PERFORM setval(pg_get_serial_sequence('conversations', 'id'), GREATEST((SELECT MAX(id) FROM conversations), 1));
+
+	INSERT INTO conversations (type, dm_user_low, dm_user_high, created_at)
+	SELECT 'dm', LEAST(sender_id, receiver_id), GREATEST(sender_id, receiver_id), MIN(created_at)
+	FROM messages
+	GROUP BY LEAST(sender_id, receiver_id), GREATEST(sender_id, receiver_id)
+	ON CONFLICT DO NOTHING;
+
+	INSERT INTO conversation_members (conversation_id, user_id, role)
+	SELECT group_id, user_id, CASE WHEN is_admin THEN 'admin' ELSE 'member' END
+	FROM group_members
+	ON CONFLICT DO NOTHING;
+
+	INSERT INTO conversation_members (conversation_id, user_id, joined_at)
+	SELECT id, dm_user_low, created_at FROM conversations WHERE type = 'dm'
+	UNION
+	SELECT id, dm_user_high, created_at FROM conversations WHERE type = 'dm'
+	ON CONFLICT DO NOTHING;
+
+	INSERT INTO conversation_messages (
+		id, conversation_id, sender_id, kind, content, entities, reply_to_id,
+		thread_root_id, thread_reply_count, thread_last_reply_at, forwarded, forwarded_from_id,
+		edited, edited_at, disappear_after_seconds, expires_at, created_at, updated_at
+	)
+	SELECT
+		id, group_id, sender_id, kind, content, entities, reply_to_id,
+		thread_root_id, thread_reply_count, thread_last_reply_at, forwarded, forwarded_from_id,
+		edited, edited_at, disappear_after_seconds, expires_at, created_at, updated_at
+	FROM group_messages;
+
+	SELECT COALESCE(MAX(id), 0) INTO dm_offset FROM group_messages;
+
+	CREATE TEMP TABLE legacy_dm_ids ON COMMIT DROP AS
+	SELECT id AS old_id, dm_offset + ROW_NUMBER() OVER (ORDER BY id) AS new_id
+	FROM messages;
+
+	-- Replies to direct messages that were already deleted lose their target
+	INSERT INTO conversation_messages (
+		id, conversation_id, sender_id, content, entities, reply_to_id,
+		forwarded, forwarded_from_id, edited, edited_at,
+		disappear_after_seconds, expires_at, created_at, updated_at
+	)
+	SELECT
+		d.new_id, c.id, m.sender_id, m.content, m.entities, r.new_id,
+		m.forwarded, m.forwarded_from_id, m.edited, m.edited_at,
+		m.disappear_after_seconds, m.expires_at, m.created_at, m.updated_at
+	FROM messages m
+	JOIN legacy_dm_ids d ON d.old_id = m.id
+	JOIN conversations c ON c.type = 'dm'
+		AND c.dm_user_low = LEAST(m.sender_id, m.receiver_id)
+		AND c.dm_user_high = GREATEST(m.sender_id, m.receiver_id)
+	LEFT JOIN legacy_dm_ids r ON r.old_id = m.reply_to_id;
+
+	PERFORM setval(pg_get_serial_sequence('conversation_messages', 'id'), GREATEST((SELECT MAX(id) FROM conversation_messages), 1));
+
+	-- New IDs are written negated first so they never collide with an old ID
+	-- that is still waiting to be rewritten
+	FOREACH tbl IN ARRAY ARRAY[
+		'message_reactions', 'attachments', 'message_pins', 'saved_messages',
+		'message_receipts', 'message_revisions', 'link_previews', 'idempotency_keys'
+	] LOOP
+		EXECUTE format('DELETE FROM %I t WHERE t.message_type = ''dm''
+			AND NOT EXISTS (SELECT 1 FROM legacy_dm_ids d WHERE d.old_id = t.message_id)', tbl);
+		EXECUTE format('UPDATE %I t SET message_id = -d.new_id FROM legacy_dm_ids d
+			WHERE t.message_type = ''dm'' AND t.message_id = d.old_id', tbl);
+		EXECUTE format('UPDATE %I SET message_id = -message_id WHERE message_type = ''dm'' AND message_id < 0', tbl);
+	END LOOP;
+
+	UPDATE scheduled_messages s SET sent_message_id = d.new_id
+	FROM legacy_dm_ids d
+	WHERE s.chat_type = 'dm' AND s.sent_message_id = d.old_id;
+
+	DELETE FROM user_events;
+	UPDATE user_sync_state SET compacted_seq = last_seq;
+
+	DROP TABLE messages, group_messages, group_members CASCADE;
+
+	ALTER TABLE thread_follows DROP CONSTRAINT IF EXISTS thread_follows_root_id_fkey,
+		ADD CONSTRAINT thread_follows_root_id_fkey FOREIGN KEY (root_id)
+		REFERENCES conversation_messages(id) ON DELETE CASCADE;
+	ALTER TABLE message_mentions DROP CONSTRAINT IF EXISTS message_mentions_group_message_id_fkey,
+		ADD CONSTRAINT message_mentions_group_message_id_fkey FOREIGN KEY (group_message_id)
+		REFERENCES conversation_messages(id) ON DELETE CASCADE;
+	ALTER TABLE mention_notifications DROP CONSTRAINT IF EXISTS mention_notifications_group_message_id_fkey,
+		ADD CONSTRAINT mention_notifications_group_message_id_fkey FOREIGN KEY (group_message_id)
+		REFERENCES conversation_messages(id) ON DELETE CASCADE;
+	ALTER TABLE polls DROP CONSTRAINT IF EXISTS polls_message_id_fkey,
+		ADD CONSTRAINT polls_message_id_fkey FOREIGN KEY (message_id)
+		REFERENCES conversation_messages(id) ON DELETE CASCADE;
+END $$;
+`
@@ -0,0 +1,165 @@
+package database_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"messaging-system-backend/internal/database"
+
+	_ "github.com/lib/pq"
+)
+
+// baselineSchema is the schema of the first release, before conversations,
+// edits, replies and the other message columns existed
+const baselineSchema = `
+	CREATE TABLE users (
+		id SERIAL PRIMARY KEY,
+		username VARCHAR(100) UNIQUE NOT NULL,
+		password TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE messages (
+		id SERIAL PRIMARY KEY,
+		sender_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		receiver_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		content TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE groups (
+		id SERIAL PRIMARY KEY,
+		name TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE group_members (
+		id SERIAL PRIMARY KEY,
+		group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		is_admin BOOLEAN DEFAULT FALSE,
+		UNIQUE(group_id, user_id)
+	);
+
+	CREATE TABLE group_messages (
+		id SERIAL PRIMARY KEY,
+		group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+		sender_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		content TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+	ALTER TABLE group_messages ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT DEFAULT 'Available' CHECK (char_length(status) <= 1000);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+	INSERT INTO users (username, password) VALUES ('alice', 'x'), ('bob', 'x'), ('carol', 'x');
+	INSERT INTO messages (sender_id, receiver_id, content) VALUES (1, 2, 'hi bob'), (2, 1, 'hi alice');
+	INSERT INTO groups (name) VALUES ('team');
+	INSERT INTO group_members (group_id, user_id, is_admin) VALUES (1, 1, TRUE), (1, 3, FALSE);
+	INSERT INTO group_messages (group_id, sender_id, content) VALUES (1, 1, 'welcome'), (1, 3, 'thanks');
+`
+
+// TestEnsureTablesMigratesBaselineSchema runs the schema and the legacy
+// migration against a database on the first release's schema. It needs
+// TEST_DATABASE_URL to point at a disposable database, whose public schema
+// is dropped.
+func TestEnsureTablesMigratesBaselineSchema(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public;`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	database.DB = db
+	if err := database.EnsureTables(); err != nil {
+		t.Fatalf("EnsureTables on the baseline schema: %v", err)
+	}
+
+	var legacy sql.NullString
+	if err := db.QueryRow(`SELECT to_regclass('public.group_messages')::text`).Scan(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Valid {
+		t.Error("group_messages was not dropped")
+	}
+
+	var groups, dms int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE type = 'group'), COUNT(*) FILTER (WHERE type = 'dm') FROM conversations
+	`).Scan(&groups, &dms); err != nil {
+		t.Fatal(err)
+	}
+	if groups != 1 || dms != 1 {
+		t.Errorf("got %d group and %d DM conversations, want 1 and 1", groups, dms)
+	}
+
+	rows, err := db.Query(`
+		SELECT m.id, c.type, m.content FROM conversation_messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		ORDER BY m.id
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	type migrated struct {
+		id       int
+		chatType string
+		content  string
+	}
+	var got []migrated
+	for rows.Next() {
+		var m migrated
+		if err := rows.Scan(&m.id, &m.chatType, &m.content); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []migrated{
+		{1, "group", "welcome"},
+		{2, "group", "thanks"},
+		{3, "dm", "hi bob"},
+		{4, "dm", "hi alice"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	var role string
+	if err := db.QueryRow(`
+		SELECT role FROM conversation_members WHERE conversation_id = 1 AND user_id = 1
+	`).Scan(&role); err != nil {
+		t.Fatal(err)
+	}
+	if role != "admin" {
+		t.Errorf("group admin migrated as %q", role)
+	}
+
+	if err := database.EnsureTables(); err != nil {
+		t.Fatalf("EnsureTables after the migration: %v", err)
+	}
+}
@@ -14,51 +14,403 @@ func EnsureTables() error {
 
 	CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 
-	CREATE TABLE IF NOT EXISTS messages (
+	CREATE TABLE IF NOT EXISTS groups (
+		id SERIAL PRIMARY KEY,
+		name TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_groups_name ON groups(name);
+
+	-- A conversation is either a group (sharing the group's ID) or a direct
+	-- chat between two users. Both kinds list their participants in
+	-- conversation_members and store messages in conversation_messages.
+	CREATE TABLE IF NOT EXISTS conversations (
 		id SERIAL PRIMARY KEY,
+		type TEXT NOT NULL CHECK (type IN ('dm', 'group')),
+		dm_user_low INT REFERENCES users(id) ON DELETE CASCADE,
+		dm_user_high INT REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		CHECK ((type = 'dm') = (dm_user_low IS NOT NULL AND dm_user_high IS NOT NULL))
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_conversations_dm_pair ON conversations(dm_user_low, dm_user_high) WHERE type = 'dm';
+
+	CREATE TABLE IF NOT EXISTS conversation_members (
+		conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('member', 'admin')),
+		joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (conversation_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_conversation_members_user_id ON conversation_members(user_id);
+
+	CREATE TABLE IF NOT EXISTS conversation_messages (
+		id SERIAL PRIMARY KEY,
+		conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
 		sender_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		receiver_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL DEFAULT 'text' CHECK (kind IN ('text', 'poll', 'encrypted')),
+		content TEXT NOT NULL,
+		entities JSONB,
+		reply_to_id INT,
+		thread_root_id INT REFERENCES conversation_messages(id) ON DELETE CASCADE,
+		thread_reply_count INT NOT NULL DEFAULT 0,
+		thread_last_reply_at TIMESTAMP,
+		forwarded BOOLEAN NOT NULL DEFAULT FALSE,
+		forwarded_from_id INT REFERENCES users(id) ON DELETE SET NULL,
+		edited BOOLEAN NOT NULL DEFAULT FALSE,
+		edited_at TIMESTAMP,
+		disappear_after_seconds INT,
+		expires_at TIMESTAMP,
+		search_vector tsvector GENERATED ALWAYS AS (to_tsvector('english', content)) STORED,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_conversation_messages_conversation ON conversation_messages(conversation_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_conversation_messages_sender_id ON conversation_messages(sender_id);
+	CREATE INDEX IF NOT EXISTS idx_conversation_messages_thread_root_id ON conversation_messages(thread_root_id);
+	CREATE INDEX IF NOT EXISTS idx_conversation_messages_expires_at ON conversation_messages(expires_at) WHERE expires_at IS NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_conversation_messages_search ON conversation_messages USING GIN (search_vector);
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT DEFAULT 'Available' CHECK (char_length(status) <= 1000);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+	CREATE TABLE IF NOT EXISTS message_revisions (
+		id SERIAL PRIMARY KEY,
+		message_type TEXT NOT NULL CHECK (message_type IN ('dm', 'group')),
+		message_id INT NOT NULL,
 		content TEXT NOT NULL,
+		edited_by INT REFERENCES users(id) ON DELETE SET NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
 
-	CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages(sender_id);
-	CREATE INDEX IF NOT EXISTS idx_messages_receiver_id ON messages(receiver_id);
+	CREATE INDEX IF NOT EXISTS idx_message_revisions_message ON message_revisions(message_type, message_id);
 
-	CREATE TABLE IF NOT EXISTS groups (
+	CREATE TABLE IF NOT EXISTS thread_follows (
+		root_id INT NOT NULL REFERENCES conversation_messages(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		last_read_reply_id INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (root_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_thread_follows_user_id ON thread_follows(user_id);
+
+	CREATE TABLE IF NOT EXISTS message_reactions (
 		id SERIAL PRIMARY KEY,
-		name TEXT UNIQUE NOT NULL,
-		created_at TIMESTAMP DEFAULT NOW()
+		message_type TEXT NOT NULL CHECK (message_type IN ('dm', 'group')),
+		message_id INT NOT NULL,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		emoji TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(message_type, message_id, user_id, emoji)
 	);
 
-	CREATE INDEX IF NOT EXISTS idx_groups_name ON groups(name);
+	CREATE INDEX IF NOT EXISTS idx_message_reactions_message ON message_reactions(message_type, message_id);
+
+	CREATE TABLE IF NOT EXISTS attachments (
+		id SERIAL PRIMARY KEY,
+		message_type TEXT NOT NULL CHECK (message_type IN ('dm', 'group')),
+		message_id INT NOT NULL,
+		uploader_id INT REFERENCES users(id) ON DELETE SET NULL,
+		storage_key TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments(message_type, message_id);
+	CREATE INDEX IF NOT EXISTS idx_attachments_storage_key ON attachments(storage_key);
+
+	ALTER TABLE attachments ADD COLUMN IF NOT EXISTS width INT;
+	ALTER TABLE attachments ADD COLUMN IF NOT EXISTS height INT;
+	ALTER TABLE attachments ADD COLUMN IF NOT EXISTS blurhash TEXT;
+	ALTER TABLE attachments ADD COLUMN IF NOT EXISTS processing_status TEXT NOT NULL DEFAULT 'none';
+	ALTER TABLE attachments ADD COLUMN IF NOT EXISTS processing_started_at TIMESTAMP;
+
+	CREATE INDEX IF NOT EXISTS idx_attachments_processing ON attachments(id) WHERE processing_status IN ('pending', 'processing');
+
+	CREATE TABLE IF NOT EXISTS attachment_thumbnails (
+		attachment_id INT NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+		size INT NOT NULL,
+		storage_key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		width INT NOT NULL,
+		height INT NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		PRIMARY KEY (attachment_id, size)
+	);
+
+	CREATE TABLE IF NOT EXISTS message_mentions (
+		id SERIAL PRIMARY KEY,
+		group_message_id INT NOT NULL REFERENCES conversation_messages(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		mentioned_user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		start_offset INT NOT NULL,
+		length INT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_message_mentions_message ON message_mentions(group_message_id);
+
+	CREATE TABLE IF NOT EXISTS mention_notifications (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		group_message_id INT NOT NULL REFERENCES conversation_messages(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		read_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user_id, group_message_id)
+	);
 
-	CREATE TABLE IF NOT EXISTS group_members (
+	CREATE INDEX IF NOT EXISTS idx_mention_notifications_user ON mention_notifications(user_id, id DESC);
+
+	CREATE INDEX IF NOT EXISTS idx_attachment_thumbnails_storage_key ON attachment_thumbnails(storage_key);
+
+	CREATE TABLE IF NOT EXISTS message_pins (
+		id SERIAL PRIMARY KEY,
+		message_type TEXT NOT NULL CHECK (message_type IN ('dm', 'group')),
+		message_id INT NOT NULL,
+		group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+		dm_user_low INT REFERENCES users(id) ON DELETE CASCADE,
+		dm_user_high INT REFERENCES users(id) ON DELETE CASCADE,
+		pinned_by INT REFERENCES users(id) ON DELETE SET NULL,
+		pinned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (message_type, message_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_message_pins_group ON message_pins(group_id, pinned_at DESC) WHERE group_id IS NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_message_pins_dm ON message_pins(dm_user_low, dm_user_high, pinned_at DESC) WHERE group_id IS NULL;
+
+	CREATE TABLE IF NOT EXISTS saved_messages (
 		id SERIAL PRIMARY KEY,
-		group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
 		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		is_admin BOOLEAN DEFAULT FALSE,
-		UNIQUE(group_id, user_id)
+		message_type TEXT NOT NULL CHECK (message_type IN ('dm', 'group')),
+		message_id INT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user_id, message_type, message_id)
 	);
 
-	CREATE INDEX IF NOT EXISTS idx_group_members_group_id ON group_members(group_id);
-	CREATE INDEX IF NOT EXISTS idx_group_members_user_id ON group_members(user_id);
+	CREATE INDEX IF NOT EXISTS idx_saved_messages_user ON saved_messages(user_id, id DESC);
+	CREATE INDEX IF NOT EXISTS idx_saved_messages_message ON saved_messages(message_type, message_id);
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS forward_attribution TEXT NOT NULL DEFAULT 'everyone' CHECK (forward_attribution IN ('everyone', 'nobody'));
 
-	CREATE TABLE IF NOT EXISTS group_messages (
+	CREATE TABLE IF NOT EXISTS scheduled_messages (
 		id SERIAL PRIMARY KEY,
-		group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
 		sender_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		chat_type TEXT NOT NULL CHECK (chat_type IN ('dm', 'group')),
+		chat_id INT NOT NULL,
 		content TEXT NOT NULL,
-		created_at TIMESTAMP DEFAULT NOW()
+		send_at TIMESTAMPTZ NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'cancelled', 'failed')),
+		failure_reason TEXT,
+		sent_message_id INT,
+		sent_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 	);
 
-	CREATE INDEX IF NOT EXISTS idx_group_messages_group_id ON group_messages(group_id);
-	CREATE INDEX IF NOT EXISTS idx_group_messages_sender_id ON group_messages(sender_id);
+	CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(send_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS idx_scheduled_messages_sender ON scheduled_messages(sender_id, send_at);
 
-	ALTER TABLE messages ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
-	ALTER TABLE group_messages ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
-	ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT DEFAULT 'Available' CHECK (char_length(status) <= 1000);
-	ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+	ALTER TABLE groups ADD COLUMN IF NOT EXISTS disappear_after_seconds INT;
+	ALTER TABLE groups ADD COLUMN IF NOT EXISTS disappear_from TEXT NOT NULL DEFAULT 'send' CHECK (disappear_from IN ('send', 'read'));
+	ALTER TABLE groups ADD COLUMN IF NOT EXISTS slow_mode_seconds INT NOT NULL DEFAULT 0;
+
+	CREATE TABLE IF NOT EXISTS direct_chat_settings (
+		user_low INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		user_high INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		disappear_after_seconds INT,
+		disappear_from TEXT NOT NULL DEFAULT 'send' CHECK (disappear_from IN ('send', 'read')),
+		updated_by INT REFERENCES users(id) ON DELETE SET NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_low, user_high)
+	);
+
+	CREATE TABLE IF NOT EXISTS message_receipts (
+		message_type TEXT NOT NULL CHECK (message_type IN ('dm', 'group')),
+		message_id INT NOT NULL,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		read_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (message_type, message_id, user_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		sender_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		message_type TEXT NOT NULL CHECK (message_type IN ('dm', 'group')),
+		chat_id INT NOT NULL,
+		message_id INT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (sender_id, key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+	CREATE TABLE IF NOT EXISTS user_sync_state (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		last_seq BIGINT NOT NULL DEFAULT 0,
+		compacted_seq BIGINT NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS user_events (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		seq BIGINT NOT NULL,
+		type TEXT NOT NULL,
+		chat_type TEXT NOT NULL DEFAULT '',
+		group_id INT,
+		message_id INT,
+		actor_id INT,
+		data JSONB,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, seq)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_events_created_at ON user_events(created_at);
+
+	CREATE TABLE IF NOT EXISTS link_previews (
+		id SERIAL PRIMARY KEY,
+		message_type TEXT NOT NULL CHECK (message_type IN ('dm', 'group')),
+		message_id INT NOT NULL,
+		position INT NOT NULL,
+		url TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'ready', 'failed')),
+		title TEXT,
+		description TEXT,
+		image_url TEXT,
+		site_name TEXT,
+		processing_started_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (message_type, message_id, url)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_link_previews_pending ON link_previews(id) WHERE status IN ('pending', 'processing');
+
+	CREATE TABLE IF NOT EXISTS polls (
+		message_id INT PRIMARY KEY REFERENCES conversation_messages(id) ON DELETE CASCADE,
+		question TEXT NOT NULL,
+		multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+		anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+		closes_at TIMESTAMP,
+		closed_at TIMESTAMP,
+		closed_by INT REFERENCES users(id) ON DELETE SET NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS poll_options (
+		id SERIAL PRIMARY KEY,
+		poll_id INT NOT NULL REFERENCES polls(message_id) ON DELETE CASCADE,
+		position INT NOT NULL,
+		text TEXT NOT NULL,
+		UNIQUE (poll_id, position)
+	);
+
+	CREATE TABLE IF NOT EXISTS poll_votes (
+		poll_id INT NOT NULL REFERENCES polls(message_id) ON DELETE CASCADE,
+		option_id INT NOT NULL REFERENCES poll_options(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (poll_id, user_id, option_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_poll_votes_option_id ON poll_votes(option_id);
+
+	ALTER TABLE conversation_members ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+	ALTER TABLE conversation_members ADD COLUMN IF NOT EXISTS muted BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE conversation_members ADD COLUMN IF NOT EXISTS muted_until TIMESTAMP;
+	ALTER TABLE conversation_members ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP;
+
+	CREATE INDEX IF NOT EXISTS idx_conversation_members_pinned ON conversation_members(user_id) WHERE pinned_at IS NOT NULL;
+
+	CREATE TABLE IF NOT EXISTS chat_folders (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(32) NOT NULL,
+		position INT NOT NULL DEFAULT 0,
+		types TEXT[] NOT NULL DEFAULT '{}',
+		unread BOOLEAN,
+		muted BOOLEAN,
+		admin_only BOOLEAN NOT NULL DEFAULT FALSE,
+		include_ids INT[] NOT NULL DEFAULT '{}',
+		exclude_ids INT[] NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_chat_folders_user_id ON chat_folders(user_id, position);
+
+	CREATE TABLE IF NOT EXISTS message_drafts (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+		content TEXT NOT NULL DEFAULT '',
+		reply_to_id INT REFERENCES conversation_messages(id) ON DELETE SET NULL,
+		client_updated_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, conversation_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS moderation_queue (
+		id SERIAL PRIMARY KEY,
+		message_id INT NOT NULL REFERENCES conversation_messages(id) ON DELETE CASCADE,
+		sender_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		check_name TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'removed')),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_moderation_queue_pending ON moderation_queue(created_at) WHERE status = 'pending';
+
+	-- End-to-end encryption. The server is a key directory for each user's
+	-- devices and stores encrypted messages as ciphertext per recipient
+	-- device; it never sees their plaintext. A DM is marked encrypted once
+	-- an encrypted message is sent in it.
+	ALTER TABLE conversations ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM pg_constraint
+			WHERE conname = 'conversation_messages_kind_check'
+			  AND pg_get_constraintdef(oid) LIKE '%encrypted%'
+		) THEN
+			ALTER TABLE conversation_messages DROP CONSTRAINT IF EXISTS conversation_messages_kind_check;
+			ALTER TABLE conversation_messages ADD CONSTRAINT conversation_messages_kind_check
+				CHECK (kind IN ('text', 'poll', 'encrypted'));
+		END IF;
+	END $$;
+
+	CREATE TABLE IF NOT EXISTS user_devices (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		device_id INT NOT NULL,
+		identity_key TEXT NOT NULL,
+		signed_prekey_id INT NOT NULL,
+		signed_prekey TEXT NOT NULL,
+		signed_prekey_signature TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, device_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS one_time_prekeys (
+		user_id INT NOT NULL,
+		device_id INT NOT NULL,
+		key_id INT NOT NULL,
+		public_key TEXT NOT NULL,
+		PRIMARY KEY (user_id, device_id, key_id),
+		FOREIGN KEY (user_id, device_id) REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS message_ciphertexts (
+		message_id INT NOT NULL REFERENCES conversation_messages(id) ON DELETE CASCADE,
+		recipient_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		device_id INT NOT NULL,
+		ciphertext TEXT NOT NULL,
+		PRIMARY KEY (message_id, recipient_id, device_id)
+	);
 
 
 
@@ -68,6 +420,9 @@ func EnsureTables() error {
 	if _, err := DB.Exec(schema); err != nil {
 		return fmt.Errorf("❌ Failed to run schema migrations: %w", err)
 	}
+	if _, err := DB.Exec(legacyMessagesMigration); err != nil {
+		return fmt.Errorf("❌ Failed to migrate messages to conversations: %w", err)
+	}
 	fmt.Println("📦 DB schema ensured.")
 	return nil
 }
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/models"
+)
+
+// DMChannel returns the Redis channel used for events in a direct conversation
+func DMChannel(userA int, userB int) string {
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	return fmt.Sprintf("events:dm:%d:%d", userA, userB)
+}
+
+// GroupChannel returns the Redis channel used for events in a group
+func GroupChannel(groupID int) string {
+	return fmt.Sprintf("events:group:%d", groupID)
+}
+
+// UserChannel returns the Redis channel used for events addressed to a single user
+func UserChannel(userID int) string {
+	return fmt.Sprintf("events:user:%d", userID)
+}
+
+// Publish sends an event to live clients subscribed to the channel.
+// Delivery is best effort: failures are logged and never block the caller.
+func Publish(channel string, event models.Event) {
+	if database.RedisClient == nil {
+		return
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := database.RedisClient.Publish(ctx, channel, payload).Err(); err != nil {
+		log.Printf("Failed to publish %s event: %v", event.Type, err)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/pkg/utils"
+)
+
+// AttachmentURLHandler handles GET /attachments/{id}/url?size=<thumbnail size>
+func AttachmentURLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	attachmentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	size, err := queryInt(r, "size", 0)
+	if err != nil {
+		http.Error(w, "Invalid thumbnail size", http.StatusBadRequest)
+		return
+	}
+
+	link, err := controllers.GetAttachmentURL(attachmentID, userID, size)
+	if err != nil {
+		writeControllerError(w, err, "Could not create download link")
+		return
+	}
+
+	json.NewEncoder(w).Encode(link)
+}
+
+// DownloadAttachmentHandler handles GET /attachments/{id}/download?uid=&exp=&sig=[&size=]
+// The signed query string takes the place of the Authorization header.
+func DownloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	attachmentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	userID, err := strconv.Atoi(query.Get("uid"))
+	if err != nil {
+		http.Error(w, "Invalid download link", http.StatusBadRequest)
+		return
+	}
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid download link", http.StatusBadRequest)
+		return
+	}
+
+	size, err := queryInt(r, "size", 0)
+	if err != nil {
+		http.Error(w, "Invalid download link", http.StatusBadRequest)
+		return
+	}
+
+	body, att, err := controllers.OpenSignedAttachment(attachmentID, userID, size, expires, query.Get("sig"))
+	if err != nil {
+		if errors.Is(err, controllers.ErrInvalidAttachmentURL) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		writeControllerError(w, err, "Could not download attachment")
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", att.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(att.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Attachment download interrupted: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// ConversationsHandler handles GET /conversations?include_archived=true
+func ConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+
+	conversations, err := controllers.GetConversations(userID, includeArchived)
+	if err != nil {
+		writeControllerError(w, err, "Could not fetch conversations")
+		return
+	}
+
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// ConversationMessagesHandler handles GET /conversations/{id}/messages
+func ConversationMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	msgs, err := controllers.GetConversationMessages(conversationID, userID)
+	if err != nil {
+		writeControllerError(w, err, "Could not fetch messages")
+		return
+	}
+
+	json.NewEncoder(w).Encode(msgs)
+}
+
+// ConversationStateHandler handles GET and PUT /conversations/{id}/state
+func ConversationStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		state, err := controllers.GetConversationState(conversationID, userID)
+		if err != nil {
+			writeControllerError(w, err, "Could not fetch conversation state")
+			return
+		}
+		json.NewEncoder(w).Encode(state)
+		return
+	}
+
+	var input models.ConversationStateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	state, err := controllers.UpdateConversationState(conversationID, input, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrInvalidMuteUntil):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, controllers.ErrTooManyPinnedConversations):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			writeControllerError(w, err, "Could not update conversation state")
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(state)
+}
+
+// ConversationDraftHandler handles GET and PUT /conversations/{id}/draft
+func ConversationDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		draft, err := controllers.GetDraft(conversationID, userID)
+		if err != nil {
+			writeControllerError(w, err, "Could not fetch draft")
+			return
+		}
+		json.NewEncoder(w).Encode(draft)
+		return
+	}
+
+	var input models.DraftInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	draft, err := controllers.SaveDraft(conversationID, input, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrInvalidDraft), errors.Is(err, controllers.ErrInvalidReplyTarget):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeControllerError(w, err, "Could not save draft")
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(draft)
+}
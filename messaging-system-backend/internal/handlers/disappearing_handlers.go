@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// DisappearingSettingsHandler handles GET /chats/disappearing?type=<dm|group>&id=<chat_id>
+// and PUT /chats/disappearing
+func DisappearingSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		chatID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+			return
+		}
+		settings, err := controllers.GetDisappearingSettings(r.URL.Query().Get("type"), chatID, userID)
+		if err != nil {
+			writeControllerError(w, err, "Could not fetch disappearing messages settings")
+			return
+		}
+		json.NewEncoder(w).Encode(settings)
+		return
+	}
+
+	var input models.DisappearingSettings
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := controllers.UpdateDisappearingSettings(input, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrInvalidDisappearTimer):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, controllers.ErrDisappearNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			writeControllerError(w, err, "Could not update disappearing messages settings")
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"messaging-system-backend/internal/controllers"
 	"messaging-system-backend/internal/models"
@@ -24,7 +26,10 @@ func EditDirectMessageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = controllers.EditDirectMessage(input, userID)
-	if err != nil {
+	if errors.Is(err, controllers.ErrUnsafeLink) || errors.Is(err, controllers.ErrTooMuchFormatting) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
@@ -47,10 +52,50 @@ func EditGroupMessageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = controllers.EditGroupMessage(input, userID)
-	if err != nil {
+	if errors.Is(err, controllers.ErrUnsafeLink) || errors.Is(err, controllers.ErrTooMuchFormatting) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if errors.Is(err, controllers.ErrAllMentionRestricted) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]string{"message": "Group message edited successfully"})
 }
+
+// MessageHistoryHandler handles GET /messages/{id}/history?type=dm|group
+func MessageHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatType := r.URL.Query().Get("type") // "dm" or "group"
+	if chatType != "dm" && chatType != "group" {
+		http.Error(w, "Invalid chat type", http.StatusBadRequest)
+		return
+	}
+
+	history, err := controllers.GetMessageHistory(chatType, messageID, userID)
+	if err != nil {
+		writeControllerError(w, err, "Could not fetch message history")
+		return
+	}
+
+	json.NewEncoder(w).Encode(history)
+}
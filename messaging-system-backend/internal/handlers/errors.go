@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/ratelimit"
+)
+
+// writeControllerError maps the shared controller errors to HTTP status codes.
+// Unexpected errors are logged and reported with the fallback message.
+func writeControllerError(w http.ResponseWriter, err error, fallback string) {
+	var rateErr *controllers.RateLimitError
+	var spamErr *controllers.SpamRejectedError
+	switch {
+	case errors.As(err, &rateErr):
+		w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfterSeconds(rateErr.Wait)))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.As(err, &spamErr):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, controllers.ErrMessageNotFound), errors.Is(err, controllers.ErrConversationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, controllers.ErrNotParticipant):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, controllers.ErrInvalidChatType):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: %v", fallback, err)
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// queryInt reads an optional integer query parameter, returning def when it is absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// ChatFoldersHandler handles GET (list) and POST (create) /folders
+func ChatFoldersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		folders, err := controllers.GetFolders(userID)
+		if err != nil {
+			writeControllerError(w, err, "Could not fetch folders")
+			return
+		}
+		json.NewEncoder(w).Encode(folders)
+		return
+	}
+
+	var input models.ChatFolderInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	folder, err := controllers.CreateFolder(input, userID)
+	if err != nil {
+		writeFolderError(w, err, "Could not create folder")
+		return
+	}
+
+	json.NewEncoder(w).Encode(folder)
+}
+
+// ChatFolderHandler handles GET, PUT (replace) and DELETE /folders/{id}
+func ChatFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var resp interface{}
+	switch r.Method {
+	case http.MethodGet:
+		resp, err = controllers.GetFolder(folderID, userID)
+	case http.MethodPut:
+		var input models.ChatFolderInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		resp, err = controllers.UpdateFolder(folderID, input, userID)
+	default:
+		resp, err = controllers.DeleteFolder(folderID, userID)
+	}
+	if err != nil {
+		writeFolderError(w, err, "Could not update folder")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// FolderOrderHandler handles PUT /folders/order
+func FolderOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input models.FolderOrderInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	folders, err := controllers.ReorderFolders(input, userID)
+	if err != nil {
+		writeFolderError(w, err, "Could not reorder folders")
+		return
+	}
+
+	json.NewEncoder(w).Encode(folders)
+}
+
+// FolderConversationsHandler handles GET /folders/{id}/conversations?include_archived=true
+func FolderConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+
+	conversations, err := controllers.GetFolderConversations(folderID, userID, includeArchived)
+	if err != nil {
+		writeFolderError(w, err, "Could not fetch folder conversations")
+		return
+	}
+
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// writeFolderError maps folder errors to HTTP status codes
+func writeFolderError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, controllers.ErrInvalidFolder), errors.Is(err, controllers.ErrInvalidFolderOrder):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, controllers.ErrFolderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, controllers.ErrTooManyFolders):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeControllerError(w, err, fallback)
+	}
+}
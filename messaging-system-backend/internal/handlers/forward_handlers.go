@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// ForwardMessagesHandler handles POST /messages/forward
+func ForwardMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input models.ForwardInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := controllers.ForwardMessages(input, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrInvalidForward),
+			errors.Is(err, controllers.ErrTooManyForwardDestinations),
+			errors.Is(err, controllers.ErrTooManyForwardMessages),
+			errors.Is(err, controllers.ErrEncryptedMessage),
+			errors.Is(err, controllers.ErrPollNotForwardable):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, controllers.ErrInvalidForwardDestination):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			writeControllerError(w, err, "Could not forward messages")
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// PrivacySettingsHandler handles GET and PUT /user/privacy
+func PrivacySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		settings, err := controllers.GetPrivacySettings(userID)
+		if err != nil {
+			writeControllerError(w, err, "Could not fetch privacy settings")
+			return
+		}
+		json.NewEncoder(w).Encode(settings)
+		return
+	}
+
+	var input models.PrivacySettings
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := controllers.UpdatePrivacySettings(userID, input)
+	if err != nil {
+		if errors.Is(err, controllers.ErrInvalidPrivacySetting) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeControllerError(w, err, "Could not update privacy settings")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
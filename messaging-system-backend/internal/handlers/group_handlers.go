@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"messaging-system-backend/internal/controllers"
 	"messaging-system-backend/internal/models"
@@ -123,3 +125,54 @@ func RemoveMemberFromGroup(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(resp)
 }
+
+// SlowModeHandler handles GET and PUT /groups/{id}/slow-mode
+func SlowModeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		settings, err := controllers.GetSlowMode(groupID, userID)
+		if err != nil {
+			writeControllerError(w, err, "Could not fetch slow mode")
+			return
+		}
+		json.NewEncoder(w).Encode(settings)
+		return
+	}
+
+	var input models.SlowModeSettings
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := controllers.UpdateSlowMode(groupID, input, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrInvalidSlowMode):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, controllers.ErrSlowModeNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			writeControllerError(w, err, "Could not update slow mode")
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(settings)
+}
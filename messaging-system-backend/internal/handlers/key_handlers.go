@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// DevicesHandler handles GET /keys/devices
+func DevicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	devices, err := controllers.GetDevices(userID)
+	if err != nil {
+		writeControllerError(w, err, "Could not fetch devices")
+		return
+	}
+
+	json.NewEncoder(w).Encode(devices)
+}
+
+// DeviceHandler handles PUT (register or rotate keys) and DELETE /keys/devices/{id}
+func DeviceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var resp interface{}
+	if r.Method == http.MethodPut {
+		var input models.DeviceKeysInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		resp, err = controllers.RegisterDevice(deviceID, input, userID)
+	} else {
+		resp, err = controllers.RemoveDevice(deviceID, userID)
+	}
+	if err != nil {
+		writeKeyError(w, err, "Could not update device")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DevicePrekeysHandler handles POST /keys/devices/{id}/prekeys
+func DevicePrekeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input models.PrekeyUploadInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	count, err := controllers.UploadPrekeys(deviceID, input, userID)
+	if err != nil {
+		writeKeyError(w, err, "Could not upload prekeys")
+		return
+	}
+
+	json.NewEncoder(w).Encode(count)
+}
+
+// PrekeyBundleHandler handles GET /keys/users/{id}/bundle
+func PrekeyBundleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	bundles, err := controllers.GetPrekeyBundles(targetID, userID)
+	if err != nil {
+		writeKeyError(w, err, "Could not fetch prekey bundle")
+		return
+	}
+
+	json.NewEncoder(w).Encode(bundles)
+}
+
+// writeKeyError maps key directory errors to status codes
+func writeKeyError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, controllers.ErrInvalidDeviceKeys),
+		errors.Is(err, controllers.ErrInvalidDeviceID),
+		errors.Is(err, controllers.ErrTooManyPrekeys):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, controllers.ErrDeviceNotFound),
+		errors.Is(err, controllers.ErrNoDevices):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, controllers.ErrTooManyDevices):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeControllerError(w, err, fallback)
+	}
+}
@@ -43,8 +43,9 @@ func GetGroupSummary(w http.ResponseWriter, r *http.Request) {
 	var exists bool
 	err = database.DB.QueryRowContext(ctx, `
         SELECT EXISTS (
-            SELECT 1 FROM group_members 
-            WHERE group_id = $1 AND user_id = $2
+            SELECT 1 FROM conversation_members cm
+            JOIN conversations c ON c.id = cm.conversation_id
+            WHERE cm.conversation_id = $1 AND cm.user_id = $2 AND c.type = 'group'
         )
     `, groupID, userID).Scan(&exists)
 
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// MentionsFeedHandler handles GET /me/mentions?before=<id>&limit=<n>&unread=true
+func MentionsFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	before, err := queryInt(r, "before", 0)
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	feed, err := controllers.GetMentions(userID, before, limit, unreadOnly)
+	if err != nil {
+		writeControllerError(w, err, "Could not fetch mentions")
+		return
+	}
+
+	json.NewEncoder(w).Encode(feed)
+}
+
+// MarkMentionsReadHandler handles POST /me/mentions/read
+func MarkMentionsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input models.MarkMentionsReadInput
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := controllers.MarkMentionsRead(userID, input.UpToID)
+	if err != nil {
+		writeControllerError(w, err, "Could not mark mentions as read")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
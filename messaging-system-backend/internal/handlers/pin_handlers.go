@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// PinHandler handles POST (pin) and DELETE (unpin) /messages/{id}/pin
+func PinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var input models.PinInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.MessageID = messageID
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var resp map[string]string
+	if r.Method == http.MethodPost {
+		resp, err = controllers.PinMessage(input, userID)
+	} else {
+		resp, err = controllers.UnpinMessage(input, userID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrPinNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, controllers.ErrTooManyPins):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			writeControllerError(w, err, "Could not update pin")
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PinnedMessagesHandler handles GET /chats/pins?type=<dm|group>&id=<chat_id>
+func PinnedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chatType := r.URL.Query().Get("type")
+	chatID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	pins, err := controllers.GetPinnedMessages(chatType, chatID, userID)
+	if err != nil {
+		writeControllerError(w, err, "Could not fetch pinned messages")
+		return
+	}
+
+	json.NewEncoder(w).Encode(pins)
+}
+
+// DeleteMessageHandler handles DELETE /messages/{id}, deleting the message for everyone
+func DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var input models.DeleteMessageInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.MessageID = messageID
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := controllers.DeleteMessageForEveryone(input, userID)
+	if err != nil {
+		if errors.Is(err, controllers.ErrDeleteNotAllowed) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		writeControllerError(w, err, "Could not delete message")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// CreatePollHandler handles POST /polls
+func CreatePollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input models.CreatePollInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	poll, err := controllers.CreatePoll(input, userID)
+	if err != nil {
+		writePollError(w, err, "Could not create poll")
+		return
+	}
+
+	json.NewEncoder(w).Encode(poll)
+}
+
+// PollHandler handles GET /polls/{id}, where id is the poll's message ID
+func PollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid poll ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	poll, err := controllers.GetPoll(messageID, userID)
+	if err != nil {
+		writePollError(w, err, "Could not fetch poll")
+		return
+	}
+
+	json.NewEncoder(w).Encode(poll)
+}
+
+// VotePollHandler handles POST /polls/{id}/vote
+func VotePollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid poll ID", http.StatusBadRequest)
+		return
+	}
+
+	var input models.PollVoteInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	poll, err := controllers.VotePoll(messageID, input, userID)
+	if err != nil {
+		writePollError(w, err, "Could not record vote")
+		return
+	}
+
+	json.NewEncoder(w).Encode(poll)
+}
+
+// ClosePollHandler handles POST /polls/{id}/close
+func ClosePollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid poll ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	poll, err := controllers.ClosePoll(messageID, userID)
+	if err != nil {
+		writePollError(w, err, "Could not close poll")
+		return
+	}
+
+	json.NewEncoder(w).Encode(poll)
+}
+
+// writePollError maps poll errors to HTTP status codes
+func writePollError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, controllers.ErrInvalidPoll), errors.Is(err, controllers.ErrInvalidPollVote):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, controllers.ErrPollNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, controllers.ErrPollCloseNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, controllers.ErrPollClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeControllerError(w, err, fallback)
+	}
+}
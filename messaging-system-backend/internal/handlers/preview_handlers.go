@@ -17,7 +17,9 @@ func ViewLatestUserChats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chats, err := controllers.GetLatestMessagesFromUsers(userID)
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+
+	chats, err := controllers.GetLatestMessagesFromUsers(userID, includeArchived)
 	if err != nil {
 		http.Error(w, "Could not fetch chats", http.StatusInternalServerError)
 		return
@@ -34,7 +36,10 @@ func ViewLatestGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	groups, err := controllers.GetLatestGroupsWithMessages(userID)
+	includePinned := r.URL.Query().Get("include_pinned") == "true"
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+
+	groups, err := controllers.GetLatestGroupsWithMessages(userID, includePinned, includeArchived)
 	if err != nil {
 		http.Error(w, "Could not fetch groups", http.StatusInternalServerError)
 		return
@@ -62,7 +67,7 @@ func ViewChatMessages(w http.ResponseWriter, r *http.Request) {
 
 	msgs, err := controllers.GetLatestMessages(chatType, chatID, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeControllerError(w, err, "Could not fetch messages")
 		return
 	}
 
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// ReactionHandler handles POST (add) and DELETE (remove) /messages/{id}/reactions
+func ReactionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var input models.ReactionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.MessageID = messageID
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var resp map[string]string
+	if r.Method == http.MethodPost {
+		resp, err = controllers.AddReaction(input, userID)
+	} else {
+		resp, err = controllers.RemoveReaction(input, userID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrInvalidEmoji):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, controllers.ErrTooManyReactions):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			writeControllerError(w, err, "Could not update reaction")
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// SaveMessageHandler handles POST (save) and DELETE (unsave) /messages/{id}/save
+func SaveMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var input models.SaveMessageInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.MessageID = messageID
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var resp map[string]string
+	if r.Method == http.MethodPost {
+		resp, err = controllers.SaveMessage(input, userID)
+	} else {
+		resp, err = controllers.UnsaveMessage(input, userID)
+	}
+	if err != nil {
+		if errors.Is(err, controllers.ErrSavedNoteTooLong) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeControllerError(w, err, "Could not update saved message")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SavedMessagesHandler handles GET /me/saved?type=<dm|group>&id=<chat_id>&before=<id>&limit=<n>
+func SavedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chatType := r.URL.Query().Get("type")
+	chatID, err := queryInt(r, "id", 0)
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+	before, err := queryInt(r, "before", 0)
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	page, err := controllers.GetSavedMessages(userID, chatType, chatID, before, limit)
+	if err != nil {
+		writeControllerError(w, err, "Could not fetch saved messages")
+		return
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// ScheduledMessagesHandler handles GET (list) and POST (create) /scheduled
+func ScheduledMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		includeDone := r.URL.Query().Get("all") == "true"
+		messages, err := controllers.GetScheduledMessages(userID, includeDone)
+		if err != nil {
+			writeControllerError(w, err, "Could not fetch scheduled messages")
+			return
+		}
+		json.NewEncoder(w).Encode(messages)
+		return
+	}
+
+	var input models.ScheduleMessageInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := controllers.ScheduleMessage(input, userID)
+	if err != nil {
+		writeScheduledError(w, err, "Could not schedule message")
+		return
+	}
+
+	json.NewEncoder(w).Encode(msg)
+}
+
+// ScheduledMessageHandler handles PATCH (edit) and DELETE (cancel) /scheduled/{id}
+func ScheduledMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch && r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid scheduled message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var resp map[string]string
+	if r.Method == http.MethodPatch {
+		var input models.UpdateScheduledMessageInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		resp, err = controllers.UpdateScheduledMessage(id, input, userID)
+	} else {
+		resp, err = controllers.CancelScheduledMessage(id, userID)
+	}
+	if err != nil {
+		writeScheduledError(w, err, "Could not update scheduled message")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeScheduledError maps scheduled message validation errors to HTTP status codes
+func writeScheduledError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, controllers.ErrInvalidSendAt), errors.Is(err, controllers.ErrEmptyScheduledMessage),
+		errors.Is(err, controllers.ErrUnsafeLink), errors.Is(err, controllers.ErrTooMuchFormatting):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, controllers.ErrScheduledNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeControllerError(w, err, fallback)
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/models"
+	"messaging-system-backend/pkg/utils"
+)
+
+// SearchMessagesHandler handles GET /search/messages?q=<text>&sender_id=<id>&type=<dm|group>&id=<chat_id>
+// &from=<RFC3339>&to=<RFC3339>&has_attachment=<bool>&order=<relevance|recency>&cursor=<cursor>&limit=<n>
+func SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	query := models.MessageSearchQuery{
+		Query:    params.Get("q"),
+		ChatType: params.Get("type"),
+		Order:    params.Get("order"),
+		Cursor:   params.Get("cursor"),
+	}
+
+	var err error
+	if query.SenderID, err = queryInt(r, "sender_id", 0); err != nil {
+		http.Error(w, "Invalid sender_id", http.StatusBadRequest)
+		return
+	}
+	if query.ChatID, err = queryInt(r, "id", 0); err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+	if query.Limit, err = queryInt(r, "limit", 0); err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	for name, dst := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+		if value := params.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid "+name+" date", http.StatusBadRequest)
+				return
+			}
+			*dst = &t
+		}
+	}
+	if value := params.Get("has_attachment"); value != "" {
+		hasAttachment, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid has_attachment", http.StatusBadRequest)
+			return
+		}
+		query.HasAttachment = &hasAttachment
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	page, err := controllers.SearchMessages(query, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrInvalidSearchQuery),
+			errors.Is(err, controllers.ErrInvalidSearchCursor),
+			errors.Is(err, controllers.ErrInvalidSearchOrder):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeControllerError(w, err, "Could not search messages")
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/pkg/utils"
+)
+
+// ViewThreadHandler handles GET /threads/{id}?after=<reply_id>&limit=<n>
+func ViewThreadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rootID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
+		return
+	}
+
+	after, err := queryInt(r, "after", 0)
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	thread, err := controllers.GetThread(rootID, userID, after, limit)
+	if err != nil {
+		writeControllerError(w, err, "Could not fetch thread")
+		return
+	}
+
+	json.NewEncoder(w).Encode(thread)
+}
+
+// FollowThreadHandler handles POST /threads/{id}/follow
+func FollowThreadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rootID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := controllers.FollowThread(rootID, userID)
+	if err != nil {
+		writeControllerError(w, err, "Could not follow thread")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UnfollowThreadHandler handles POST /threads/{id}/unfollow
+func UnfollowThreadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rootID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ExtractUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := controllers.UnfollowThread(rootID, userID)
+	if err != nil {
+		writeControllerError(w, err, "Could not unfollow thread")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
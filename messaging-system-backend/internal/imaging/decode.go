@@ -0,0 +1,61 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// MaxPixels bounds the decoded size of an image to protect against decompression bombs
+const MaxPixels = 50_000_000
+
+// ErrImageTooLarge is returned when an image has more pixels than MaxPixels
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+// Decode decodes a JPEG, PNG or GIF (first frame only) and applies the JPEG
+// EXIF orientation so the result is upright
+func Decode(data []byte, contentType string) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err == nil {
+			img = ApplyOrientation(img, JPEGOrientation(data))
+		}
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, image.ErrFormat
+	}
+	return img, err
+}
+
+// Encode writes a thumbnail as JPEG for JPEG sources and as PNG otherwise,
+// so transparency in PNG and GIF images survives. It returns the encoded
+// bytes and their content type.
+func Encode(img image.Image, sourceContentType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if sourceContentType == "image/jpeg" {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 82}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
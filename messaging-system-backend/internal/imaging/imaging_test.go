@@ -0,0 +1,101 @@
+package imaging_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"messaging-system-backend/internal/imaging"
+)
+
+// exifSegment builds an APP1 segment with an orientation tag followed by fake GPS data
+func exifSegment(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("II\x2A\x00")
+	binary.Write(&tiff, binary.LittleEndian, uint32(8))
+	binary.Write(&tiff, binary.LittleEndian, uint16(1))
+	binary.Write(&tiff, binary.LittleEndian, uint16(0x0112))
+	binary.Write(&tiff, binary.LittleEndian, uint16(3))
+	binary.Write(&tiff, binary.LittleEndian, uint32(1))
+	binary.Write(&tiff, binary.LittleEndian, uint32(orientation))
+	binary.Write(&tiff, binary.LittleEndian, uint32(0))
+	tiff.WriteString("GPSLatitude 52.5200 GPSLongitude 13.4050")
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestStripMetadataRemovesGPSAndKeepsOrientation(t *testing.T) {
+	plain := testJPEG(t, 40, 20)
+	withExif := append(append(append([]byte{}, plain[:2]...), exifSegment(6)...), plain[2:]...)
+
+	if got := imaging.JPEGOrientation(withExif); got != 6 {
+		t.Fatalf("Expected orientation 6 before stripping, got %d", got)
+	}
+
+	stripped, err := imaging.StripMetadata(withExif, "image/jpeg")
+	if err != nil {
+		t.Fatalf("StripMetadata failed: %v", err)
+	}
+	if bytes.Contains(stripped, []byte("GPS")) {
+		t.Error("Expected GPS metadata to be removed")
+	}
+	if got := imaging.JPEGOrientation(stripped); got != 6 {
+		t.Errorf("Expected orientation 6 to be preserved, got %d", got)
+	}
+
+	img, err := imaging.Decode(stripped, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Stripped image does not decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Errorf("Expected rotated image to be 20x40, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestFitKeepsAspectRatio(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	thumb := imaging.Fit(img, 320)
+	if b := thumb.Bounds(); b.Dx() != 320 || b.Dy() != 160 {
+		t.Errorf("Expected 320x160, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestBlurHashOfSolidImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 255, 0, 0, 255
+	}
+
+	hash := imaging.BlurHash(img, 4, 3)
+	// 1 size flag + 1 max AC + 4 DC + 2 per AC component
+	if len(hash) != 1+1+4+2*11 {
+		t.Fatalf("Unexpected blurhash length %d: %q", len(hash), hash)
+	}
+	// 4x3 components encode as "L"; the DC term of pure red is 0xFF0000
+	if hash[:1] != "L" {
+		t.Errorf("Expected size flag L, got %q", hash[:1])
+	}
+	if hash[2:6] != "TI:j" {
+		t.Errorf("Expected DC component TI:j (pure red), got %q", hash[2:6])
+	}
+}
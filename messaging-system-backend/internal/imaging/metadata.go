@@ -0,0 +1,325 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// ErrMalformedImage is returned when an image cannot be parsed for metadata removal
+var ErrMalformedImage = errors.New("malformed image")
+
+// StripMetadata removes metadata such as EXIF (including GPS location), XMP,
+// IPTC and comments from a JPEG, PNG or GIF without re-encoding the pixels.
+// JPEG orientation is preserved in a minimal EXIF block so images still
+// display upright. Other content types are returned unchanged.
+func StripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/gif":
+		return stripGIF(data)
+	default:
+		return data, nil
+	}
+}
+
+// stripJPEG keeps only the segments needed to decode and color-manage the image
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrMalformedImage
+	}
+	orientation := JPEGOrientation(data)
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	insertedOrientation := orientation == 1
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, ErrMalformedImage
+		}
+		// Skip fill bytes
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, ErrMalformedImage
+		}
+		marker := data[i+1]
+
+		// Markers without a length field
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		if marker == 0xD9 {
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+
+		if i+4 > len(data) {
+			return nil, ErrMalformedImage
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrMalformedImage
+		}
+		segment := data[i:end]
+		payload := data[i+4 : end]
+
+		if !insertedOrientation && marker != 0xE0 {
+			out.Write(orientationSegment(orientation))
+			insertedOrientation = true
+		}
+
+		if keepJPEGSegment(marker, payload) {
+			out.Write(segment)
+		}
+
+		if marker == 0xDA {
+			// Start of scan: the rest is entropy-coded data
+			out.Write(data[end:])
+			return out.Bytes(), nil
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+// keepJPEGSegment reports whether a JPEG segment is needed to render the image.
+// APP0 (JFIF), ICC profiles in APP2 and the Adobe APP14 color transform are
+// kept; every other application segment and comments are dropped.
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0:
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xEE:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xE1 && marker <= 0xEF:
+		return false
+	case marker == 0xFE:
+		return false
+	default:
+		return true
+	}
+}
+
+// orientationSegment builds an APP1 EXIF segment holding only the orientation tag
+func orientationSegment(orientation int) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2A")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))      // IFD0 offset
+	binary.Write(&tiff, binary.BigEndian, uint16(1))      // one entry
+	binary.Write(&tiff, binary.BigEndian, uint16(0x0112)) // Orientation
+	binary.Write(&tiff, binary.BigEndian, uint16(3))      // SHORT
+	binary.Write(&tiff, binary.BigEndian, uint32(1))      // count
+	binary.Write(&tiff, binary.BigEndian, uint16(orientation))
+	binary.Write(&tiff, binary.BigEndian, uint16(0)) // padding
+	binary.Write(&tiff, binary.BigEndian, uint32(0)) // no next IFD
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// JPEGOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 when absent
+func JPEGOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		payload := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			if o := exifOrientation(payload[6:]); o != 0 {
+				return o
+			}
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from IFD0 of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// droppedPNGChunks are ancillary chunks that carry metadata
+var droppedPNGChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// stripPNG removes text, EXIF and timestamp chunks from a PNG
+func stripPNG(data []byte) ([]byte, error) {
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	if !bytes.HasPrefix(data, signature) {
+		return nil, ErrMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(signature)
+
+	i := len(signature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrMalformedImage
+		}
+		if crc32.ChecksumIEEE(data[i+4:i+8+length]) != binary.BigEndian.Uint32(data[i+8+length:end]) {
+			return nil, ErrMalformedImage
+		}
+		if !droppedPNGChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// stripGIF removes comment extensions and application extensions other than
+// the animation loop extensions from a GIF
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, ErrMalformedImage
+	}
+
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << ((flags & 0x07) + 1)
+	}
+	if i > len(data) {
+		return nil, ErrMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:i])
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // trailer
+			out.WriteByte(0x3B)
+			return out.Bytes(), nil
+
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, ErrMalformedImage
+			}
+			label := data[i+1]
+			end, err := skipSubBlocks(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			keep := label != 0xFE
+			if label == 0xFF {
+				app := data[i+3 : min(i+14, len(data))]
+				keep = bytes.HasPrefix(app, []byte("NETSCAPE2.0")) || bytes.HasPrefix(app, []byte("ANIMEXTS1.0"))
+			}
+			if keep {
+				out.Write(data[i:end])
+			}
+			i = end
+
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return nil, ErrMalformedImage
+			}
+			start := i
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << ((flags & 0x07) + 1)
+			}
+			i++ // LZW minimum code size
+			if i > len(data) {
+				return nil, ErrMalformedImage
+			}
+			end, err := skipSubBlocks(data, i)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(data[start:end])
+			i = end
+
+		default:
+			return nil, ErrMalformedImage
+		}
+	}
+	return nil, ErrMalformedImage
+}
+
+// skipSubBlocks returns the index just past a chain of GIF data sub-blocks
+func skipSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, ErrMalformedImage
+		}
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
@@ -0,0 +1,64 @@
+package mentions
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Mention kinds
+const (
+	KindUser   = "user"
+	KindAll    = "all"
+	KindAdmins = "admins"
+)
+
+// Token is an @mention found in message text. Offset and Length are counted
+// in Unicode code points and cover the whole token including the "@".
+type Token struct {
+	Kind     string
+	Username string
+	Offset   int
+	Length   int
+}
+
+// Parse finds @username, @all and @admins tokens in text. An "@" only starts a
+// mention at the beginning of the text or after a character that cannot be part
+// of a username, so e-mail addresses are ignored. Trailing dots are treated as
+// punctuation rather than part of the username.
+func Parse(text string) []Token {
+	runes := []rune(text)
+	var tokens []Token
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && isUsernameRune(runes[i-1])) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isUsernameRune(runes[end]) {
+			end++
+		}
+		for end > i+1 && runes[end-1] == '.' {
+			end--
+		}
+		if end == i+1 {
+			continue
+		}
+
+		name := string(runes[i+1 : end])
+		token := Token{Kind: KindUser, Username: name, Offset: i, Length: end - i}
+		switch strings.ToLower(name) {
+		case "all":
+			token.Kind, token.Username = KindAll, ""
+		case "admins":
+			token.Kind, token.Username = KindAdmins, ""
+		}
+		tokens = append(tokens, token)
+		i = end - 1
+	}
+	return tokens
+}
+
+// isUsernameRune reports whether r may appear in a mentionable username
+func isUsernameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
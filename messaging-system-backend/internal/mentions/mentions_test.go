@@ -0,0 +1,46 @@
+package mentions_test
+
+import (
+	"reflect"
+	"testing"
+
+	"messaging-system-backend/internal/mentions"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want []mentions.Token
+	}{
+		{
+			text: "hi @naman, ping @all",
+			want: []mentions.Token{
+				{Kind: mentions.KindUser, Username: "naman", Offset: 3, Length: 6},
+				{Kind: mentions.KindAll, Offset: 16, Length: 4},
+			},
+		},
+		{
+			text: "mail naman@example.com or ask @Admins.",
+			want: []mentions.Token{
+				{Kind: mentions.KindAdmins, Offset: 30, Length: 7},
+			},
+		},
+		{
+			text: "héllo @saawan_1.",
+			want: []mentions.Token{
+				{Kind: mentions.KindUser, Username: "saawan_1", Offset: 6, Length: 9},
+			},
+		},
+		{
+			text: "no mentions @ here",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		got := mentions.Parse(tt.text)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
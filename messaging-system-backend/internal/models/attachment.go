@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Attachment models the metadata of a file sent with a message
+type Attachment struct {
+	ID          int         `json:"id"`
+	Filename    string      `json:"filename"`
+	ContentType string      `json:"content_type"`
+	SizeBytes   int64       `json:"size_bytes"`
+	Width       *int        `json:"width,omitempty"`
+	Height      *int        `json:"height,omitempty"`
+	Blurhash    string      `json:"blurhash,omitempty"`
+	Thumbnails  []Thumbnail `json:"thumbnails,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// Thumbnail models a downscaled preview of an image attachment
+type Thumbnail struct {
+	Size   int `json:"size"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// AttachmentURL models a short-lived signed download link for an attachment
+type AttachmentURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
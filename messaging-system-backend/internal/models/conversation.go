@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// ConversationState models the user's own inbox settings for a DM or group.
+// A mute without MutedUntil lasts until it is lifted.
+type ConversationState struct {
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	Muted      bool       `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// ConversationStateInput models a change to the user's conversation state.
+// Omitted fields keep their value; Muted without MutedUntil mutes forever.
+type ConversationStateInput struct {
+	Archived   *bool      `json:"archived,omitempty"`
+	Pinned     *bool      `json:"pinned,omitempty"`
+	Muted      *bool      `json:"muted,omitempty"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
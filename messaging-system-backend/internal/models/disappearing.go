@@ -0,0 +1,11 @@
+package models
+
+// DisappearingSettings models the disappearing messages timer of a DM
+// (ChatID is the other user) or group. AfterSeconds of zero turns it off.
+type DisappearingSettings struct {
+	ChatType     string `json:"chat_type"`
+	ChatID       int    `json:"chat_id"`
+	AfterSeconds int    `json:"after_seconds"`
+	// From is "send" to start the timer when a message is sent or "read" to start it on first read
+	From string `json:"from"`
+}
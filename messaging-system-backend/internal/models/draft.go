@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Draft models a user's unsent message in a conversation. ClientUpdatedAt is
+// the time the client last changed it and decides which save wins.
+type Draft struct {
+	ConversationID  int       `json:"conversation_id"`
+	Content         string    `json:"content"`
+	ReplyToID       *int      `json:"reply_to_id,omitempty"`
+	ClientUpdatedAt time.Time `json:"client_updated_at"`
+}
+
+// DraftInput models saving a draft. An empty content without a reply target
+// clears the draft.
+type DraftInput struct {
+	Content         string    `json:"content"`
+	ReplyToID       *int      `json:"reply_to_id,omitempty"`
+	ClientUpdatedAt time.Time `json:"client_updated_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+// TextEntity models a formatted span of a message's plain text content.
+// Offset and Length are counted in Unicode code points. Type is one of bold,
+// italic, code, pre, link, bullet_item or ordered_item.
+type TextEntity struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	URL      string `json:"url,omitempty"`
+	Language string `json:"language,omitempty"`
+	Number   int    `json:"number,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Event models a real-time notification published to live clients
+type Event struct {
+	Type      string      `json:"type"`
+	ChatType  string      `json:"chat_type"`
+	GroupID   int         `json:"group_id,omitempty"`
+	MessageID int         `json:"message_id,omitempty"`
+	UserID    int         `json:"user_id"`
+	Data      interface{} `json:"data,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
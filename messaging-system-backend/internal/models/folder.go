@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// ChatFolder models a user-defined inbox folder. Folders are listed by
+// Position, lowest first.
+type ChatFolder struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	FolderRules
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FolderRules decide which conversations a folder shows. A conversation is
+// shown when it is in IncludeIDs or matches every rule that is set, unless it
+// is in ExcludeIDs. Types holds "dm" and/or "group"; Unread and Muted match
+// either state when left out; AdminOnly keeps groups the user administers.
+type FolderRules struct {
+	Types      []string `json:"types"`
+	Unread     *bool    `json:"unread,omitempty"`
+	Muted      *bool    `json:"muted,omitempty"`
+	AdminOnly  bool     `json:"admin_only"`
+	IncludeIDs []int    `json:"include_ids"`
+	ExcludeIDs []int    `json:"exclude_ids"`
+}
+
+// ChatFolderInput models the input for creating or replacing a folder
+type ChatFolderInput struct {
+	Name string `json:"name"`
+	FolderRules
+}
+
+// FolderOrderInput models a new order for all of the user's folders
+type FolderOrderInput struct {
+	FolderIDs []int `json:"folder_ids"`
+}
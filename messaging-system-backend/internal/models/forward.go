@@ -0,0 +1,40 @@
+package models
+
+// ForwardDestination identifies a DM (ID is the other user) or group to forward to
+type ForwardDestination struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+// ForwardInput models the input for forwarding messages to other conversations
+type ForwardInput struct {
+	SourceType   string               `json:"source_type"`
+	MessageIDs   []int                `json:"message_ids"`
+	Destinations []ForwardDestination `json:"destinations"`
+}
+
+// ForwardedMessage models one message created by a forward
+type ForwardedMessage struct {
+	SourceMessageID int                `json:"source_message_id"`
+	Destination     ForwardDestination `json:"destination"`
+	MessageID       int                `json:"message_id"`
+}
+
+// ForwardResult models the messages created by a forward request
+type ForwardResult struct {
+	Messages []ForwardedMessage `json:"messages"`
+}
+
+// ForwardOrigin models the attribution shown on a forwarded message. When the
+// original author hides their identity only Hidden is set.
+type ForwardOrigin struct {
+	UserID   *int   `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Hidden   bool   `json:"hidden"`
+}
+
+// PrivacySettings models a user's privacy preferences
+type PrivacySettings struct {
+	// ForwardAttribution is "everyone" to show the author on forwarded messages or "nobody" to hide it
+	ForwardAttribution string `json:"forward_attribution"`
+}
@@ -37,3 +37,10 @@ type PromoteOrDemoteInput struct {
 	GroupID int `json:"group_id"`
 	UserID  int `json:"user_id"`
 }
+
+// SlowModeSettings models the minimum number of seconds between one member's
+// messages in a group. 0 means slow mode is off.
+type SlowModeSettings struct {
+	GroupID int `json:"group_id"`
+	Seconds int `json:"seconds"`
+}
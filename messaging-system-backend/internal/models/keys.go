@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// Prekey models a one-time prekey a device publishes for starting sessions
+type Prekey struct {
+	KeyID     int    `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// SignedPrekey models a device's medium-term prekey, signed with its
+// identity key
+type SignedPrekey struct {
+	KeyID     int    `json:"key_id"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// Device models one of a user's devices in the key directory. Keys are
+// base64 encoded.
+type Device struct {
+	DeviceID           int          `json:"device_id"`
+	IdentityKey        string       `json:"identity_key"`
+	SignedPrekey       SignedPrekey `json:"signed_prekey"`
+	OneTimePrekeysLeft int          `json:"one_time_prekeys_left"`
+	CreatedAt          time.Time    `json:"created_at"`
+	UpdatedAt          time.Time    `json:"updated_at"`
+}
+
+// DeviceKeysInput models registering a device or rotating its keys. A new
+// identity key discards the device's remaining one-time prekeys.
+type DeviceKeysInput struct {
+	IdentityKey    string       `json:"identity_key"`
+	SignedPrekey   SignedPrekey `json:"signed_prekey"`
+	OneTimePrekeys []Prekey     `json:"one_time_prekeys,omitempty"`
+}
+
+// PrekeyUploadInput models topping up a device's one-time prekeys
+type PrekeyUploadInput struct {
+	OneTimePrekeys []Prekey `json:"one_time_prekeys"`
+}
+
+// PrekeyCount models how many one-time prekeys a device has left
+type PrekeyCount struct {
+	DeviceID           int `json:"device_id"`
+	OneTimePrekeysLeft int `json:"one_time_prekeys_left"`
+}
+
+// PrekeyBundle models what a sender needs to start a session with one of a
+// user's devices. OneTimePrekey is nil once the device has run out.
+type PrekeyBundle struct {
+	UserID        int          `json:"user_id"`
+	DeviceID      int          `json:"device_id"`
+	IdentityKey   string       `json:"identity_key"`
+	SignedPrekey  SignedPrekey `json:"signed_prekey"`
+	OneTimePrekey *Prekey      `json:"one_time_prekey"`
+}
+
+// MessageCiphertext models an encrypted message for one recipient device
+type MessageCiphertext struct {
+	RecipientID int    `json:"recipient_id"`
+	DeviceID    int    `json:"device_id"`
+	Ciphertext  string `json:"ciphertext"`
+}
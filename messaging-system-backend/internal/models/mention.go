@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Mention models an @mention entity inside a group message. Offset and
+// Length are counted in Unicode code points.
+type Mention struct {
+	Kind   string `json:"kind"`
+	UserID *int   `json:"user_id,omitempty"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// MentionNotification models an entry in a user's mentions feed
+type MentionNotification struct {
+	ID             int       `json:"id"`
+	GroupID        int       `json:"group_id"`
+	GroupName      string    `json:"group_name"`
+	MessageID      int       `json:"message_id"`
+	SenderID       int       `json:"sender_id"`
+	SenderUsername string    `json:"sender_username"`
+	Content        string    `json:"content"`
+	Kind           string    `json:"kind"`
+	Read           bool      `json:"read"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// MentionFeed models a page of the mentions feed
+type MentionFeed struct {
+	Mentions   []MentionNotification `json:"mentions"`
+	NextCursor *int                  `json:"next_cursor,omitempty"`
+}
+
+// MarkMentionsReadInput models the input for marking mentions as read.
+// A zero UpToID marks every mention as read.
+type MarkMentionsReadInput struct {
+	UpToID int `json:"up_to_id"`
+}
@@ -2,38 +2,71 @@ package models
 
 import "time"
 
-// Message models a message in the messaging system
+// Message models a message in the messaging system. An encrypted message
+// has no content and carries a ciphertext for each recipient device instead.
 type Message struct {
-	ID         int       `json:"id"`
-	SenderID   int       `json:"sender_id"`
-	ReceiverID int       `json:"receiver_id"`
-	Content    string    `json:"content"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID          int                 `json:"id"`
+	SenderID    int                 `json:"sender_id"`
+	ReceiverID  int                 `json:"receiver_id"`
+	Content     string              `json:"content"`
+	Encrypted   bool                `json:"encrypted,omitempty"`
+	Ciphertexts []MessageCiphertext `json:"ciphertexts,omitempty"`
+	ReplyToID   *int                `json:"reply_to_id,omitempty"`
+	ClientMsgID string              `json:"client_msg_id,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
 }
 
 // GroupMessageInput models the input for sending a message to a group
 type GroupMessageInput struct {
-	ID        int       `json:"id"`
-	GroupID   int       `json:"group_id"`
-	SenderID  int       `json:"sender_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           int       `json:"id"`
+	GroupID      int       `json:"group_id"`
+	SenderID     int       `json:"sender_id"`
+	Content      string    `json:"content"`
+	ReplyToID    *int      `json:"reply_to_id,omitempty"`
+	ThreadRootID *int      `json:"thread_root_id,omitempty"`
+	ClientMsgID  string    `json:"client_msg_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 // ChatMessage models a message in a chat, which can be sent to a user or a group
 type ChatMessage struct {
-	ID             int       `json:"id"`
-	GroupID        *int      `json:"group_id,omitempty"`
-	SenderID       int       `json:"sender_id"`
-	ReceiverID     int       `json:"receiver_id,omitempty"`
-	Content        string    `json:"content"`
-	CreatedAt      time.Time `json:"created_at"`
-	SenderStatus   string    `json:"sender_status"`
-	ReceiverStatus string    `json:"receiver_status"`
+	ID             int                 `json:"id"`
+	ConversationID int                 `json:"conversation_id,omitempty"`
+	GroupID        *int                `json:"group_id,omitempty"`
+	SenderID       int                 `json:"sender_id"`
+	ReceiverID     int                 `json:"receiver_id,omitempty"`
+	Kind           string              `json:"kind,omitempty"`
+	Content        string              `json:"content"`
+	Entities       []TextEntity        `json:"entities,omitempty"`
+	Poll           *Poll               `json:"poll,omitempty"`
+	Ciphertexts    []MessageCiphertext `json:"ciphertexts,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	SenderStatus   string              `json:"sender_status"`
+	ReceiverStatus string              `json:"receiver_status"`
+	Edited         bool                `json:"edited"`
+	EditedAt       *time.Time          `json:"edited_at,omitempty"`
+	ReplyToID      *int                `json:"reply_to_id,omitempty"`
+	ReplyTo        *ReplySnippet       `json:"reply_to,omitempty"`
+	ThreadRootID   *int                `json:"thread_root_id,omitempty"`
+	Thread         *ThreadSummary      `json:"thread,omitempty"`
+	Reactions      []ReactionCount     `json:"reactions,omitempty"`
+	Attachments    []Attachment        `json:"attachments,omitempty"`
+	Mentions       []Mention           `json:"mentions,omitempty"`
+	ForwardedFrom  *ForwardOrigin      `json:"forwarded_from,omitempty"`
+	ExpiresAt      *time.Time          `json:"expires_at,omitempty"`
+	LinkPreviews   []LinkPreview       `json:"link_previews,omitempty"`
 }
 
+// ReplySnippet models the quoted message shown above a reply
+type ReplySnippet struct {
+	ID             int    `json:"id"`
+	SenderID       int    `json:"sender_id,omitempty"`
+	SenderUsername string `json:"sender_username,omitempty"`
+	Content        string `json:"content,omitempty"`
+	Deleted        bool   `json:"deleted"`
+}
 
 // EditMessageInput models the input for editing a message
 type EditMessageInput struct {
@@ -41,3 +74,13 @@ type EditMessageInput struct {
 	NewContent    string    `json:"new_content"`
 	LastUpdatedAt time.Time `json:"last_updated_at"`
 }
+
+// MessageRevision models a previous version of an edited message
+type MessageRevision struct {
+	ID          int       `json:"id"`
+	MessageType string    `json:"message_type"`
+	MessageID   int       `json:"message_id"`
+	Content     string    `json:"content"`
+	EditedBy    *int      `json:"edited_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
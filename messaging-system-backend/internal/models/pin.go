@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// PinInput models the input for pinning or unpinning a message
+type PinInput struct {
+	MessageType string `json:"message_type"`
+	MessageID   int    `json:"message_id"`
+}
+
+// PinnedMessage models a pinned message with its current content
+type PinnedMessage struct {
+	MessageType string    `json:"message_type"`
+	MessageID   int       `json:"message_id"`
+	SenderID    int       `json:"sender_id"`
+	Content     string    `json:"content"`
+	CreatedAt   time.Time `json:"created_at"`
+	PinnedBy    *int      `json:"pinned_by,omitempty"`
+	PinnedAt    time.Time `json:"pinned_at"`
+}
+
+// DeleteMessageInput models the input for deleting a message for everyone
+type DeleteMessageInput struct {
+	MessageType string `json:"message_type"`
+	MessageID   int    `json:"message_id"`
+}
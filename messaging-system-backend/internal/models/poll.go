@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// CreatePollInput models the input for posting a poll to a group
+type CreatePollInput struct {
+	GroupID        int        `json:"group_id"`
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MultipleChoice bool       `json:"multiple_choice"`
+	Anonymous      bool       `json:"anonymous"`
+	ClosesAt       *time.Time `json:"closes_at,omitempty"`
+}
+
+// PollVoteInput models the options a user votes for. An empty list retracts the vote.
+type PollVoteInput struct {
+	OptionIDs []int `json:"option_ids"`
+}
+
+// PollOption models one answer of a poll with its current vote count.
+// Voters is only filled in for polls with visible voters.
+type PollOption struct {
+	ID     int    `json:"id"`
+	Text   string `json:"text"`
+	Votes  int    `json:"votes"`
+	Voters []int  `json:"voters,omitempty"`
+}
+
+// Poll models a poll message with its aggregated results. MessageID is the
+// group message that carries the poll.
+type Poll struct {
+	MessageID      int          `json:"message_id"`
+	GroupID        int          `json:"group_id"`
+	CreatorID      int          `json:"creator_id"`
+	Question       string       `json:"question"`
+	Options        []PollOption `json:"options"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	Anonymous      bool         `json:"anonymous"`
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`
+	ClosedAt       *time.Time   `json:"closed_at,omitempty"`
+	Closed         bool         `json:"closed"`
+	TotalVoters    int          `json:"total_voters"`
+	MyVotes        []int        `json:"my_votes,omitempty"`
+}
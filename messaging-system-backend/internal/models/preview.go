@@ -5,12 +5,15 @@ import "time"
 // Preview models for messages and groups to be used in the messaging system
 type MessagePreview struct {
 	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
 	SenderID       int       `json:"sender_id"`
 	ReceiverID     int       `json:"receiver_id"`
 	SenderStatus   string    `json:"sender_status"`   // Add this
 	ReceiverStatus string    `json:"receiver_status"` // Add this
 	Content        string    `json:"content"`
 	CreatedAt      time.Time `json:"created_at"`
+	Draft          *Draft    `json:"draft,omitempty"`
+	ConversationState
 }
 
 
@@ -19,8 +22,33 @@ type GroupPreview struct {
 	ID             int       `json:"id"`
 	Name           string    `json:"name"`
 	LastMessage    string    `json:"last_message"`
+	LastMessageType string   `json:"last_message_type"`
 	LastMessageTime time.Time `json:"last_message_time"`
 	SenderStatus   string    `json:"sender_status"`
 	ReceiverStatus string    `json:"receiver_status"`
+	PinnedMessage  *PinnedMessage `json:"pinned_message,omitempty"`
+	Draft          *Draft         `json:"draft,omitempty"`
+	ConversationState
 }
 
+// ConversationPreview models a DM or group conversation in the user's inbox.
+// Title is the group name or the other user's username.
+type ConversationPreview struct {
+	ID          int                 `json:"id"`
+	Type        string              `json:"type"`
+	Title       string              `json:"title"`
+	OtherUserID int                 `json:"other_user_id,omitempty"`
+	LastMessage *LastMessagePreview `json:"last_message,omitempty"`
+	Unread      bool                `json:"unread"`
+	Draft       *Draft              `json:"draft,omitempty"`
+	ConversationState
+}
+
+// LastMessagePreview models the latest top-level message of a conversation
+type LastMessagePreview struct {
+	ID        int       `json:"id"`
+	SenderID  int       `json:"sender_id"`
+	Kind      string    `json:"kind"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
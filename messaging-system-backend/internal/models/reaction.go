@@ -0,0 +1,15 @@
+package models
+
+// ReactionInput models the input for adding or removing an emoji reaction
+type ReactionInput struct {
+	MessageType string `json:"message_type"`
+	MessageID   int    `json:"message_id"`
+	Emoji       string `json:"emoji"`
+}
+
+// ReactionCount models the aggregated reactions of one emoji on a message
+type ReactionCount struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// SaveMessageInput models the input for saving or unsaving a message
+type SaveMessageInput struct {
+	MessageType string `json:"message_type"`
+	MessageID   int    `json:"message_id"`
+	Note        string `json:"note,omitempty"`
+}
+
+// SavedMessage models a bookmarked message with its current content
+type SavedMessage struct {
+	ID               int       `json:"id"`
+	MessageType      string    `json:"message_type"`
+	MessageID        int       `json:"message_id"`
+	GroupID          *int      `json:"group_id,omitempty"`
+	ReceiverID       *int      `json:"receiver_id,omitempty"`
+	SenderID         int       `json:"sender_id"`
+	SenderUsername   string    `json:"sender_username"`
+	Content          string    `json:"content"`
+	MessageCreatedAt time.Time `json:"message_created_at"`
+	Note             string    `json:"note,omitempty"`
+	SavedAt          time.Time `json:"saved_at"`
+}
+
+// SavedMessagesPage models a page of the saved messages list
+type SavedMessagesPage struct {
+	Items      []SavedMessage `json:"items"`
+	NextCursor *int           `json:"next_cursor,omitempty"`
+}
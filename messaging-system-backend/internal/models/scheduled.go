@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// ScheduledMessage models a DM or group message waiting to be sent at SendAt
+type ScheduledMessage struct {
+	ID            int        `json:"id"`
+	SenderID      int        `json:"sender_id"`
+	ChatType      string     `json:"chat_type"`
+	ChatID        int        `json:"chat_id"`
+	Content       string     `json:"content"`
+	SendAt        time.Time  `json:"send_at"`
+	Status        string     `json:"status"`
+	FailureReason string     `json:"failure_reason,omitempty"`
+	SentMessageID *int       `json:"sent_message_id,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// ScheduleMessageInput models the input for scheduling a message. ChatID is
+// the receiver for DMs and the group for group messages.
+type ScheduleMessageInput struct {
+	ChatType string    `json:"chat_type"`
+	ChatID   int       `json:"chat_id"`
+	Content  string    `json:"content"`
+	SendAt   time.Time `json:"send_at"`
+}
+
+// UpdateScheduledMessageInput models the input for editing a pending scheduled message
+type UpdateScheduledMessageInput struct {
+	Content *string    `json:"content,omitempty"`
+	SendAt  *time.Time `json:"send_at,omitempty"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// MessageSearchQuery models the filters and paging of a message search
+type MessageSearchQuery struct {
+	Query         string
+	SenderID      int
+	ChatType      string
+	ChatID        int
+	From          *time.Time
+	To            *time.Time
+	HasAttachment *bool
+	// Order is "relevance" (default) or "recency"
+	Order  string
+	Cursor string
+	Limit  int
+}
+
+// MessageSearchResult models one message matching a search. Snippet is HTML:
+// the message text is escaped and the matching words wrapped in <mark> tags.
+type MessageSearchResult struct {
+	ChatType   string    `json:"chat_type"`
+	MessageID  int       `json:"message_id"`
+	GroupID    *int      `json:"group_id,omitempty"`
+	ReceiverID *int      `json:"receiver_id,omitempty"`
+	SenderID   int       `json:"sender_id"`
+	Content    string    `json:"content"`
+	Snippet    string    `json:"snippet"`
+	CreatedAt  time.Time `json:"created_at"`
+	Rank       float64   `json:"rank"`
+}
+
+// MessageSearchPage models a page of search results
+type MessageSearchPage struct {
+	Results    []MessageSearchResult `json:"results"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
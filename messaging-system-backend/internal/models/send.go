@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// SendResult models the response to a message send. Retries with the same
+// idempotency key return the original message with Duplicate set.
+type SendResult struct {
+	Message     string    `json:"message"`
+	ID          int       `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	ClientMsgID string    `json:"client_msg_id,omitempty"`
+	Duplicate   bool      `json:"duplicate,omitempty"`
+}
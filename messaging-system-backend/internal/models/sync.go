@@ -0,0 +1,18 @@
+package models
+
+// SyncEvent is an entry in a user's sync log. Seq increases by one for every
+// event recorded for the user.
+type SyncEvent struct {
+	Seq int64 `json:"seq"`
+	Event
+}
+
+// SyncPage models a batch of sync events. Clients pass NextSeq as since on
+// the next call. When ResyncRequired is set the events after since were
+// compacted away: the client reloads its chats and continues from NextSeq.
+type SyncPage struct {
+	Events         []SyncEvent `json:"events"`
+	NextSeq        int64       `json:"next_seq"`
+	HasMore        bool        `json:"has_more"`
+	ResyncRequired bool        `json:"resync_required,omitempty"`
+}
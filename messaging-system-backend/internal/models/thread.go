@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ThreadSummary models the thread information shown on a thread's root message
+type ThreadSummary struct {
+	ReplyCount   int        `json:"reply_count"`
+	LastReplyAt  *time.Time `json:"last_reply_at,omitempty"`
+	Participants []int      `json:"participants"`
+	Following    bool       `json:"following"`
+	UnreadCount  int        `json:"unread_count"`
+}
+
+// ThreadView models a page of replies in a thread together with its root message
+type ThreadView struct {
+	Root       ChatMessage   `json:"root"`
+	Replies    []ChatMessage `json:"replies"`
+	NextCursor *int          `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,138 @@
+// Package ratelimit implements token-bucket rate limits that every app
+// instance shares through Redis.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrInvalidRate is returned by ParseRate for a malformed rate
+var ErrInvalidRate = errors.New(`rate must look like "30/10s"`)
+
+// Rate is a token bucket holding up to Burst tokens that refills all of them
+// evenly over Per
+type Rate struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseRate reads a rate written as "<burst>/<duration>", such as "30/10s"
+// for 30 requests at once and 3 more every second after that
+func ParseRate(s string) (Rate, error) {
+	burst, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rate{}, ErrInvalidRate
+	}
+	n, err := strconv.Atoi(burst)
+	if err != nil || n <= 0 {
+		return Rate{}, ErrInvalidRate
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d < time.Millisecond {
+		return Rate{}, ErrInvalidRate
+	}
+	return Rate{Burst: n, Per: d}, nil
+}
+
+// String formats the rate the way ParseRate reads it
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Burst, r.Per)
+}
+
+// Bucket applies a rate to one key, such as a user or a conversation. Cost
+// is how many tokens one use takes, one when it is zero; a cost above the
+// burst takes a full bucket.
+type Bucket struct {
+	Key  string
+	Rate Rate
+	Cost int
+}
+
+// Limiter takes tokens from a set of buckets at once
+type Limiter interface {
+	// Allow takes its cost from every bucket and returns 0, or takes nothing
+	// and returns how long to wait until all of them have enough
+	Allow(ctx context.Context, buckets ...Bucket) (time.Duration, error)
+}
+
+// takeTokensScript refills each bucket for the time since it was last used
+// and takes each bucket's cost when all have enough. It uses the Redis clock
+// so app instances with skewed clocks share the same buckets. ARGV holds the
+// burst, the refill period in milliseconds and the cost of each key in turn.
+var takeTokensScript = redis.NewScript(`
+	local now = redis.call("TIME")
+	local now_ms = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+	local tokens = {}
+	local wait = 0
+	for i, key in ipairs(KEYS) do
+		local burst = tonumber(ARGV[3 * i - 2])
+		local per_ms = tonumber(ARGV[3 * i - 1])
+		local cost = math.min(tonumber(ARGV[3 * i]), burst)
+		local state = redis.call("HMGET", key, "tokens", "ts")
+		local available = tonumber(state[1]) or burst
+		local last = tonumber(state[2]) or now_ms
+		available = math.min(burst, available + math.max(0, now_ms - last) * burst / per_ms)
+		tokens[i] = available - cost
+		if available < cost then
+			wait = math.max(wait, math.ceil((cost - available) * per_ms / burst))
+		end
+	end
+	if wait > 0 then
+		return wait
+	end
+	for i, key in ipairs(KEYS) do
+		redis.call("HSET", key, "tokens", tostring(tokens[i]), "ts", now_ms)
+		redis.call("PEXPIRE", key, ARGV[3 * i - 1])
+	end
+	return 0
+`)
+
+// RedisLimiter keeps token buckets in Redis hashes. A bucket left alone for
+// its refill period is full again and expires.
+type RedisLimiter struct {
+	client *redis.Client
+}
+
+// NewRedisLimiter returns a limiter storing its buckets in client
+func NewRedisLimiter(client *redis.Client) *RedisLimiter {
+	return &RedisLimiter{client: client}
+}
+
+// Allow takes its cost from every bucket, or nothing when one runs short
+func (l *RedisLimiter) Allow(ctx context.Context, buckets ...Bucket) (time.Duration, error) {
+	if len(buckets) == 0 {
+		return 0, nil
+	}
+	keys := make([]string, 0, len(buckets))
+	args := make([]interface{}, 0, 3*len(buckets))
+	for _, b := range buckets {
+		cost := b.Cost
+		if cost < 1 {
+			cost = 1
+		}
+		keys = append(keys, b.Key)
+		args = append(args, b.Rate.Burst, b.Rate.Per.Milliseconds(), cost)
+	}
+
+	waitMs, err := takeTokensScript.Run(ctx, l.client, keys, args...).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(waitMs) * time.Millisecond, nil
+}
+
+// RetryAfterSeconds rounds a wait up to whole seconds for a Retry-After header
+func RetryAfterSeconds(wait time.Duration) int {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
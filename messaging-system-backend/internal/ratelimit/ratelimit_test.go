@@ -0,0 +1,178 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"messaging-system-backend/internal/ratelimit"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ratelimit.Rate
+	}{
+		{"30/10s", ratelimit.Rate{Burst: 30, Per: 10 * time.Second}},
+		{" 5/1m ", ratelimit.Rate{Burst: 5, Per: time.Minute}},
+		{"1/500ms", ratelimit.Rate{Burst: 1, Per: 500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		got, err := ratelimit.ParseRate(tt.in)
+		if err != nil {
+			t.Fatalf("ParseRate(%q): %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if again, err := ratelimit.ParseRate(got.String()); err != nil || again != got {
+			t.Errorf("ParseRate(%q.String()) = %+v, %v", tt.in, again, err)
+		}
+	}
+}
+
+func TestParseRateRejectsMalformed(t *testing.T) {
+	for _, in := range []string{"", "30", "30/", "/10s", "0/10s", "-1/10s", "x/10s", "30/ten", "30/0s", "30/10us"} {
+		if _, err := ratelimit.ParseRate(in); !errors.Is(err, ratelimit.ErrInvalidRate) {
+			t.Errorf("ParseRate(%q) error = %v, want ErrInvalidRate", in, err)
+		}
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{0, 1},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1001 * time.Millisecond, 2},
+		{90 * time.Second, 90},
+	}
+	for _, tt := range tests {
+		if got := ratelimit.RetryAfterSeconds(tt.wait); got != tt.want {
+			t.Errorf("RetryAfterSeconds(%v) = %d, want %d", tt.wait, got, tt.want)
+		}
+	}
+}
+
+// newTestLimiter returns a limiter on an in-memory Redis whose clock only
+// moves when the test advances it
+func newTestLimiter(t *testing.T) (*ratelimit.RedisLimiter, *miniredis.Miniredis) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	m.SetTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return ratelimit.NewRedisLimiter(client), m
+}
+
+// allow calls Allow and fails the test on an error
+func allow(t *testing.T, l *ratelimit.RedisLimiter, buckets ...ratelimit.Bucket) time.Duration {
+	t.Helper()
+	wait, err := l.Allow(context.Background(), buckets...)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	return wait
+}
+
+func TestAllowTakesBurstThenWaits(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	bucket := ratelimit.Bucket{Key: "user", Rate: ratelimit.Rate{Burst: 3, Per: 3 * time.Second}}
+
+	for i := 0; i < 3; i++ {
+		if wait := allow(t, l, bucket); wait != 0 {
+			t.Fatalf("send %d waited %v within the burst", i+1, wait)
+		}
+	}
+	// One token refills every second
+	if wait := allow(t, l, bucket); wait != time.Second {
+		t.Errorf("wait after the burst = %v, want 1s", wait)
+	}
+}
+
+func TestAllowRefillsOverTime(t *testing.T) {
+	l, m := newTestLimiter(t)
+	bucket := ratelimit.Bucket{Key: "user", Rate: ratelimit.Rate{Burst: 2, Per: 2 * time.Second}}
+
+	allow(t, l, bucket)
+	allow(t, l, bucket)
+
+	m.SetTime(time.Date(2024, 1, 1, 0, 0, 0, int(400*time.Millisecond), time.UTC))
+	if wait := allow(t, l, bucket); wait != 600*time.Millisecond {
+		t.Errorf("wait 400ms into the refill = %v, want 600ms", wait)
+	}
+
+	m.SetTime(time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC))
+	if wait := allow(t, l, bucket); wait != 0 {
+		t.Errorf("wait once a token refilled = %v, want 0", wait)
+	}
+	if wait := allow(t, l, bucket); wait != time.Second {
+		t.Errorf("wait after using the refilled token = %v, want 1s", wait)
+	}
+}
+
+func TestAllowIsAllOrNothing(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	user := ratelimit.Bucket{Key: "user", Rate: ratelimit.Rate{Burst: 5, Per: 5 * time.Second}}
+	chat := ratelimit.Bucket{Key: "chat", Rate: ratelimit.Rate{Burst: 1, Per: 10 * time.Second}}
+
+	allow(t, l, user, chat)
+	// The chat bucket is empty, so the user bucket must keep its tokens
+	if wait := allow(t, l, user, chat); wait != 10*time.Second {
+		t.Errorf("wait on the empty chat bucket = %v, want 10s", wait)
+	}
+	for i := 0; i < 4; i++ {
+		if wait := allow(t, l, user); wait != 0 {
+			t.Fatalf("user send %d waited %v; the rejected send took a token", i+1, wait)
+		}
+	}
+	if wait := allow(t, l, user); wait == 0 {
+		t.Error("user bucket had more than 5 tokens")
+	}
+}
+
+func TestAllowWaitsForTheSlowestBucket(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	fast := ratelimit.Bucket{Key: "fast", Rate: ratelimit.Rate{Burst: 1, Per: time.Second}}
+	slow := ratelimit.Bucket{Key: "slow", Rate: ratelimit.Rate{Burst: 1, Per: time.Minute}}
+
+	allow(t, l, fast, slow)
+	if wait := allow(t, l, fast, slow); wait != time.Minute {
+		t.Errorf("wait = %v, want 1m", wait)
+	}
+}
+
+func TestAllowTakesCost(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	rate := ratelimit.Rate{Burst: 10, Per: 10 * time.Second}
+
+	if wait := allow(t, l, ratelimit.Bucket{Key: "user", Rate: rate, Cost: 8}); wait != 0 {
+		t.Fatalf("first send waited %v", wait)
+	}
+	// 2 tokens are left, so 3 more take a second to refill
+	if wait := allow(t, l, ratelimit.Bucket{Key: "user", Rate: rate, Cost: 5}); wait != 3*time.Second {
+		t.Errorf("wait for 5 tokens = %v, want 3s", wait)
+	}
+	if wait := allow(t, l, ratelimit.Bucket{Key: "user", Rate: rate, Cost: 2}); wait != 0 {
+		t.Errorf("wait for the last 2 tokens = %v, want 0", wait)
+	}
+}
+
+func TestAllowCapsCostAtBurst(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	bucket := ratelimit.Bucket{Key: "user", Rate: ratelimit.Rate{Burst: 3, Per: 3 * time.Second}, Cost: 100}
+
+	if wait := allow(t, l, bucket); wait != 0 {
+		t.Fatalf("a cost above the burst waited %v on a full bucket", wait)
+	}
+	if wait := allow(t, l, bucket); wait != 3*time.Second {
+		t.Errorf("wait after emptying the bucket = %v, want 3s", wait)
+	}
+}
@@ -0,0 +1,352 @@
+// Package richtext parses the restricted markdown dialect accepted in
+// messages into plain text and a list of formatting entities.
+//
+// Supported: **bold**, *italic* or _italic_, `code`, fenced ``` code blocks
+// with an optional language, [links](https://example.com) and "-", "*", "+"
+// or "1." list items. Headings, block quotes and images are stripped to
+// their text; HTML is not interpreted and stays literal text. Links must use
+// http, https or mailto.
+package richtext
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Entity types
+const (
+	TypeBold        = "bold"
+	TypeItalic      = "italic"
+	TypeCode        = "code"
+	TypePre         = "pre"
+	TypeLink        = "link"
+	TypeBulletItem  = "bullet_item"
+	TypeOrderedItem = "ordered_item"
+)
+
+const (
+	// MaxEntities caps the number of entities in one message
+	MaxEntities = 100
+	// maxDepth caps how deeply inline formatting may nest
+	maxDepth = 8
+	// maxLanguageLength caps the language tag of a code block
+	maxLanguageLength = 20
+)
+
+var (
+	// ErrUnsafeLink is returned for a link whose scheme is not http, https or mailto
+	ErrUnsafeLink = errors.New("links must use http, https or mailto")
+	// ErrTooManyEntities is returned when a message has more than MaxEntities formatted spans
+	ErrTooManyEntities = errors.New("message has too much formatting")
+)
+
+// Entity is a formatted span of the plain text. Offset and Length are
+// counted in Unicode code points.
+type Entity struct {
+	Type     string
+	Offset   int
+	Length   int
+	URL      string
+	Language string
+	Number   int
+}
+
+// Parse converts markdown source into plain text and formatting entities.
+// Entities are ordered by offset, outer spans before the spans they contain.
+func Parse(src string) (string, []Entity, error) {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	lines := strings.Split(src, "\n")
+
+	var out []rune
+	var entities []Entity
+	for i := 0; i < len(lines); i++ {
+		if i > 0 {
+			out = append(out, '\n')
+		}
+
+		if lang, ok := openFence(lines[i]); ok {
+			if end := closeFence(lines, i+1); end > 0 {
+				code := []rune(strings.Join(lines[i+1:end], "\n"))
+				if len(code) > 0 {
+					entities = append(entities, Entity{Type: TypePre, Offset: len(out), Length: len(code), Language: lang})
+				}
+				out = append(out, code...)
+				i = end
+				continue
+			}
+		}
+
+		line, item := stripBlockMarkers(lines[i])
+		text, lineEntities, err := parseInline([]rune(line), 0)
+		if err != nil {
+			return "", nil, err
+		}
+		if item.Type != "" && len(text) > 0 {
+			item.Offset, item.Length = len(out), len(text)
+			entities = append(entities, item)
+		}
+		for _, e := range lineEntities {
+			e.Offset += len(out)
+			entities = append(entities, e)
+		}
+		out = append(out, text...)
+	}
+
+	if len(entities) > MaxEntities {
+		return "", nil, ErrTooManyEntities
+	}
+	sort.SliceStable(entities, func(a, b int) bool {
+		if entities[a].Offset != entities[b].Offset {
+			return entities[a].Offset < entities[b].Offset
+		}
+		return entities[a].Length > entities[b].Length
+	})
+	return string(out), entities, nil
+}
+
+// openFence reports whether line opens a fenced code block and returns its language
+func openFence(line string) (string, bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || !strings.HasPrefix(trimmed, "```") {
+		return "", false
+	}
+	lang := strings.TrimSpace(trimmed[3:])
+	if strings.Contains(lang, "`") {
+		return "", false
+	}
+	if len(lang) > maxLanguageLength || strings.IndexFunc(lang, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("+#-_.", r)))
+	}) >= 0 {
+		lang = ""
+	}
+	return lang, true
+}
+
+// closeFence returns the index of the line closing a code block opened
+// before lines[from], or -1 when the block is never closed
+func closeFence(lines []string, from int) int {
+	for i := from; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "```" {
+			return i
+		}
+	}
+	return -1
+}
+
+// stripBlockMarkers removes heading and quote markers, which are not
+// supported, and turns list markers into a list item entity
+func stripBlockMarkers(line string) (string, Entity) {
+	trimmed := strings.TrimLeft(line, " \t")
+
+	if hashes := len(trimmed) - len(strings.TrimLeft(trimmed, "#")); hashes >= 1 && hashes <= 6 &&
+		len(trimmed) > hashes && trimmed[hashes] == ' ' {
+		return strings.TrimLeft(trimmed[hashes:], " "), Entity{}
+	}
+	for strings.HasPrefix(trimmed, ">") {
+		trimmed = strings.TrimLeft(trimmed[1:], " ")
+		line = trimmed
+	}
+
+	if len(trimmed) > 1 && strings.ContainsRune("-*+", rune(trimmed[0])) && trimmed[1] == ' ' {
+		return strings.TrimLeft(trimmed[2:], " "), Entity{Type: TypeBulletItem}
+	}
+	digits := len(trimmed) - len(strings.TrimLeft(trimmed, "0123456789"))
+	if digits >= 1 && digits <= 9 && len(trimmed) > digits+1 &&
+		(trimmed[digits] == '.' || trimmed[digits] == ')') && trimmed[digits+1] == ' ' {
+		number := 0
+		for _, d := range trimmed[:digits] {
+			number = number*10 + int(d-'0')
+		}
+		return strings.TrimLeft(trimmed[digits+2:], " "), Entity{Type: TypeOrderedItem, Number: number}
+	}
+	return line, Entity{}
+}
+
+// parseInline parses the inline markup of one line. Entity offsets are
+// relative to the returned text.
+func parseInline(src []rune, depth int) ([]rune, []Entity, error) {
+	var out []rune
+	var entities []Entity
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\\' && i+1 < len(src) && isPunct(src[i+1]):
+			out = append(out, src[i+1])
+			i++
+			continue
+
+		case c == '`':
+			if end := indexRune(src, i+1, '`'); end > i+1 {
+				code := src[i+1 : end]
+				entities = append(entities, Entity{Type: TypeCode, Offset: len(out), Length: len(code)})
+				out = append(out, code...)
+				i = end
+				continue
+			}
+
+		case c == '[' || (c == '!' && i+1 < len(src) && src[i+1] == '['):
+			image := c == '!'
+			start := i
+			if image {
+				start++
+			}
+			if textEnd, href, end, ok := matchLink(src, start); ok && depth < maxDepth {
+				inner, innerEntities, err := parseInline(src[start+1:textEnd], depth+1)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !image {
+					if !safeLink(href) {
+						return nil, nil, ErrUnsafeLink
+					}
+					if len(inner) > 0 {
+						entities = append(entities, Entity{Type: TypeLink, Offset: len(out), Length: len(inner), URL: href})
+					}
+				}
+				entities = append(entities, shift(innerEntities, len(out))...)
+				out = append(out, inner...)
+				i = end
+				continue
+			}
+
+		case c == '*' || c == '_':
+			delim := []rune{c}
+			entityType := TypeItalic
+			if c == '*' && i+1 < len(src) && src[i+1] == '*' {
+				delim = []rune{'*', '*'}
+				entityType = TypeBold
+			}
+			if end := closingDelimiter(src, i, delim); end > 0 && depth < maxDepth {
+				inner, innerEntities, err := parseInline(src[i+len(delim):end], depth+1)
+				if err != nil {
+					return nil, nil, err
+				}
+				entities = append(entities, Entity{Type: entityType, Offset: len(out), Length: len(inner)})
+				entities = append(entities, shift(innerEntities, len(out))...)
+				out = append(out, inner...)
+				i = end + len(delim) - 1
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out, entities, nil
+}
+
+// closingDelimiter finds the delimiter closing the one at src[open]. The
+// opener must be followed and the closer preceded by a non-space character;
+// underscores also have to sit on word boundaries so snake_case is left
+// alone. Escapes and code spans are skipped. It returns -1 when unclosed.
+func closingDelimiter(src []rune, open int, delim []rune) int {
+	n := len(delim)
+	start := open + n
+	if start >= len(src) || unicode.IsSpace(src[start]) {
+		return -1
+	}
+	if delim[0] == '_' && open > 0 && isWordRune(src[open-1]) {
+		return -1
+	}
+
+	for j := start; j < len(src); j++ {
+		switch {
+		case src[j] == '\\':
+			j++
+			continue
+		case src[j] == '`':
+			if end := indexRune(src, j+1, '`'); end > j+1 {
+				j = end
+			}
+			continue
+		case n == 1 && delim[0] == '*' && j+1 < len(src) && src[j] == '*' && src[j+1] == '*':
+			// A bold delimiter inside an italic span
+			j++
+			continue
+		}
+		if j+n > len(src) || string(src[j:j+n]) != string(delim) || j == start {
+			continue
+		}
+		if unicode.IsSpace(src[j-1]) {
+			continue
+		}
+		if delim[0] == '_' && j+n < len(src) && isWordRune(src[j+n]) {
+			continue
+		}
+		return j
+	}
+	return -1
+}
+
+// matchLink matches [text](url) starting at the '[' at src[open]. It returns
+// the index of the closing ']', the URL and the index of the closing ')'.
+func matchLink(src []rune, open int) (int, string, int, bool) {
+	depth := 0
+	textEnd := -1
+	for j := open; j < len(src) && textEnd < 0; j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				textEnd = j
+			}
+		}
+	}
+	if textEnd < 0 || textEnd+1 >= len(src) || src[textEnd+1] != '(' {
+		return 0, "", 0, false
+	}
+	urlEnd := indexRune(src, textEnd+2, ')')
+	if urlEnd < 0 {
+		return 0, "", 0, false
+	}
+	href := strings.TrimSpace(string(src[textEnd+2 : urlEnd]))
+	if href == "" || strings.ContainsAny(href, " \t") {
+		return 0, "", 0, false
+	}
+	return textEnd, href, urlEnd, true
+}
+
+// safeLink accepts absolute http, https and mailto URLs
+func safeLink(href string) bool {
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+func shift(entities []Entity, by int) []Entity {
+	for i := range entities {
+		entities[i].Offset += by
+	}
+	return entities
+}
+
+func indexRune(src []rune, from int, r rune) int {
+	for j := from; j < len(src); j++ {
+		if src[j] == r {
+			return j
+		}
+	}
+	return -1
+}
+
+func isPunct(r rune) bool {
+	return r < unicode.MaxASCII && unicode.IsPunct(r) || r == '`' || r == '*' || r == '_' || r == '#' || r == '+'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
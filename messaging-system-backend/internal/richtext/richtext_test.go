@@ -0,0 +1,131 @@
+package richtext_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"messaging-system-backend/internal/richtext"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		text     string
+		entities []richtext.Entity
+	}{
+		{
+			name: "plain text is unchanged",
+			src:  "see you at 5 <b>not html</b> 2 * 3 snake_case_name",
+			text: "see you at 5 <b>not html</b> 2 * 3 snake_case_name",
+		},
+		{
+			name: "bold italic and code",
+			src:  "**ship** it _today_ with `go test`",
+			text: "ship it today with go test",
+			entities: []richtext.Entity{
+				{Type: richtext.TypeBold, Offset: 0, Length: 4},
+				{Type: richtext.TypeItalic, Offset: 8, Length: 5},
+				{Type: richtext.TypeCode, Offset: 19, Length: 7},
+			},
+		},
+		{
+			name: "nested formatting inside a link",
+			src:  "read [the **new** docs](https://example.com/docs)",
+			text: "read the new docs",
+			entities: []richtext.Entity{
+				{Type: richtext.TypeLink, Offset: 5, Length: 12, URL: "https://example.com/docs"},
+				{Type: richtext.TypeBold, Offset: 9, Length: 3},
+			},
+		},
+		{
+			name: "offsets count code points",
+			src:  "héllo *wörld*",
+			text: "héllo wörld",
+			entities: []richtext.Entity{
+				{Type: richtext.TypeItalic, Offset: 6, Length: 5},
+			},
+		},
+		{
+			name: "code spans are literal",
+			src:  "`**not bold**`",
+			text: "**not bold**",
+			entities: []richtext.Entity{
+				{Type: richtext.TypeCode, Offset: 0, Length: 12},
+			},
+		},
+		{
+			name: "escapes and unclosed markers",
+			src:  `\*literal\* and *open`,
+			text: "*literal* and *open",
+		},
+		{
+			name: "code block keeps its content",
+			src:  "before\n```go\nx := *p\n```\nafter",
+			text: "before\nx := *p\nafter",
+			entities: []richtext.Entity{
+				{Type: richtext.TypePre, Offset: 7, Length: 7, Language: "go"},
+			},
+		},
+		{
+			name: "lists",
+			src:  "- milk\n* **eggs**\n2. flour",
+			text: "milk\neggs\nflour",
+			entities: []richtext.Entity{
+				{Type: richtext.TypeBulletItem, Offset: 0, Length: 4},
+				{Type: richtext.TypeBulletItem, Offset: 5, Length: 4},
+				{Type: richtext.TypeBold, Offset: 5, Length: 4},
+				{Type: richtext.TypeOrderedItem, Offset: 10, Length: 5, Number: 2},
+			},
+		},
+		{
+			name: "headings quotes and images are stripped",
+			src:  "# Title\n> quoted\n![diagram](https://example.com/d.png)",
+			text: "Title\nquoted\ndiagram",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities, err := richtext.Parse(tt.src)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if len(entities) != 0 || len(tt.entities) != 0 {
+				if !reflect.DeepEqual(entities, tt.entities) {
+					t.Errorf("entities = %+v, want %+v", entities, tt.entities)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRejectsUnsafeLinks(t *testing.T) {
+	for _, src := range []string{
+		"[click](javascript:alert(1))",
+		"[x](data:text/html;base64,PHNjcmlwdD4=)",
+		"[x](/relative/path)",
+	} {
+		if _, _, err := richtext.Parse(src); !errors.Is(err, richtext.ErrUnsafeLink) {
+			t.Errorf("Parse(%q) err = %v, want ErrUnsafeLink", src, err)
+		}
+	}
+
+	if _, _, err := richtext.Parse("[mail me](mailto:a@example.com)"); err != nil {
+		t.Errorf("mailto link rejected: %v", err)
+	}
+}
+
+func TestParseLimitsEntities(t *testing.T) {
+	src := ""
+	for i := 0; i <= richtext.MaxEntities; i++ {
+		src += "*a* "
+	}
+	if _, _, err := richtext.Parse(src); !errors.Is(err, richtext.ErrTooManyEntities) {
+		t.Fatalf("err = %v, want ErrTooManyEntities", err)
+	}
+}
@@ -0,0 +1,141 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"messaging-system-backend/internal/unfurl"
+)
+
+// DuplicateStore remembers who received each piece of text
+type DuplicateStore interface {
+	// RecordRecipient adds recipient to the people senderID sent the text
+	// with fingerprint to within window, and returns how many there are
+	RecordRecipient(ctx context.Context, senderID int, fingerprint string, recipient string, window time.Duration) (int, error)
+}
+
+// DuplicateCheck catches the same text sent to many recipients in a short
+// time. Text shorter than MinLength characters, such as "thanks", is ignored.
+type DuplicateCheck struct {
+	Store     DuplicateStore
+	Window    time.Duration
+	MinLength int
+	FlagAt    int
+	RejectAt  int
+}
+
+// Name implements Check
+func (c DuplicateCheck) Name() string { return "duplicate" }
+
+// Check implements Check
+func (c DuplicateCheck) Check(ctx context.Context, msg Message) (Result, error) {
+	if utf8.RuneCountInString(strings.TrimSpace(msg.Content)) < c.MinLength {
+		return Result{Verdict: Allow}, nil
+	}
+	count, err := c.Store.RecordRecipient(ctx, msg.SenderID, Fingerprint(msg.Content), msg.Recipient, c.Window)
+	if err != nil {
+		return Result{}, err
+	}
+	switch {
+	case count >= c.RejectAt:
+		return Result{Verdict: Reject, Reason: fmt.Sprintf("the same message was sent to %d conversations", count)}, nil
+	case count >= c.FlagAt:
+		return Result{Verdict: Flag, Reason: fmt.Sprintf("the same message was sent to %d conversations", count)}, nil
+	}
+	return Result{Verdict: Allow}, nil
+}
+
+// shortenerHosts are link shorteners, which hide where a link leads
+var shortenerHosts = map[string]bool{
+	"bit.ly":      true,
+	"buff.ly":     true,
+	"cutt.ly":     true,
+	"goo.gl":      true,
+	"is.gd":       true,
+	"ow.ly":       true,
+	"rebrand.ly":  true,
+	"t.co":        true,
+	"tinyurl.com": true,
+}
+
+// maxScoredLinks caps how many links LinkScore looks at
+const maxScoredLinks = 50
+
+// LinkScore rates how link-heavy a message is: one point per distinct link,
+// two more per link shortener and two more when links make up most of the text
+func LinkScore(content string) int {
+	links := unfurl.ExtractURLs(content, maxScoredLinks)
+	score := len(links)
+	linkLength := 0
+	for _, link := range links {
+		linkLength += len(link)
+		if u, err := url.Parse(link); err == nil && shortenerHosts[strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")] {
+			score += 2
+		}
+	}
+	if len(links) > 0 && linkLength*2 > len(strings.TrimSpace(content)) {
+		score += 2
+	}
+	return score
+}
+
+// LinkCheck flags or rejects messages by their LinkScore
+type LinkCheck struct {
+	FlagAt   int
+	RejectAt int
+}
+
+// Name implements Check
+func (c LinkCheck) Name() string { return "links" }
+
+// Check implements Check
+func (c LinkCheck) Check(ctx context.Context, msg Message) (Result, error) {
+	score := LinkScore(msg.Content)
+	switch {
+	case score >= c.RejectAt:
+		return Result{Verdict: Reject, Reason: fmt.Sprintf("message is mostly links (score %d)", score)}, nil
+	case score >= c.FlagAt:
+		return Result{Verdict: Flag, Reason: fmt.Sprintf("message is link-heavy (score %d)", score)}, nil
+	}
+	return Result{Verdict: Allow}, nil
+}
+
+// FirstContactStore counts the DM conversations a user started
+type FirstContactStore interface {
+	// FirstContacts returns how many DM conversations senderID started within window
+	FirstContacts(ctx context.Context, senderID int, window time.Duration) (int, error)
+}
+
+// FirstContactCheck limits how many people a new account can message for
+// the first time within Window. Existing conversations are not affected.
+type FirstContactCheck struct {
+	Store    FirstContactStore
+	Window   time.Duration
+	FlagAt   int
+	RejectAt int
+}
+
+// Name implements Check
+func (c FirstContactCheck) Name() string { return "first_contact" }
+
+// Check implements Check
+func (c FirstContactCheck) Check(ctx context.Context, msg Message) (Result, error) {
+	if !msg.NewAccount || !msg.FirstContact {
+		return Result{Verdict: Allow}, nil
+	}
+	count, err := c.Store.FirstContacts(ctx, msg.SenderID, c.Window)
+	if err != nil {
+		return Result{}, err
+	}
+	switch {
+	case count >= c.RejectAt:
+		return Result{Verdict: Reject, Reason: fmt.Sprintf("new accounts can start at most %d new conversations for now", c.RejectAt)}, nil
+	case count >= c.FlagAt:
+		return Result{Verdict: Flag, Reason: fmt.Sprintf("new account is starting its %d. new conversation", count+1)}, nil
+	}
+	return Result{Verdict: Allow}, nil
+}
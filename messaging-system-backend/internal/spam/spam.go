@@ -0,0 +1,121 @@
+// Package spam runs content checks on messages before they are stored. Each
+// check allows, flags or rejects a message with a reason; flagged messages
+// are delivered and queued for moderation, rejected ones are not stored.
+package spam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Verdict is the outcome of a check
+type Verdict string
+
+// Verdicts a check can return
+const (
+	Allow  Verdict = "allow"
+	Flag   Verdict = "flag"
+	Reject Verdict = "reject"
+)
+
+// Message is a message about to be stored. Recipient names the DM receiver
+// or the group, such as "dm:3" or "group:4".
+type Message struct {
+	SenderID  int
+	Recipient string
+	Content   string
+	// FirstContact is set for a DM to someone the sender has never messaged
+	FirstContact bool
+	// NewAccount is set while the sender's account is still new
+	NewAccount bool
+}
+
+// Result is the verdict of a check, or of a whole pipeline, with the name of
+// the check that decided it
+type Result struct {
+	Verdict Verdict
+	Check   string
+	Reason  string
+}
+
+// Check inspects a message before it is stored
+type Check interface {
+	// Name identifies the check in configuration and in the moderation queue
+	Name() string
+	Check(ctx context.Context, msg Message) (Result, error)
+}
+
+// Pipeline runs checks in order
+type Pipeline struct {
+	checks []Check
+}
+
+// NewPipeline returns a pipeline running checks in the given order
+func NewPipeline(checks ...Check) *Pipeline {
+	return &Pipeline{checks: checks}
+}
+
+// Run returns the first reject, or else the first flag, or else allow. A
+// check that fails is skipped so an outage does not stop messaging; its error
+// is returned alongside the result.
+func (p *Pipeline) Run(ctx context.Context, msg Message) (Result, error) {
+	result := Result{Verdict: Allow}
+	var errs []error
+	for _, check := range p.checks {
+		r, err := check.Check(ctx, msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", check.Name(), err))
+			continue
+		}
+		r.Check = check.Name()
+		switch {
+		case r.Verdict == Reject:
+			return r, errors.Join(errs...)
+		case r.Verdict == Flag && result.Verdict == Allow:
+			result = r
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// Enabled picks checks by a comma-separated list of names, keeping the order
+// of checks. An empty list enables every check and "none" disables them all.
+// Unknown names are returned so the caller can report them.
+func Enabled(checks []Check, names string) ([]Check, []string) {
+	names = strings.TrimSpace(names)
+	if names == "" {
+		return checks, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" && name != "none" {
+			wanted[name] = true
+		}
+	}
+
+	var enabled []Check
+	for _, check := range checks {
+		if wanted[check.Name()] {
+			enabled = append(enabled, check)
+			delete(wanted, check.Name())
+		}
+	}
+	var unknown []string
+	for name := range wanted {
+		unknown = append(unknown, name)
+	}
+	return enabled, unknown
+}
+
+// Fingerprint identifies message text regardless of case and spacing, so
+// copies of the same text sent to different people match
+func Fingerprint(content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
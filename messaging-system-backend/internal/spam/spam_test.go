@@ -0,0 +1,205 @@
+package spam_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"messaging-system-backend/internal/spam"
+)
+
+// fixedCheck returns the same result for every message
+type fixedCheck struct {
+	name    string
+	verdict spam.Verdict
+	err     error
+	calls   *int
+}
+
+func (c fixedCheck) Name() string { return c.name }
+
+func (c fixedCheck) Check(ctx context.Context, msg spam.Message) (spam.Result, error) {
+	if c.calls != nil {
+		*c.calls++
+	}
+	return spam.Result{Verdict: c.verdict, Reason: c.name + " said " + string(c.verdict)}, c.err
+}
+
+// fakeDuplicates keeps recipients per sender and fingerprint in memory
+type fakeDuplicates map[string]map[string]bool
+
+func (f fakeDuplicates) RecordRecipient(ctx context.Context, senderID int, fingerprint string, recipient string, window time.Duration) (int, error) {
+	if f[fingerprint] == nil {
+		f[fingerprint] = make(map[string]bool)
+	}
+	f[fingerprint][recipient] = true
+	return len(f[fingerprint]), nil
+}
+
+// fakeFirstContacts reports a fixed number of started conversations
+type fakeFirstContacts int
+
+func (f fakeFirstContacts) FirstContacts(ctx context.Context, senderID int, window time.Duration) (int, error) {
+	return int(f), nil
+}
+
+func TestPipelineRejectWinsAndStops(t *testing.T) {
+	var after int
+	p := spam.NewPipeline(
+		fixedCheck{name: "a", verdict: spam.Flag},
+		fixedCheck{name: "b", verdict: spam.Reject},
+		fixedCheck{name: "c", verdict: spam.Reject, calls: &after},
+	)
+	result, err := p.Run(context.Background(), spam.Message{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Verdict != spam.Reject || result.Check != "b" {
+		t.Errorf("result = %+v, want reject from b", result)
+	}
+	if after != 0 {
+		t.Errorf("check after the reject ran %d times", after)
+	}
+}
+
+func TestPipelineKeepsFirstFlagAndSkipsFailingChecks(t *testing.T) {
+	p := spam.NewPipeline(
+		fixedCheck{name: "a", verdict: spam.Allow},
+		fixedCheck{name: "broken", verdict: spam.Reject, err: errors.New("store down")},
+		fixedCheck{name: "b", verdict: spam.Flag},
+		fixedCheck{name: "c", verdict: spam.Flag},
+	)
+	result, err := p.Run(context.Background(), spam.Message{})
+	if err == nil {
+		t.Error("expected the failing check's error")
+	}
+	if result.Verdict != spam.Flag || result.Check != "b" {
+		t.Errorf("result = %+v, want flag from b", result)
+	}
+
+	result, err = spam.NewPipeline().Run(context.Background(), spam.Message{})
+	if err != nil || result.Verdict != spam.Allow {
+		t.Errorf("empty pipeline = %+v, %v, want allow", result, err)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	checks := []spam.Check{
+		fixedCheck{name: "duplicate"},
+		fixedCheck{name: "links"},
+		fixedCheck{name: "first_contact"},
+	}
+	names := func(checks []spam.Check) []string {
+		var out []string
+		for _, c := range checks {
+			out = append(out, c.Name())
+		}
+		return out
+	}
+
+	if got, _ := spam.Enabled(checks, ""); len(got) != 3 {
+		t.Errorf("empty list enabled %v, want all", names(got))
+	}
+	if got, _ := spam.Enabled(checks, "none"); len(got) != 0 {
+		t.Errorf("none enabled %v", names(got))
+	}
+	got, unknown := spam.Enabled(checks, " first_contact, duplicate ,bogus")
+	if want := []string{"duplicate", "first_contact"}; !reflect.DeepEqual(names(got), want) {
+		t.Errorf("enabled %v, want %v", names(got), want)
+	}
+	if !reflect.DeepEqual(unknown, []string{"bogus"}) {
+		t.Errorf("unknown = %v", unknown)
+	}
+}
+
+func TestFingerprintIgnoresCaseAndSpacing(t *testing.T) {
+	a := spam.Fingerprint("Win a FREE  phone\ntoday")
+	b := spam.Fingerprint("  win a free phone today ")
+	if a != b {
+		t.Error("fingerprints differ for the same text")
+	}
+	if a == spam.Fingerprint("win a free phone tomorrow") {
+		t.Error("fingerprints match for different text")
+	}
+}
+
+func TestDuplicateCheck(t *testing.T) {
+	check := spam.DuplicateCheck{Store: fakeDuplicates{}, Window: time.Minute, MinLength: 10, FlagAt: 3, RejectAt: 4}
+	ctx := context.Background()
+	text := "Check out my new crypto giveaway"
+
+	var verdicts []spam.Verdict
+	for _, recipient := range []string{"dm:1", "dm:2", "dm:2", "group:7", "dm:3"} {
+		result, err := check.Check(ctx, spam.Message{SenderID: 9, Recipient: recipient, Content: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		verdicts = append(verdicts, result.Verdict)
+	}
+	want := []spam.Verdict{spam.Allow, spam.Allow, spam.Allow, spam.Flag, spam.Reject}
+	if !reflect.DeepEqual(verdicts, want) {
+		t.Errorf("verdicts = %v, want %v", verdicts, want)
+	}
+
+	for i := 0; i < 5; i++ {
+		result, _ := check.Check(ctx, spam.Message{SenderID: 9, Recipient: "dm:" + string(rune('a'+i)), Content: "thanks!"})
+		if result.Verdict != spam.Allow {
+			t.Fatalf("short message got %v", result.Verdict)
+		}
+	}
+}
+
+func TestLinkScore(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int
+	}{
+		{"no links here", 0},
+		{"the notes are at https://example.com/notes if you need them later today", 1},
+		{"https://example.com", 3},
+		{"look https://bit.ly/x and https://www.tinyurl.com/y and https://example.com/z", 9},
+	}
+	for _, tt := range tests {
+		if got := spam.LinkScore(tt.content); got != tt.want {
+			t.Errorf("LinkScore(%q) = %d, want %d", tt.content, got, tt.want)
+		}
+	}
+
+	check := spam.LinkCheck{FlagAt: 3, RejectAt: 6}
+	for content, want := range map[string]spam.Verdict{
+		"no links here":       spam.Allow,
+		"https://example.com": spam.Flag,
+		"look https://bit.ly/x and https://www.tinyurl.com/y and https://example.com/z": spam.Reject,
+	} {
+		if result, _ := check.Check(context.Background(), spam.Message{Content: content}); result.Verdict != want {
+			t.Errorf("LinkCheck(%q) = %v, want %v", content, result.Verdict, want)
+		}
+	}
+}
+
+func TestFirstContactCheck(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		started int
+		msg     spam.Message
+		want    spam.Verdict
+	}{
+		{9, spam.Message{NewAccount: false, FirstContact: true}, spam.Allow},
+		{9, spam.Message{NewAccount: true, FirstContact: false}, spam.Allow},
+		{2, spam.Message{NewAccount: true, FirstContact: true}, spam.Allow},
+		{5, spam.Message{NewAccount: true, FirstContact: true}, spam.Flag},
+		{10, spam.Message{NewAccount: true, FirstContact: true}, spam.Reject},
+	}
+	for _, tt := range tests {
+		check := spam.FirstContactCheck{Store: fakeFirstContacts(tt.started), Window: 24 * time.Hour, FlagAt: 5, RejectAt: 10}
+		result, err := check.Check(ctx, tt.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Verdict != tt.want {
+			t.Errorf("started %d, %+v: got %v, want %v", tt.started, tt.msg, result.Verdict, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"messaging-system-backend/internal/storage"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+
+	content := "hello attachment"
+	if err := store.Put(ctx, "2025/07/28/abc", strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	r, err := store.Get(ctx, "2025/07/28/abc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != content {
+		t.Errorf("Expected %q, got %q", content, got)
+	}
+
+	if err := store.Delete(ctx, "2025/07/28/abc"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Get(ctx, "2025/07/28/abc"); !errors.Is(err, storage.ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound after delete, got %v", err)
+	}
+}
+
+func TestLocalStoreRejectsEscapingKeys(t *testing.T) {
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	for _, key := range []string{"../outside", "/etc/passwd", "a/../../outside", ""} {
+		if err := store.Put(context.Background(), key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+			t.Errorf("Expected key %q to be rejected", key)
+		}
+	}
+}
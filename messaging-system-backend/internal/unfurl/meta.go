@@ -0,0 +1,172 @@
+package unfurl
+
+import (
+	"html"
+	"strings"
+)
+
+// document is the metadata found in the head of an HTML page
+type document struct {
+	Title string
+	// Meta maps lower-cased meta property or name keys to their first content value
+	Meta map[string]string
+}
+
+// first returns the first non-empty meta value among keys
+func (d document) first(keys ...string) string {
+	for _, key := range keys {
+		if value := d.Meta[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseHead scans an HTML document for its <title> and <meta> tags. It is a
+// small tolerant scanner rather than a full HTML parser: it stops at </head>
+// or <body>, skips comments, scripts and styles, and decodes entities.
+func parseHead(src string) document {
+	doc := document{Meta: make(map[string]string)}
+	lower := asciiLower(src)
+
+	i := 0
+	for {
+		start := strings.IndexByte(src[i:], '<')
+		if start < 0 {
+			return doc
+		}
+		i += start
+
+		switch {
+		case strings.HasPrefix(src[i:], "<!--"):
+			end := strings.Index(src[i+4:], "-->")
+			if end < 0 {
+				return doc
+			}
+			i += 4 + end + 3
+			continue
+		case strings.HasPrefix(src[i:], "<!"), strings.HasPrefix(src[i:], "<?"), strings.HasPrefix(src[i:], "</"):
+			if strings.HasPrefix(lower[i:], "</head") {
+				return doc
+			}
+			end := strings.IndexByte(src[i:], '>')
+			if end < 0 {
+				return doc
+			}
+			i += end + 1
+			continue
+		}
+
+		name, attrs, next := parseTag(src, i+1)
+		i = next
+		switch name {
+		case "body":
+			return doc
+		case "meta":
+			key := strings.ToLower(strings.TrimSpace(attrs["property"]))
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(attrs["name"]))
+			}
+			if _, ok := doc.Meta[key]; key != "" && !ok {
+				doc.Meta[key] = cleanText(attrs["content"])
+			}
+		case "title", "script", "style":
+			end := strings.Index(lower[i:], "</"+name)
+			if end < 0 {
+				return doc
+			}
+			if name == "title" && doc.Title == "" {
+				doc.Title = cleanText(src[i : i+end])
+			}
+			i += end
+		}
+	}
+}
+
+// parseTag reads the tag name and attributes of a start tag beginning at
+// src[i] (just after '<') and returns the index after its closing '>'
+func parseTag(src string, i int) (string, map[string]string, int) {
+	start := i
+	for i < len(src) && isNameByte(src[i]) {
+		i++
+	}
+	name := strings.ToLower(src[start:i])
+	attrs := make(map[string]string)
+
+	for i < len(src) {
+		for i < len(src) && (isSpace(src[i]) || src[i] == '/') {
+			i++
+		}
+		if i >= len(src) {
+			break
+		}
+		if src[i] == '>' {
+			return name, attrs, i + 1
+		}
+
+		keyStart := i
+		for i < len(src) && !isSpace(src[i]) && src[i] != '=' && src[i] != '>' && src[i] != '/' {
+			i++
+		}
+		key := strings.ToLower(src[keyStart:i])
+		for i < len(src) && isSpace(src[i]) {
+			i++
+		}
+		if i >= len(src) || src[i] != '=' {
+			if key != "" {
+				attrs[key] = ""
+			}
+			continue
+		}
+		i++
+		for i < len(src) && isSpace(src[i]) {
+			i++
+		}
+
+		var value string
+		if i < len(src) && (src[i] == '"' || src[i] == '\'') {
+			quote := src[i]
+			end := strings.IndexByte(src[i+1:], quote)
+			if end < 0 {
+				value, i = src[i+1:], len(src)
+			} else {
+				value, i = src[i+1:i+1+end], i+1+end+1
+			}
+		} else {
+			valueStart := i
+			for i < len(src) && !isSpace(src[i]) && src[i] != '>' {
+				i++
+			}
+			value = src[valueStart:i]
+		}
+		if _, ok := attrs[key]; key != "" && !ok {
+			attrs[key] = value
+		}
+	}
+	return name, attrs, len(src)
+}
+
+// cleanText decodes HTML entities and collapses runs of whitespace
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// asciiLower lower-cases ASCII letters only, so byte offsets in the result
+// match the original string
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-' || b == ':'
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
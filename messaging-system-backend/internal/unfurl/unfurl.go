@@ -0,0 +1,234 @@
+// Package unfurl fetches OpenGraph and Twitter-card metadata for link
+// previews. The fetcher only connects to public addresses and caps
+// redirects, response size and time, so message authors cannot use it to
+// reach internal services.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultTimeout      = 5 * time.Second
+	defaultMaxRedirects = 3
+	defaultMaxBytes     = 512 << 10
+	defaultUserAgent    = "MessagingSystemBot/1.0 (link preview)"
+
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+)
+
+var (
+	// ErrInvalidURL is returned for URLs that are not absolute http or https links
+	ErrInvalidURL = errors.New("unfurl: invalid url")
+	// ErrBlockedAddress is returned when a URL resolves to a non-public address
+	ErrBlockedAddress = errors.New("unfurl: address is not public")
+	// ErrTooManyRedirects is returned when a page redirects more than allowed
+	ErrTooManyRedirects = errors.New("unfurl: too many redirects")
+	// ErrNotHTML is returned when a URL does not serve an HTML page
+	ErrNotHTML = errors.New("unfurl: not an html page")
+	// ErrRobotsDisallowed is returned when the page opts out of previews with a robots tag
+	ErrRobotsDisallowed = errors.New("unfurl: page disallows previews")
+	// ErrNoPreview is returned when a page has no title, description or image
+	ErrNoPreview = errors.New("unfurl: no preview metadata")
+)
+
+// Preview is the metadata shown for a link
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// Options configure a Fetcher. Zero values use the defaults: 5 seconds,
+// 3 redirects, 512 KB and only public addresses.
+type Options struct {
+	Timeout      time.Duration
+	MaxRedirects int
+	MaxBytes     int64
+	UserAgent    string
+	// AllowIP decides which resolved addresses may be dialed. Tests can
+	// widen it to reach a local server.
+	AllowIP func(net.IP) bool
+}
+
+// Fetcher downloads pages and extracts their preview metadata
+type Fetcher struct {
+	client    *http.Client
+	maxBytes  int64
+	userAgent string
+}
+
+// NewFetcher creates a Fetcher. Addresses are checked when each connection
+// is dialed, after DNS resolution, so redirects and DNS rebinding cannot
+// reach a blocked address.
+func NewFetcher(opts Options) *Fetcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = defaultMaxRedirects
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultMaxBytes
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = defaultUserAgent
+	}
+	if opts.AllowIP == nil {
+		opts.AllowIP = IsPublicIP
+	}
+
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !opts.AllowIP(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// Never go through an environment proxy: it would dial on our behalf
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > opts.MaxRedirects {
+					return ErrTooManyRedirects
+				}
+				return checkURL(req.URL)
+			},
+		},
+		maxBytes:  opts.MaxBytes,
+		userAgent: opts.UserAgent,
+	}
+}
+
+// checkURL accepts absolute http and https URLs only
+func checkURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// Fetch downloads rawURL and returns its preview. OpenGraph tags take
+// precedence over Twitter-card tags, which take precedence over the plain
+// <title> and description.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unfurl: unexpected status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+	for _, tag := range resp.Header.Values("X-Robots-Tag") {
+		if disallowsPreview(tag) {
+			return nil, ErrRobotsDisallowed
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	doc := parseHead(string(body))
+	if disallowsPreview(doc.Meta["robots"]) {
+		return nil, ErrRobotsDisallowed
+	}
+
+	preview := &Preview{
+		URL:         rawURL,
+		Title:       truncate(doc.first("og:title", "twitter:title"), maxTitleLength),
+		Description: truncate(doc.first("og:description", "twitter:description", "description"), maxDescriptionLength),
+		SiteName:    doc.first("og:site_name"),
+	}
+	if preview.Title == "" {
+		preview.Title = truncate(doc.Title, maxTitleLength)
+	}
+	if image := doc.first("og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src"); image != "" {
+		if ref, err := resp.Request.URL.Parse(image); err == nil && checkURL(ref) == nil {
+			preview.ImageURL = ref.String()
+		}
+	}
+	if preview.Title == "" && preview.Description == "" && preview.ImageURL == "" {
+		return nil, ErrNoPreview
+	}
+	if preview.SiteName == "" {
+		preview.SiteName = resp.Request.URL.Hostname()
+	}
+	return preview, nil
+}
+
+// disallowsPreview reports whether a robots directive list opts out of
+// snippets: noindex, nosnippet or none
+func disallowsPreview(directives string) bool {
+	for _, d := range strings.FieldsFunc(strings.ToLower(directives), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if d == "noindex" || d == "nosnippet" || d == "none" {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate shortens s to at most max runes
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:max]))
+}
@@ -0,0 +1,212 @@
+package unfurl_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"messaging-system-backend/internal/unfurl"
+)
+
+// localFetcher can reach the loopback httptest server
+func localFetcher(opts unfurl.Options) *unfurl.Fetcher {
+	opts.AllowIP = func(ip net.IP) bool { return ip.IsLoopback() }
+	return unfurl.NewFetcher(opts)
+}
+
+func serveHTML(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(body))
+	}
+}
+
+func TestFetchOpenGraph(t *testing.T) {
+	srv := httptest.NewServer(serveHTML(`<!doctype html>
+<html><head>
+<title>Fallback title</title>
+<!-- <meta property="og:title" content="commented out"> -->
+<meta property="og:title" content="Release notes &amp; changes">
+<meta name="twitter:title" content="Twitter title">
+<meta property='og:description' content='What shipped   this week'>
+<meta property="og:image" content="/img/cover.png">
+<meta property="og:site_name" content="Example Blog">
+<script>var s = "<meta property='og:image' content='evil'>";</script>
+</head><body><meta property="og:title" content="in body"></body></html>`))
+	defer srv.Close()
+
+	preview, err := localFetcher(unfurl.Options{}).Fetch(context.Background(), srv.URL+"/post")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	want := &unfurl.Preview{
+		URL:         srv.URL + "/post",
+		Title:       "Release notes & changes",
+		Description: "What shipped this week",
+		ImageURL:    srv.URL + "/img/cover.png",
+		SiteName:    "Example Blog",
+	}
+	if !reflect.DeepEqual(preview, want) {
+		t.Errorf("got %+v, want %+v", preview, want)
+	}
+}
+
+func TestFetchTwitterCardFallback(t *testing.T) {
+	srv := httptest.NewServer(serveHTML(`<html><head>
+<meta name="twitter:card" content="summary">
+<meta name="twitter:title" content="Card title">
+<meta name="description" content="Plain description">
+<meta name="twitter:image" content="https://cdn.example.com/card.jpg">
+</head></html>`))
+	defer srv.Close()
+
+	preview, err := localFetcher(unfurl.Options{}).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if preview.Title != "Card title" || preview.Description != "Plain description" ||
+		preview.ImageURL != "https://cdn.example.com/card.jpg" {
+		t.Errorf("unexpected preview %+v", preview)
+	}
+	if preview.SiteName != "127.0.0.1" {
+		t.Errorf("site name = %q, want the host", preview.SiteName)
+	}
+}
+
+func TestFetchRespectsRobots(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/header" {
+			w.Header().Set("X-Robots-Tag", "noindex")
+		}
+		if r.URL.Path == "/meta" {
+			w.Write([]byte(`<meta name="robots" content="index, nosnippet">`))
+		}
+		w.Write([]byte(`<meta property="og:title" content="Secret">`))
+	}))
+	defer srv.Close()
+
+	fetcher := localFetcher(unfurl.Options{})
+	for _, path := range []string{"/header", "/meta"} {
+		if _, err := fetcher.Fetch(context.Background(), srv.URL+path); !errors.Is(err, unfurl.ErrRobotsDisallowed) {
+			t.Errorf("%s: err = %v, want ErrRobotsDisallowed", path, err)
+		}
+	}
+}
+
+func TestFetchBlocksLoopbackByDefault(t *testing.T) {
+	srv := httptest.NewServer(serveHTML(`<title>internal</title>`))
+	defer srv.Close()
+
+	_, err := unfurl.NewFetcher(unfurl.Options{}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, unfurl.ErrBlockedAddress) {
+		t.Fatalf("err = %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchCapsRedirects(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, srv.URL+r.URL.Path+"x", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	_, err := localFetcher(unfurl.Options{MaxRedirects: 2}).Fetch(context.Background(), srv.URL+"/r")
+	if !errors.Is(err, unfurl.ErrTooManyRedirects) {
+		t.Fatalf("err = %v, want ErrTooManyRedirects", err)
+	}
+}
+
+func TestFetchRejectsRedirectToOtherScheme(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	_, err := localFetcher(unfurl.Options{}).Fetch(context.Background(), srv.URL)
+	if err == nil {
+		t.Fatal("expected redirect to file:// to fail")
+	}
+}
+
+func TestFetchCapsBodySize(t *testing.T) {
+	padding := strings.Repeat("x", 4096)
+	srv := httptest.NewServer(serveHTML(`<html><head><title>` + padding + `</title>
+<meta property="og:title" content="Too far down"></head></html>`))
+	defer srv.Close()
+
+	_, err := localFetcher(unfurl.Options{MaxBytes: 1024}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, unfurl.ErrNoPreview) {
+		t.Fatalf("err = %v, want ErrNoPreview for a truncated page", err)
+	}
+}
+
+func TestFetchTimesOut(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		serveHTML(`<title>slow</title>`)(w, r)
+	}))
+	defer srv.Close()
+
+	_, err := localFetcher(unfurl.Options{Timeout: 100 * time.Millisecond}).Fetch(context.Background(), srv.URL)
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title":"nope"}`))
+	}))
+	defer srv.Close()
+
+	_, err := localFetcher(unfurl.Options{}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, unfurl.ErrNotHTML) {
+		t.Fatalf("err = %v, want ErrNotHTML", err)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"8.8.8.8":         true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+		"64:ff9b::a00:1":  false,
+	}
+	for addr, want := range tests {
+		if got := unfurl.IsPublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("IsPublicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	text := "see https://example.com/a, (and http://example.org/wiki/Go_(language)) or " +
+		"https://example.com/a again; ftp://x.y and https://third.example/ https://fourth.example"
+	got := unfurl.ExtractURLs(text, 3)
+	want := []string{
+		"https://example.com/a",
+		"http://example.org/wiki/Go_(language)",
+		"https://third.example/",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package unfurl
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// urlPattern finds http and https links in message text
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'` + "`" + `]+`)
+
+// trailingPunctuation is stripped from the end of a link, since it usually
+// belongs to the surrounding sentence
+const trailingPunctuation = ".,:;!?'\""
+
+// ExtractURLs returns up to max distinct http and https URLs in text, in the
+// order they appear
+func ExtractURLs(text string, max int) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		if len(urls) == max {
+			break
+		}
+		match = trimLink(match)
+		u, err := url.Parse(match)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		if !seen[match] {
+			seen[match] = true
+			urls = append(urls, match)
+		}
+	}
+	return urls
+}
+
+// trimLink drops trailing punctuation and a closing parenthesis that has no
+// opening partner inside the link
+func trimLink(link string) string {
+	for {
+		trimmed := strings.TrimRight(link, trailingPunctuation)
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == link {
+			return link
+		}
+		link = trimmed
+	}
+}
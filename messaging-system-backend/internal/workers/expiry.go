@@ -0,0 +1,39 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/controllers"
+)
+
+// expiryBatchSize is how many expired messages of each kind are deleted per transaction
+const expiryBatchSize = 500
+
+// RunExpiryReaper hard-deletes expired disappearing messages in batches until
+// ctx is cancelled. Rows are claimed with SKIP LOCKED, so several app
+// instances can run it at once.
+func RunExpiryReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			purged, err := controllers.PurgeExpiredMessages(ctx, expiryBatchSize)
+			if err != nil {
+				log.Printf("Expiry reaper error: %v", err)
+				break
+			}
+			if purged == 0 {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/controllers"
+)
+
+// RunIdempotencyCleanup deletes expired send idempotency keys until ctx is cancelled
+func RunIdempotencyCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := controllers.PurgeExpiredIdempotencyKeys(ctx); err != nil {
+			log.Printf("Idempotency cleanup error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
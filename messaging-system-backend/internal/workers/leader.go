@@ -0,0 +1,76 @@
+package workers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Leader decides whether this app instance should run a singleton job
+type Leader interface {
+	// IsLeader acquires or renews leadership and reports whether this instance holds it
+	IsLeader(ctx context.Context) (bool, error)
+}
+
+// renewLeaseScript extends the lease only if this instance still owns it
+var renewLeaseScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+// releaseLeaseScript deletes the lease only if this instance still owns it
+var releaseLeaseScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+// RedisLeader elects a single leader across app instances with a Redis lease
+// key holding a random per-instance token. The lease expires after ttl unless
+// renewed, so a crashed leader is replaced within one ttl.
+type RedisLeader struct {
+	client *redis.Client
+	key    string
+	token  string
+	ttl    time.Duration
+}
+
+// NewRedisLeader returns a leader elector for the lease key
+func NewRedisLeader(client *redis.Client, key string, ttl time.Duration) *RedisLeader {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return &RedisLeader{
+		client: client,
+		key:    key,
+		token:  hex.EncodeToString(buf),
+		ttl:    ttl,
+	}
+}
+
+// IsLeader takes the lease when it is free or renews it when already held
+func (l *RedisLeader) IsLeader(ctx context.Context) (bool, error) {
+	acquired, err := l.client.SetNX(ctx, l.key, l.token, l.ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	if acquired {
+		return true, nil
+	}
+
+	renewed, err := renewLeaseScript.Run(ctx, l.client, []string{l.key}, l.token, l.ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+// Release gives up the lease so another instance can take over immediately
+func (l *RedisLeader) Release(ctx context.Context) error {
+	return releaseLeaseScript.Run(ctx, l.client, []string{l.key}, l.token).Err()
+}
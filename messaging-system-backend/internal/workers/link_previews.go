@@ -0,0 +1,42 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/controllers"
+	"messaging-system-backend/internal/unfurl"
+)
+
+// linkPreviewBatchSize is how many links are claimed at a time
+const linkPreviewBatchSize = 10
+
+// RunLinkPreviewWorker unfurls links in newly sent messages until ctx is
+// cancelled. Pages are fetched with the SSRF-safe unfurl fetcher.
+func RunLinkPreviewWorker(ctx context.Context, interval time.Duration) {
+	fetcher := unfurl.NewFetcher(unfurl.Options{})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			processed, err := controllers.ProcessLinkPreviews(ctx, fetcher, linkPreviewBatchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Link preview worker error: %v", err)
+				}
+				break
+			}
+			if processed < linkPreviewBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const scheduledBatchSize = 50
+
+// ScheduledStore loads and sends scheduled messages
+type ScheduledStore interface {
+	// DueScheduledMessages returns the IDs of pending messages with send_at at or before now
+	DueScheduledMessages(ctx context.Context, now time.Time, limit int) ([]int, error)
+	// DispatchScheduledMessage sends one pending message, or marks it failed
+	// when it can no longer be delivered
+	DispatchScheduledMessage(ctx context.Context, id int, now time.Time) error
+}
+
+// ScheduledDispatcher delivers scheduled messages once they are due. Only
+// the elected leader dispatches, so each message is sent by one instance.
+type ScheduledDispatcher struct {
+	store  ScheduledStore
+	leader Leader
+	now    func() time.Time
+}
+
+// NewScheduledDispatcher returns a dispatcher reading the current time from now
+func NewScheduledDispatcher(store ScheduledStore, leader Leader, now func() time.Time) *ScheduledDispatcher {
+	return &ScheduledDispatcher{store: store, leader: leader, now: now}
+}
+
+// Run dispatches due messages every interval until ctx is cancelled
+func (d *ScheduledDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.Tick(ctx); err != nil {
+			log.Printf("Scheduled message dispatcher error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick sends every message that is due when this instance is the leader and
+// returns how many were dispatched. A message that fails to send is logged
+// and retried on the next tick.
+func (d *ScheduledDispatcher) Tick(ctx context.Context) (int, error) {
+	isLeader, err := d.leader.IsLeader(ctx)
+	if err != nil || !isLeader {
+		return 0, err
+	}
+
+	dispatched := 0
+	for {
+		now := d.now()
+		ids, err := d.store.DueScheduledMessages(ctx, now, scheduledBatchSize)
+		if err != nil {
+			return dispatched, err
+		}
+
+		sent := 0
+		for _, id := range ids {
+			if err := d.store.DispatchScheduledMessage(ctx, id, now); err != nil {
+				log.Printf("Failed to dispatch scheduled message %d: %v", id, err)
+				continue
+			}
+			sent++
+		}
+		dispatched += sent
+
+		// Stop when the batch was not full or nothing could be sent, so a
+		// failing message cannot keep the loop spinning
+		if len(ids) < scheduledBatchSize || sent == 0 {
+			return dispatched, nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package workers_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"messaging-system-backend/internal/workers"
+)
+
+// fakeClock is a manually advanced clock
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// fakeLeader reports a fixed leadership state
+type fakeLeader struct{ leader bool }
+
+func (l *fakeLeader) IsLeader(ctx context.Context) (bool, error) { return l.leader, nil }
+
+// fakeStore keeps pending messages in memory keyed by ID
+type fakeStore struct {
+	pending    map[int]time.Time
+	failing    map[int]bool
+	dispatched []int
+}
+
+func (s *fakeStore) DueScheduledMessages(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	var ids []int
+	for id, sendAt := range s.pending {
+		if !sendAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (s *fakeStore) DispatchScheduledMessage(ctx context.Context, id int, now time.Time) error {
+	if s.failing[id] {
+		return errors.New("delivery failed")
+	}
+	if sendAt := s.pending[id]; sendAt.After(now) {
+		return errors.New("dispatched before send_at")
+	}
+	delete(s.pending, id)
+	s.dispatched = append(s.dispatched, id)
+	return nil
+}
+
+func TestScheduledDispatcherSendsWhenDue(t *testing.T) {
+	start := time.Date(2025, 7, 28, 9, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	store := &fakeStore{pending: map[int]time.Time{
+		1: start.Add(time.Minute),
+		2: start.Add(5 * time.Minute),
+		3: start.Add(time.Minute),
+	}}
+	d := workers.NewScheduledDispatcher(store, &fakeLeader{leader: true}, clock.Now)
+	ctx := context.Background()
+
+	if n, err := d.Tick(ctx); err != nil || n != 0 {
+		t.Fatalf("Tick before due = %d, %v; want 0, nil", n, err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if n, err := d.Tick(ctx); err != nil || n != 2 {
+		t.Fatalf("Tick after 2m = %d, %v; want 2, nil", n, err)
+	}
+	if !reflect.DeepEqual(store.dispatched, []int{1, 3}) {
+		t.Fatalf("dispatched = %v, want [1 3]", store.dispatched)
+	}
+
+	clock.Advance(3 * time.Minute)
+	if n, err := d.Tick(ctx); err != nil || n != 1 {
+		t.Fatalf("Tick after 5m = %d, %v; want 1, nil", n, err)
+	}
+	if !reflect.DeepEqual(store.dispatched, []int{1, 3, 2}) {
+		t.Fatalf("dispatched = %v, want [1 3 2]", store.dispatched)
+	}
+}
+
+func TestScheduledDispatcherOnlyLeaderSends(t *testing.T) {
+	start := time.Date(2025, 7, 28, 9, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	store := &fakeStore{pending: map[int]time.Time{1: start}}
+	leader := &fakeLeader{leader: false}
+	d := workers.NewScheduledDispatcher(store, leader, clock.Now)
+
+	if n, _ := d.Tick(context.Background()); n != 0 || len(store.dispatched) != 0 {
+		t.Fatalf("follower dispatched %v", store.dispatched)
+	}
+
+	leader.leader = true
+	if n, _ := d.Tick(context.Background()); n != 1 {
+		t.Fatalf("leader dispatched %d messages, want 1", n)
+	}
+}
+
+func TestScheduledDispatcherSkipsFailures(t *testing.T) {
+	start := time.Date(2025, 7, 28, 9, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	store := &fakeStore{
+		pending: map[int]time.Time{1: start, 2: start},
+		failing: map[int]bool{1: true},
+	}
+	d := workers.NewScheduledDispatcher(store, &fakeLeader{leader: true}, clock.Now)
+
+	if n, err := d.Tick(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Tick = %d, %v; want 1, nil", n, err)
+	}
+	if _, ok := store.pending[1]; !ok {
+		t.Fatal("failed message should stay pending for a retry")
+	}
+}
@@ -0,0 +1,39 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/controllers"
+)
+
+// syncCompactionBatchSize is how many sync events are deleted per statement
+const syncCompactionBatchSize = 5000
+
+// RunSyncCompactor deletes sync events older than the retention period until
+// ctx is cancelled. Clients whose since falls in the deleted range are asked
+// to do a full resync.
+func RunSyncCompactor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			deleted, err := controllers.CompactSyncEvents(ctx, syncCompactionBatchSize)
+			if err != nil {
+				log.Printf("Sync compactor error: %v", err)
+				break
+			}
+			if deleted < syncCompactionBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"messaging-system-backend/internal/database"
+	"messaging-system-backend/internal/imaging"
+	"messaging-system-backend/internal/storage"
+)
+
+// ThumbnailSizes are the longest-side pixel sizes generated for image attachments
+var ThumbnailSizes = []int{64, 320, 800}
+
+const (
+	thumbnailBatchSize = 5
+	// thumbnailStaleAfter requeues jobs whose worker died mid-way
+	thumbnailStaleAfter = 10 * time.Minute
+	maxSourceImageBytes = 25 << 20
+)
+
+// thumbnailJob is an image attachment waiting for thumbnails
+type thumbnailJob struct {
+	ID          int
+	StorageKey  string
+	ContentType string
+}
+
+// RunThumbnailWorker generates thumbnails and blurhash placeholders for
+// newly uploaded images until ctx is cancelled. Several app instances can run
+// it at once; rows are claimed with SKIP LOCKED so each image is processed once.
+func RunThumbnailWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			processed, err := processThumbnailBatch(ctx)
+			if err != nil {
+				log.Printf("Thumbnail worker error: %v", err)
+				break
+			}
+			if processed < thumbnailBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processThumbnailBatch claims and processes up to thumbnailBatchSize images
+func processThumbnailBatch(ctx context.Context) (int, error) {
+	rows, err := database.DB.QueryContext(ctx, `
+		UPDATE attachments
+		SET processing_status = 'processing', processing_started_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM attachments
+			WHERE processing_status = 'pending'
+			   OR (processing_status = 'processing' AND processing_started_at < $1)
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, storage_key, content_type
+	`, time.Now().Add(-thumbnailStaleAfter), thumbnailBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var jobs []thumbnailJob
+	for rows.Next() {
+		var job thumbnailJob
+		if err := rows.Scan(&job.ID, &job.StorageKey, &job.ContentType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, job := range jobs {
+		status := "done"
+		if err := processThumbnailJob(ctx, job); err != nil {
+			log.Printf("Failed to process attachment %d: %v", job.ID, err)
+			status = "failed"
+		}
+		if _, err := database.DB.ExecContext(ctx, `
+			UPDATE attachments SET processing_status = $2 WHERE id = $1
+		`, job.ID, status); err != nil {
+			return 0, err
+		}
+	}
+	return len(jobs), nil
+}
+
+// processThumbnailJob decodes one image, stores its thumbnails and records
+// the dimensions and blurhash placeholder
+func processThumbnailJob(ctx context.Context, job thumbnailJob) error {
+	body, err := storage.Blobs.Get(ctx, job.StorageKey)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxSourceImageBytes))
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	img, err := imaging.Decode(data, job.ContentType)
+	if err != nil {
+		return err
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	for _, size := range ThumbnailSizes {
+		if width <= size && height <= size {
+			break
+		}
+		thumb := imaging.Fit(img, size)
+		encoded, contentType, err := imaging.Encode(thumb, job.ContentType)
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("%s_thumb_%d", job.StorageKey, size)
+		if err := storage.Blobs.Put(ctx, key, bytes.NewReader(encoded), int64(len(encoded)), contentType); err != nil {
+			return err
+		}
+
+		_, err = database.DB.ExecContext(ctx, `
+			INSERT INTO attachment_thumbnails (attachment_id, size, storage_key, content_type, width, height, size_bytes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (attachment_id, size) DO UPDATE
+			SET storage_key = EXCLUDED.storage_key, content_type = EXCLUDED.content_type,
+			    width = EXCLUDED.width, height = EXCLUDED.height, size_bytes = EXCLUDED.size_bytes
+		`, job.ID, size, key, contentType, thumb.Bounds().Dx(), thumb.Bounds().Dy(), len(encoded))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = database.DB.ExecContext(ctx, `
+		UPDATE attachments SET width = $2, height = $3, blurhash = $4 WHERE id = $1
+	`, job.ID, width, height, imaging.BlurHash(img, 4, 3))
+	return err
+}